@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/evaluation"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	if err := run(); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// run 执行评测，返回错误而不是直接退出，保证临时目录和数据库在失败时同样会被清理
+func run() error {
+	datasetDir := flag.String("dataset", "", "带标签的数据集目录，每个子目录为一个类别（必需）")
+	ks := flag.String("k", "1,5,10", "需要统计的 k 值，多个用逗号分隔")
+	mapDepth := flag.Int("map-depth", service.MaxSearchLimit, "计算 mAP 时检索的结果数量")
+	jsonPath := flag.String("json", "", "JSON 报告输出路径，为 - 时输出到标准输出")
+	workDir := flag.String("workdir", "", "评测使用的数据库和图片目录，默认使用临时目录并在结束后删除")
+	logLevel := flag.String("log-level", "warn", "日志级别")
+	flag.Parse()
+
+	config.SetupLogger(&config.LogConfig{Level: *logLevel})
+
+	if *datasetDir == "" {
+		fmt.Fprintln(os.Stderr, "请使用 -dataset 指定数据集目录")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	kValues, err := parseKs(*ks)
+	if err != nil {
+		return fmt.Errorf("解析 k 值失败: %w", err)
+	}
+
+	samples, err := evaluation.LoadDataset(*datasetDir)
+	if err != nil {
+		return fmt.Errorf("读取数据集失败: %w", err)
+	}
+
+	// 评测使用独立的数据库和图片目录，避免污染正在使用的数据
+	dir := *workDir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "imagesearch-evaluate-")
+		if err != nil {
+			return fmt.Errorf("创建临时目录失败: %w", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	db, err := repository.NewDatabase(filepath.Join(dir, "evaluate.db"))
+	if err != nil {
+		return fmt.Errorf("连接数据库失败: %w", err)
+	}
+	defer db.Close()
+	// 关闭 SQL 日志，避免与报告输出混在一起
+	db.DB = db.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err := db.AutoMigrate(); err != nil {
+		return fmt.Errorf("自动迁移数据库表结构失败: %w", err)
+	}
+
+	blobStore, err := storage.NewLocalStore(filepath.Join(dir, "images"))
+	if err != nil {
+		return fmt.Errorf("初始化对象存储失败: %w", err)
+	}
+
+	cfg, err := evaluationConfig()
+	if err != nil {
+		return err
+	}
+	imageRepo := repository.NewImageRepository(db)
+	imageService := service.NewImageService(imageRepo, blobStore, cfg)
+
+	report, err := evaluation.Run(imageService, imageRepo, samples, evaluation.Options{
+		Ks:       kValues,
+		MapDepth: *mapDepth,
+	})
+	if err != nil {
+		return fmt.Errorf("评测失败: %w", err)
+	}
+
+	if *jsonPath != "" {
+		if err := writeJSON(*jsonPath, report); err != nil {
+			return fmt.Errorf("输出 JSON 报告失败: %w", err)
+		}
+	}
+	if *jsonPath != "-" {
+		if err := report.WriteTable(os.Stdout); err != nil {
+			return fmt.Errorf("输出报告失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// evaluationConfig 返回评测使用的配置，不读取环境变量，评测结果不受运行环境中的配额、流水线、回收站等配置影响
+// 不生成衍生图片、不限制上传大小和配额，嵌入向量按默认流水线生成，与服务的默认配置可比
+func evaluationConfig() (*config.Config, error) {
+	steps, err := config.ParsePipeline(config.DefaultPipeline)
+	if err != nil {
+		return nil, fmt.Errorf("解析默认流水线失败: %w", err)
+	}
+	return &config.Config{
+		Storage: config.StorageConfig{
+			Backend:       "local",
+			DedupMode:     service.DedupModeReuse,
+			SVGRasterSize: 1024,
+		},
+		Pipeline: config.PipelineConfig{Steps: steps},
+		Search: config.SearchConfig{
+			BatchConcurrency: 4,
+			BatchMaxQueries:  100,
+			MaxFrames:        16,
+		},
+	}, nil
+}
+
+// parseKs 解析逗号分隔的 k 值
+func parseKs(value string) ([]int, error) {
+	var ks []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, err := strconv.Atoi(part)
+		if err != nil || k < 1 {
+			return nil, fmt.Errorf("无效的 k 值: %s", part)
+		}
+		ks = append(ks, k)
+	}
+	if len(ks) == 0 {
+		return nil, fmt.Errorf("至少需要一个 k 值")
+	}
+	return ks, nil
+}
+
+// writeJSON 将报告以 JSON 格式写入文件或标准输出
+func writeJSON(path string, report *evaluation.Report) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
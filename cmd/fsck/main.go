@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "修复发现的问题")
+	verifyChecksums := flag.Bool("verify-checksums", false, "读取全部原图并校验 SHA-256")
+	deleteOrphans := flag.Bool("delete-orphans", false, "修复时直接删除孤立文件，默认移动到隔离区")
+	grace := flag.Duration("grace", 10*time.Minute, "修改时间在宽限期内的孤立文件不报告，0 表示不设宽限期")
+	jsonOutput := flag.Bool("json", false, "以 JSON 格式输出报告")
+	logLevel := flag.String("log-level", "warn", "日志级别")
+	flag.Parse()
+
+	config.SetupLogger(&config.LogConfig{Level: *logLevel})
+
+	// 使用与服务相同的配置，直接检查服务使用的数据库和对象存储
+	cfg := config.LoadConfig()
+
+	db, err := repository.NewDatabase(cfg.Database.DSN)
+	if err != nil {
+		logrus.Fatalf("连接数据库失败: %v", err)
+	}
+	defer db.Close()
+	// 关闭 SQL 日志，避免与报告输出混在一起
+	db.DB = db.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err := db.AutoMigrate(); err != nil {
+		logrus.Fatalf("自动迁移数据库表结构失败: %v", err)
+	}
+
+	blobStore, err := storage.New(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("初始化对象存储失败: %v", err)
+	}
+
+	imageRepo := repository.NewImageRepository(db)
+	imageService := service.NewImageService(imageRepo, blobStore, cfg)
+
+	opts := service.FsckOptions{
+		Repair:          *repair,
+		VerifyChecksums: *verifyChecksums,
+		DeleteOrphans:   *deleteOrphans,
+		GracePeriod:     *grace,
+	}
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = -1
+	}
+
+	report, err := imageService.Fsck(opts)
+	if err != nil {
+		logrus.Fatalf("一致性检查失败: %v", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logrus.Fatalf("输出 JSON 报告失败: %v", err)
+		}
+	} else if err := writeTable(os.Stdout, report); err != nil {
+		logrus.Fatalf("输出报告失败: %v", err)
+	}
+
+	// 存在未修复的问题时以非零状态退出，便于在定时任务中告警
+	if report.Unresolved() > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeTable 以表格形式输出报告
+func writeTable(out io.Writer, report *service.FsckReport) error {
+	fmt.Fprintf(out, "文件: %d  图片: %d  衍生图片: %d  嵌入向量: %d  文件记录: %d  耗时: %.0fms\n",
+		report.Files, report.Images, report.Renditions, report.Embeddings, report.Blobs, report.DurationMs)
+
+	if len(report.Issues) == 0 {
+		fmt.Fprintln(out, "未发现问题")
+		return nil
+	}
+
+	kinds := make([]string, 0, len(report.Counts))
+	for kind := range report.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(out, "发现 %d 个问题:", len(report.Issues))
+	for _, kind := range kinds {
+		fmt.Fprintf(out, " %s=%d", kind, report.Counts[kind])
+	}
+	fmt.Fprintln(out)
+	if report.Repair {
+		fmt.Fprintf(out, "已修复 %d 个，修复失败 %d 个\n", report.Repaired, report.Failed)
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "类型\t对象键\t图片ID\t说明\t修复")
+	for _, issue := range report.Issues {
+		status := "-"
+		switch {
+		case issue.Repaired:
+			status = issue.Action
+		case issue.Error != "":
+			status = "失败: " + issue.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", issue.Kind, orDash(issue.Key), orDash(issue.ImageID), issue.Detail, status)
+	}
+	return w.Flush()
+}
+
+// orDash 空字符串显示为 -
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "只统计需要重新加密的文件，不修改")
+	jsonOutput := flag.Bool("json", false, "以 JSON 格式输出报告")
+	logLevel := flag.String("log-level", "warn", "日志级别")
+	flag.Parse()
+
+	config.SetupLogger(&config.LogConfig{Level: *logLevel})
+
+	// 使用与服务相同的配置，ENCRYPTION_KEY_FILE 中需要同时包含旧密钥和新密钥
+	cfg := config.LoadConfig()
+
+	db, err := repository.NewDatabase(cfg.Database.DSN)
+	if err != nil {
+		logrus.Fatalf("连接数据库失败: %v", err)
+	}
+	defer db.Close()
+	// 关闭 SQL 日志，避免与报告输出混在一起
+	db.DB = db.DB.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+
+	if err := db.AutoMigrate(); err != nil {
+		logrus.Fatalf("自动迁移数据库表结构失败: %v", err)
+	}
+
+	blobStore, err := storage.New(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("初始化对象存储失败: %v", err)
+	}
+
+	imageRepo := repository.NewImageRepository(db)
+	imageService := service.NewImageService(imageRepo, blobStore, cfg)
+
+	report, err := imageService.RotateKeys(*dryRun)
+	if err != nil {
+		logrus.Fatalf("密钥轮换失败: %v", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			logrus.Fatalf("输出 JSON 报告失败: %v", err)
+		}
+	} else {
+		writeSummary(os.Stdout, report)
+	}
+
+	// 存在重新加密失败的文件时以非零状态退出，此时不能从密钥文件中删除旧密钥
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeSummary 以文本形式输出报告
+func writeSummary(out io.Writer, report *service.KeyRotationReport) {
+	action := "已重新加密"
+	if report.DryRun {
+		action = "需要重新加密"
+	}
+	fmt.Fprintf(out, "当前密钥: %s  文件: %d  %s: %d  失败: %d  耗时: %.0fms\n",
+		report.KeyID, report.Files, action, report.Reencrypted, report.Failed, report.DurationMs)
+
+	keyIDs := make([]string, 0, len(report.Keys))
+	for keyID := range report.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	fmt.Fprint(out, "轮换前各密钥的文件数量:")
+	for _, keyID := range keyIDs {
+		fmt.Fprintf(out, " %s=%d", keyID, report.Keys[keyID])
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "图片: %d  更新密钥ID: %d\n", report.Images, report.ImagesUpdated)
+
+	for _, e := range report.Errors {
+		fmt.Fprintf(out, "失败 %s: %s\n", e.Key, e.Error)
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"syscall"
 
 	"github.com/bytedance/ImageSearch/internal/api"
+	"github.com/bytedance/ImageSearch/internal/cache"
 	"github.com/bytedance/ImageSearch/internal/config"
 	"github.com/bytedance/ImageSearch/internal/repository"
 	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -37,11 +39,50 @@ func main() {
 	// 初始化仓库
 	imageRepo := repository.NewImageRepository(db)
 
+	// 初始化对象存储
+	blobStore, err := storage.New(cfg.Storage)
+	if err != nil {
+		logrus.Fatalf("初始化对象存储失败: %v", err)
+	}
+
 	// 初始化服务
-	imageService := service.NewImageService(imageRepo, cfg.Storage.ImageDir)
+	imageService := service.NewImageService(imageRepo, blobStore, cfg)
+
+	// 清理上次运行崩溃时残留的未提交上传
+	recovery, err := imageService.RecoverUploads()
+	if err != nil {
+		logrus.Fatalf("恢复未完成的上传失败: %v", err)
+	}
+	if recovery.Uploads > 0 || recovery.TempFiles > 0 {
+		logrus.Warnf("已清理 %d 个未完成的上传，删除 %d 个未提交的文件和 %d 个临时文件",
+			recovery.Uploads, recovery.Blobs, recovery.TempFiles)
+	}
+
+	// 后台任务：定期永久删除回收站中超过保留期的图片，按保留策略删除过期图片
+	var tasks []*service.PeriodicTask
+	if cfg.Trash.Retention > 0 && cfg.Trash.PurgeInterval > 0 {
+		tasks = append(tasks, service.NewTrashPurger(imageService, cfg.Trash.PurgeInterval))
+	}
+	if len(cfg.Quota.Retention) > 0 && cfg.Quota.RetentionInterval > 0 {
+		tasks = append(tasks, service.NewRetentionEnforcer(imageService, cfg.Quota.RetentionInterval))
+	}
+	for _, task := range tasks {
+		task.Start()
+	}
+
+	// 初始化图片处理缓存和服务
+	renderCache, err := cache.NewDiskCache(cfg.Render.CacheDir, cfg.Render.CacheMaxBytes)
+	if err != nil {
+		logrus.Fatalf("初始化图片处理缓存失败: %v", err)
+	}
+	// 启用静态加密时，缓存的处理结果同样加密保存
+	if sealer, ok := blobStore.(cache.Sealer); ok {
+		renderCache.SetSealer(sealer)
+	}
+	renderService := service.NewRenderService(imageRepo, blobStore, renderCache, cfg)
 
 	// 初始化API处理器
-	handler := api.NewHandler(imageService, cfg.Storage.ImageDir)
+	handler := api.NewHandler(imageService, renderService, blobStore, cfg)
 
 	// 设置Gin模式
 	if cfg.Log.Level == "debug" {
@@ -74,6 +115,11 @@ func main() {
 
 	logrus.Info("正在关闭服务器...")
 
+	// 等待正在执行的后台任务完成
+	for _, task := range tasks {
+		task.Stop()
+	}
+
 	// 关闭数据库连接
 	sqlDB, err := db.DB.DB()
 	if err != nil {
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// requireAdmin 校验管理接口的访问令牌，支持 Authorization: Bearer <token> 和 X-Admin-Token 请求头
+func (h *Handler) requireAdmin(c *gin.Context) {
+	if h.adminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+			Error: "管理接口未启用，请配置 ADMIN_TOKEN",
+		})
+		return
+	}
+
+	if !h.hasAdminToken(c) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "无效的管理令牌",
+		})
+		return
+	}
+	c.Next()
+}
+
+// hasAdminToken 判断请求是否携带了有效的管理令牌，没有配置 ADMIN_TOKEN 时总是返回 false
+func (h *Handler) hasAdminToken(c *gin.Context) bool {
+	if h.adminToken == "" {
+		return false
+	}
+	token := c.GetHeader("X-Admin-Token")
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// Fsck 存储一致性检查
+// @Summary 存储一致性检查
+// @Description 检查对象存储、图片记录和嵌入向量之间的一致性，报告孤立文件、缺失文件、缺失或格式错误的嵌入向量、校验和不一致等问题，可选地修复
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Param repair query bool false "是否修复发现的问题"
+// @Param verify_checksums query bool false "是否读取原图校验 SHA-256"
+// @Param delete_orphans query bool false "修复时直接删除孤立文件，默认移动到隔离区"
+// @Param grace query string false "孤立文件宽限期，如 10m，0 表示不设宽限期"
+// @Success 200 {object} service.FsckReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/fsck [post]
+func (h *Handler) Fsck(c *gin.Context) {
+	opts, err := parseFsckOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	report, err := h.imageService.Fsck(opts)
+	if err != nil {
+		logrus.Errorf("一致性检查失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// parseFsckOptions 解析一致性检查选项
+func parseFsckOptions(c *gin.Context) (service.FsckOptions, error) {
+	var opts service.FsckOptions
+
+	for _, param := range []struct {
+		key   string
+		value *bool
+	}{
+		{"repair", &opts.Repair},
+		{"verify_checksums", &opts.VerifyChecksums},
+		{"delete_orphans", &opts.DeleteOrphans},
+	} {
+		if v := searchParam(c, param.key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("无效的 %s 参数: %s", param.key, v)
+			}
+			*param.value = b
+		}
+	}
+
+	if v := searchParam(c, "grace"); v != "" {
+		grace, err := time.ParseDuration(v)
+		if err != nil || grace < 0 {
+			return opts, fmt.Errorf("无效的 grace 参数: %s", v)
+		}
+		// 0 表示不设宽限期
+		if grace == 0 {
+			grace = -1
+		}
+		opts.GracePeriod = grace
+	}
+	return opts, nil
+}
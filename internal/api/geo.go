@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultGeoRadius 指定 near 但没有指定 radius 时使用的半径（米）
+const defaultGeoRadius = 5000
+
+// parseGeoFilter 解析地理位置过滤参数，没有指定时返回 nil
+// near=纬度,经度 与 radius（默认 5km）表示圆形范围，bbox=最小经度,最小纬度,最大经度,最大纬度 表示矩形范围
+func parseGeoFilter(c *gin.Context) (*repository.GeoFilter, error) {
+	filter := &repository.GeoFilter{}
+
+	if v := searchParam(c, "near"); v != "" {
+		coords, err := parseCoordinates(v, 2)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 near 参数: %s", v)
+		}
+		filter.Near = &repository.GeoCircle{Lat: coords[0], Lon: coords[1], Radius: defaultGeoRadius}
+
+		if v := searchParam(c, "radius"); v != "" {
+			radius, err := parseDistance(v)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 radius 参数: %s", v)
+			}
+			filter.Near.Radius = radius
+		}
+	} else if v := searchParam(c, "radius"); v != "" {
+		return nil, fmt.Errorf("radius 参数需要与 near 参数一起使用")
+	}
+
+	if v := searchParam(c, "bbox"); v != "" {
+		coords, err := parseCoordinates(v, 4)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 bbox 参数: %s", v)
+		}
+		filter.Within = &repository.GeoBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+	}
+
+	if filter.Near == nil && filter.Within == nil {
+		return nil, nil
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseCoordinates 解析逗号分隔的 n 个数字
+func parseCoordinates(v string, n int) ([]float64, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("需要 %d 个数字", n)
+	}
+	coords := make([]float64, n)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		coords[i] = f
+	}
+	return coords, nil
+}
+
+// parseDistance 解析距离，支持 m 和 km 单位，没有单位时为米
+func parseDistance(v string) (float64, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(v, "km"):
+		v, scale = strings.TrimSuffix(v, "km"), 1000
+	case strings.HasSuffix(v, "m"):
+		v = strings.TrimSuffix(v, "m")
+	}
+	distance, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || !(distance > 0) {
+		return 0, fmt.Errorf("无效的距离: %s", v)
+	}
+	return distance * scale, nil
+}
@@ -1,12 +1,21 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"path/filepath"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/bytedance/ImageSearch/internal/config"
 	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
 	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -14,15 +23,34 @@ import (
 
 // Handler API处理器
 type Handler struct {
-	imageService service.ImageService
-	imageDir     string
+	imageService  service.ImageService
+	renderService service.RenderService
+	blobs         storage.BlobStore
+	adminToken    string
+	// maxUploadBytes 单个图片文件的最大字节数，用于限制上传和搜索请求的请求体
+	maxUploadBytes int64
+	// batchMaxQueries 批量搜索的最大查询数量，用于计算批量搜索请求体的上限
+	batchMaxQueries int
+	// metadataPolicy 返回图片文件时保留的元数据
+	metadataPolicy string
+	// renditions 衍生图片配置，用于识别不需要删除元数据的衍生图片文件
+	renditions []config.RenditionConfig
+	// publicLocation 是否向没有管理令牌的请求返回拍摄地点
+	publicLocation bool
 }
 
 // NewHandler 创建API处理器
-func NewHandler(imageService service.ImageService, imageDir string) *Handler {
+func NewHandler(imageService service.ImageService, renderService service.RenderService, blobs storage.BlobStore, cfg *config.Config) *Handler {
 	return &Handler{
-		imageService: imageService,
-		imageDir:     imageDir,
+		imageService:    imageService,
+		renderService:   renderService,
+		blobs:           blobs,
+		adminToken:      cfg.Server.AdminToken,
+		maxUploadBytes:  cfg.Upload.MaxBytes,
+		batchMaxQueries: cfg.Search.BatchMaxQueries,
+		metadataPolicy:  cfg.Privacy.MetadataPolicy,
+		renditions:      cfg.Storage.Renditions,
+		publicLocation:  cfg.Privacy.PublicLocation,
 	}
 }
 
@@ -31,8 +59,12 @@ func (h *Handler) RegisterRoutes(router *gin.Engine) {
 	// 根路径处理程序
 	router.GET("/", h.RootHandler)
 
-	// 静态文件服务
-	router.Static("/images", h.imageDir)
+	// 图片文件服务
+	router.GET("/images/:key", h.ServeImageFile)
+	router.HEAD("/images/:key", h.ServeImageFile)
+
+	// IIIF Image API
+	h.registerIIIFRoutes(router)
 
 	// API路由组
 	api := router.Group("/api")
@@ -44,7 +76,27 @@ func (h *Handler) RegisterRoutes(router *gin.Engine) {
 			images.GET("", h.ListImages)
 			images.GET("/:id", h.GetImage)
 			images.DELETE("/:id", h.DeleteImage)
+			images.GET("/:id/similar", h.SearchSimilarImages)
+			images.GET("/:id/render", h.RenderImage)
+			images.HEAD("/:id/render", h.RenderImage)
 			images.POST("/search", h.SearchImages)
+			images.POST("/search/batch", h.SearchImagesBatch)
+		}
+
+		// 回收站，列出和永久删除需要管理令牌，恢复与删除图片一样不需要
+		trash := api.Group("/trash")
+		{
+			trash.GET("", h.requireAdmin, h.ListTrash)
+			trash.POST("/:id/restore", h.RestoreImage)
+			trash.DELETE("/:id", h.requireAdmin, h.PurgeImage)
+		}
+
+		// 管理接口，需要配置 ADMIN_TOKEN
+		admin := api.Group("/admin", h.requireAdmin)
+		{
+			admin.POST("/fsck", h.Fsck)
+			admin.GET("/usage", h.Usage)
+			admin.POST("/retention", h.ApplyRetention)
 		}
 	}
 
@@ -72,11 +124,25 @@ func (h *Handler) RootHandler(c *gin.Context) {
 			"base_url": "/api",
 			"endpoints": map[string]interface{}{
 				"images": map[string]string{
-					"upload": "POST /api/images",
-					"list":   "GET /api/images",
-					"get":    "GET /api/images/:id",
-					"delete": "DELETE /api/images/:id",
-					"search": "POST /api/images/search",
+					"upload":  "POST /api/images",
+					"list":    "GET /api/images",
+					"get":     "GET /api/images/:id",
+					"delete":  "DELETE /api/images/:id",
+					"search":  "POST /api/images/search",
+					"batch":   "POST /api/images/search/batch",
+					"similar": "GET /api/images/:id/similar",
+					"render":  "GET /api/images/:id/render",
+				},
+				"trash": map[string]string{
+					"list":    "GET /api/trash",
+					"restore": "POST /api/trash/:id/restore",
+					"purge":   "DELETE /api/trash/:id",
+				},
+				"iiif": "GET /iiif/3/:id/info.json",
+				"admin": map[string]string{
+					"fsck":      "POST /api/admin/fsck",
+					"usage":     "GET /api/admin/usage",
+					"retention": "POST /api/admin/retention",
 				},
 				"health": "GET /health",
 			},
@@ -84,22 +150,206 @@ func (h *Handler) RootHandler(c *gin.Context) {
 	})
 }
 
+// ServeImageFile 从对象存储读取并返回图片文件
+// @Summary 访问图片文件
+// @Description 根据对象键返回图片文件内容，位图原图按 PRIVACY_METADATA_POLICY 删除 EXIF、XMP 等元数据，无法按文件结构删除时重新编码，结果缓存在磁盘上；衍生图片直接返回；支持 Range 和条件请求
+// @Tags 图片
+// @Produce image/jpeg,image/png,image/gif,image/webp,image/tiff,image/svg+xml
+// @Param key path string true "对象键"
+// @Success 200 {file} binary
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /images/{key} [get]
+func (h *Handler) ServeImageFile(c *gin.Context) {
+	key := c.Param("key")
+
+	// 原图按策略删除 EXIF、XMP 等元数据后返回，对象存储中的原图保持不变，处理结果缓存在磁盘上
+	// 衍生图片由编码器生成，不包含元数据，与 SVG 一样直接流式返回
+	if !strings.EqualFold(path.Ext(key), ".svg") && !service.IsRenditionKey(key, h.renditions) {
+		h.servePublicImage(c, key)
+		return
+	}
+
+	reader, info, err := h.blobs.Get(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "图片不存在",
+			})
+			return
+		}
+		logrus.Errorf("读取图片文件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "读取图片文件失败",
+		})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Type", info.ContentType)
+	// SVG 上传时已清理脚本和外部引用，这里再禁止浏览器执行脚本和加载外部资源，防止同源 XSS
+	if info.ContentType == "image/svg+xml" {
+		c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox")
+		c.Header("X-Content-Type-Options", "nosniff")
+	}
+
+	// 可随机读取的对象交给 http.ServeContent 处理 Range 和条件请求
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, info.ModTime, seeker)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, reader, nil)
+}
+
+// servePublicImage 返回删除元数据后的原图
+func (h *Handler) servePublicImage(c *gin.Context, key string) {
+	public, err := h.renderService.PublicImage(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey):
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "图片不存在",
+			})
+		case errors.Is(err, service.ErrDecodeBusy):
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error: err.Error(),
+			})
+		default:
+			// 既无法删除元数据也无法重新编码时不返回文件，避免泄露拍摄地点等信息
+			logrus.Errorf("删除图片元数据失败 %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "处理图片文件失败",
+			})
+		}
+		return
+	}
+	c.Header("Content-Type", public.ContentType)
+	http.ServeContent(c.Writer, c.Request, key, public.ModTime, bytes.NewReader(public.Data))
+}
+
+// RenderImage 实时处理图片
+// @Summary 获取指定尺寸和格式的图片
+// @Description 从原图缩放、裁剪并转换格式，结果按参数缓存在磁盘上；未指定 format 时根据 Accept 请求头选择输出格式；支持 If-None-Match 和 If-Modified-Since 条件请求
+// @Tags 图片
+// @Produce image/jpeg,image/png
+// @Param id path string true "图片ID"
+// @Param w query int false "目标宽度"
+// @Param h query int false "目标高度"
+// @Param fit query string false "同时指定宽高时的缩放方式：cover、contain、fill，默认 contain"
+// @Param format query string false "输出格式：jpeg、png，默认根据 Accept 请求头选择，否则与原图相同（原图不是 JPEG 或 PNG 时为 png）"
+// @Param q query int false "JPEG 输出质量 1-100，默认85"
+// @Success 200 {file} binary
+// @Success 304
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 406 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/images/{id}/render [get]
+func (h *Handler) RenderImage(c *gin.Context) {
+	// 解析图片ID
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		logrus.Errorf("解析图片ID失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "无效的图片ID",
+		})
+		return
+	}
+
+	// 解析处理参数
+	opts, err := parseRenderOptions(c)
+	if err != nil {
+		logrus.Errorf("解析图片处理参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// 未指定输出格式时根据 Accept 请求头协商
+	if opts.Format == "" {
+		format, ok := negotiateImageFormat(c.GetHeader("Accept"))
+		if !ok {
+			c.JSON(http.StatusNotAcceptable, ErrorResponse{
+				Error: "只能输出 image/jpeg 或 image/png",
+			})
+			return
+		}
+		opts.Format = format
+		c.Header("Vary", "Accept")
+	}
+
+	// 处理图片
+	rendered, err := h.renderService.RenderImage(id, opts)
+	if err != nil {
+		logrus.Errorf("处理图片失败: %v", err)
+		switch {
+		case errors.Is(err, service.ErrImageNotFound), errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "图片不存在",
+			})
+		case errors.Is(err, service.ErrInvalidRenderOptions):
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "处理图片失败",
+			})
+		}
+		return
+	}
+
+	// 处理结果由原图内容和参数唯一确定，可以长期缓存
+	c.Header("Content-Type", rendered.ContentType)
+	c.Header("ETag", rendered.ETag)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	if rendered.Cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	http.ServeContent(c.Writer, c.Request, "", rendered.ModTime, bytes.NewReader(rendered.Data))
+}
+
 // UploadImage 上传图片
 // @Summary 上传图片
-// @Description 上传一张图片并生成嵌入向量
+// @Description 上传一张图片并生成嵌入向量，支持 JPEG、PNG、GIF、WebP、BMP 和 TIFF，BMP 转换为 PNG 保存
 // @Tags 图片
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "图片文件"
+// @Param namespace formData string false "命名空间，也可以通过 X-Namespace 请求头指定，默认 default"
 // @Success 200 {object} model.Image
 // @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} QuotaErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Failure 507 {object} QuotaErrorResponse
 // @Router /api/images [post]
 func (h *Handler) UploadImage(c *gin.Context) {
+	if !h.limitRequestBody(c, 1) {
+		return
+	}
+
 	// 获取上传的文件
 	file, fileHeader, err := c.Request.FormFile("file")
 	if err != nil {
 		logrus.Errorf("获取上传文件失败: %v", err)
+		if isRequestTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error: errRequestTooLarge.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: "请选择要上传的图片文件",
 		})
@@ -108,8 +358,26 @@ func (h *Handler) UploadImage(c *gin.Context) {
 	defer file.Close()
 
 	// 上传图片
-	image, err := h.imageService.UploadImage(file, fileHeader)
+	namespace := c.PostForm("namespace")
+	if namespace == "" {
+		namespace = c.GetHeader("X-Namespace")
+	}
+	image, err := h.imageService.UploadImage(file, fileHeader, namespace)
 	if err != nil {
+		var quotaErr *service.QuotaError
+		if errors.As(err, &quotaErr) {
+			writeQuotaError(c, quotaErr)
+			return
+		}
+		if errors.Is(err, service.ErrInvalidNamespace) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+		if writeImageError(c, err) {
+			return
+		}
 		logrus.Errorf("上传图片失败: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: err.Error(),
@@ -117,13 +385,14 @@ func (h *Handler) UploadImage(c *gin.Context) {
 		return
 	}
 
-	// 返回结果
+	// 返回结果，没有权限时不返回拍摄地点
+	h.redactImage(c, image)
 	c.JSON(http.StatusOK, image)
 }
 
 // GetImage 获取图片
 // @Summary 获取图片信息
-// @Description 根据ID获取图片信息
+// @Description 根据ID获取图片信息，包含衍生图片和从 EXIF 提取的拍摄信息
 // @Tags 图片
 // @Produce json
 // @Param id path string true "图片ID"
@@ -154,18 +423,25 @@ func (h *Handler) GetImage(c *gin.Context) {
 		return
 	}
 
-	// 返回结果
+	// 返回结果，没有权限时不返回拍摄地点
+	h.redactImage(c, image)
 	c.JSON(http.StatusOK, image)
 }
 
 // ListImages 列出图片
 // @Summary 列出图片
-// @Description 分页列出所有图片
+// @Description 分页列出所有图片，可以按拍摄地点过滤，指定 near 时按距离由近到远排列
 // @Tags 图片
 // @Produce json
 // @Param page query int false "页码，默认1"
 // @Param page_size query int false "每页大小，默认10"
+// @Param namespace query string false "只列出指定命名空间的图片"
+// @Param near query string false "中心点坐标，格式为 纬度,经度"
+// @Param radius query string false "与中心点的最大距离，支持 m、km 单位，默认5km"
+// @Param bbox query string false "矩形范围，格式为 最小经度,最小纬度,最大经度,最大纬度"
 // @Success 200 {object} ListImagesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /api/images [get]
 func (h *Handler) ListImages(c *gin.Context) {
@@ -173,8 +449,21 @@ func (h *Handler) ListImages(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 
+	// 解析地理位置过滤参数
+	geo, err := parseGeoFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if !h.checkGeoAccess(c, geo) {
+		return
+	}
+
 	// 获取图片列表
-	images, total, err := h.imageService.ListImages(page, pageSize)
+	filter := repository.ListFilter{Namespace: c.Query("namespace"), Geo: geo}
+	images, total, err := h.imageService.ListImages(page, pageSize, filter)
 	if err != nil {
 		logrus.Errorf("获取图片列表失败: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -184,6 +473,7 @@ func (h *Handler) ListImages(c *gin.Context) {
 	}
 
 	// 返回结果
+	h.redactImages(c, images)
 	c.JSON(http.StatusOK, ListImagesResponse{
 		Images: images,
 		Total:  total,
@@ -194,7 +484,7 @@ func (h *Handler) ListImages(c *gin.Context) {
 
 // DeleteImage 删除图片
 // @Summary 删除图片
-// @Description 根据ID删除图片
+// @Description 根据ID删除图片，配置了 TRASH_RETENTION 时移入回收站，可在保留期内恢复
 // @Tags 图片
 // @Produce json
 // @Param id path string true "图片ID"
@@ -217,6 +507,12 @@ func (h *Handler) DeleteImage(c *gin.Context) {
 
 	// 删除图片
 	if err := h.imageService.DeleteImage(id); err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "图片不存在",
+			})
+			return
+		}
 		logrus.Errorf("删除图片失败: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "删除图片失败",
@@ -237,15 +533,44 @@ func (h *Handler) DeleteImage(c *gin.Context) {
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "搜索用的图片文件"
+// @Param limit formData int false "返回结果数量，默认10，最大100"
+// @Param max_distance formData number false "最大距离阈值"
+// @Param extension formData string false "图片格式过滤，多个用逗号分隔"
+// @Param min_width formData int false "最小宽度"
+// @Param min_height formData int false "最小高度"
+// @Param namespace formData string false "只返回指定命名空间的图片"
+// @Param near formData string false "只返回拍摄地点在中心点附近的图片，格式为 纬度,经度"
+// @Param radius formData string false "与中心点的最大距离，支持 m、km 单位，默认5km"
+// @Param bbox formData string false "只返回拍摄地点在矩形范围内的图片，格式为 最小经度,最小纬度,最大经度,最大纬度"
+// @Param diversify formData bool false "是否使用 MMR 多样化重排"
+// @Param lambda formData number false "MMR 相关性权重 (0, 1]，默认0.7"
+// @Param collapse formData bool false "是否折叠近似重复结果"
+// @Param collapse_threshold formData number false "近似重复判定距离，默认0.02"
+// @Param explain formData bool false "是否返回搜索过程的详细信息"
 // @Success 200 {object} SearchImagesResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
 // @Router /api/images/search [post]
 func (h *Handler) SearchImages(c *gin.Context) {
+	if !h.limitRequestBody(c, 1) {
+		return
+	}
+
 	// 获取上传的文件
 	file, _, err := c.Request.FormFile("file")
 	if err != nil {
 		logrus.Errorf("获取上传文件失败: %v", err)
+		if isRequestTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error: errRequestTooLarge.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: "请选择要搜索的图片文件",
 		})
@@ -253,10 +578,167 @@ func (h *Handler) SearchImages(c *gin.Context) {
 	}
 	defer file.Close()
 
+	// 解析搜索选项
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		logrus.Errorf("解析搜索参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if !h.checkGeoAccess(c, opts.Geo) {
+		return
+	}
+
 	// 搜索相似图片
-	images, distances, err := h.imageService.SearchImagesByImage(file)
+	outcome, err := h.imageService.SearchImagesByImage(file, opts)
 	if err != nil {
 		logrus.Errorf("搜索相似图片失败: %v", err)
+		if writeImageError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// 返回结果
+	h.redactOutcome(c, outcome)
+	c.JSON(http.StatusOK, buildSearchResponse(outcome))
+}
+
+// SearchSimilarImages 以图搜图（已入库图片）
+// @Summary 搜索与指定图片相似的图片
+// @Description 复用已入库图片的嵌入向量搜索相似图片，结果中不包含该图片本身
+// @Tags 图片
+// @Produce json
+// @Param id path string true "图片ID"
+// @Param limit query int false "返回结果数量，默认10，最大100"
+// @Param max_distance query number false "最大距离阈值"
+// @Param extension query string false "图片格式过滤，多个用逗号分隔"
+// @Param min_width query int false "最小宽度"
+// @Param min_height query int false "最小高度"
+// @Param namespace query string false "只返回指定命名空间的图片"
+// @Param near query string false "只返回拍摄地点在中心点附近的图片，格式为 纬度,经度"
+// @Param radius query string false "与中心点的最大距离，支持 m、km 单位，默认5km"
+// @Param bbox query string false "只返回拍摄地点在矩形范围内的图片，格式为 最小经度,最小纬度,最大经度,最大纬度"
+// @Param diversify query bool false "是否使用 MMR 多样化重排"
+// @Param lambda query number false "MMR 相关性权重 (0, 1]，默认0.7"
+// @Param collapse query bool false "是否折叠近似重复结果"
+// @Param collapse_threshold query number false "近似重复判定距离，默认0.02"
+// @Param explain query bool false "是否返回搜索过程的详细信息"
+// @Success 200 {object} SearchImagesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/images/{id}/similar [get]
+func (h *Handler) SearchSimilarImages(c *gin.Context) {
+	// 解析图片ID
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		logrus.Errorf("解析图片ID失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "无效的图片ID",
+		})
+		return
+	}
+
+	// 解析搜索选项
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		logrus.Errorf("解析搜索参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if !h.checkGeoAccess(c, opts.Geo) {
+		return
+	}
+
+	// 搜索相似图片
+	outcome, err := h.imageService.SearchSimilarByImageID(id, opts)
+	if err != nil {
+		logrus.Errorf("搜索相似图片失败: %v", err)
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "图片不存在",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// 返回结果
+	h.redactOutcome(c, outcome)
+	c.JSON(http.StatusOK, buildSearchResponse(outcome))
+}
+
+// SearchImagesBatch 批量搜索图片
+// @Summary 批量搜索相似图片
+// @Description 一次请求提交多个查询图片或已入库图片ID，以有限并发处理，按查询名称返回各自的结果，单个查询失败不影响其他查询
+// @Tags 图片
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file false "查询图片，查询名称为文件名；也可以用任意字段名上传文件，查询名称为字段名"
+// @Param ids formData string false "已入库图片ID，格式为 名称=ID 或 ID，可重复"
+// @Success 200 {object} BatchSearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/images/search/batch [post]
+func (h *Handler) SearchImagesBatch(c *gin.Context) {
+	if !h.limitRequestBody(c, h.batchMaxQueries) {
+		return
+	}
+
+	// 解析查询列表
+	queries, err := parseBatchQueries(c)
+	if err != nil {
+		logrus.Errorf("解析批量搜索查询失败: %v", err)
+		if isRequestTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error: errRequestTooLarge.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// 解析搜索选项
+	opts, err := parseSearchOptions(c)
+	if err != nil {
+		logrus.Errorf("解析搜索参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if !h.checkGeoAccess(c, opts.Geo) {
+		return
+	}
+
+	// 批量搜索
+	batchResults, err := h.imageService.SearchBatch(queries, opts)
+	if err != nil {
+		logrus.Errorf("批量搜索失败: %v", err)
+		if errors.Is(err, service.ErrTooManyQueries) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: err.Error(),
 		})
@@ -264,21 +746,311 @@ func (h *Handler) SearchImages(c *gin.Context) {
 	}
 
 	// 构建响应数据
-	results := make([]SearchResult, len(images))
-	for i, img := range images {
+	response := BatchSearchResponse{
+		Results: make(map[string]BatchQueryResult, len(batchResults)),
+		Total:   len(batchResults),
+	}
+	for _, result := range batchResults {
+		if result.Err != nil {
+			response.Failed++
+			response.Results[result.Name] = BatchQueryResult{
+				Error: result.Err.Error(),
+			}
+			continue
+		}
+		h.redactOutcome(c, result.Outcome)
+		searchResponse := buildSearchResponse(result.Outcome)
+		response.Succeeded++
+		response.Results[result.Name] = BatchQueryResult{
+			SearchImagesResponse: &searchResponse,
+		}
+	}
+
+	// 返回结果
+	c.JSON(http.StatusOK, response)
+}
+
+// parseBatchQueries 从 multipart 表单中解析批量搜索的查询列表
+func parseBatchQueries(c *gin.Context) ([]service.BatchQuery, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		if isRequestTooLarge(err) {
+			return nil, errRequestTooLarge
+		}
+		return nil, errors.New("请使用 multipart/form-data 提交查询图片或图片ID")
+	}
+
+	var queries []service.BatchQuery
+	names := make(map[string]bool)
+	addQuery := func(query service.BatchQuery) error {
+		if query.Name == "" {
+			return errors.New("查询名称不能为空")
+		}
+		if names[query.Name] {
+			return fmt.Errorf("查询名称重复: %s", query.Name)
+		}
+		names[query.Name] = true
+		queries = append(queries, query)
+		return nil
+	}
+
+	// 按字段名排序，保证处理顺序稳定
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		for _, fileHeader := range form.File[field] {
+			name := field
+			if field == "files" {
+				name = fileHeader.Filename
+			}
+			if err := addQuery(service.BatchQuery{Name: name, File: fileHeader}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, value := range form.Value["ids"] {
+		name, idStr := value, value
+		if i := strings.Index(value, "="); i >= 0 {
+			name, idStr = value[:i], value[i+1:]
+		}
+		id, err := uuid.Parse(strings.TrimSpace(idStr))
+		if err != nil {
+			return nil, fmt.Errorf("无效的图片ID: %s", idStr)
+		}
+		if err := addQuery(service.BatchQuery{Name: strings.TrimSpace(name), ImageID: id}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(queries) == 0 {
+		return nil, errors.New("请至少提交一个查询图片或图片ID")
+	}
+
+	return queries, nil
+}
+
+// parseSearchOptions 从请求中解析搜索选项，参数可以放在查询字符串或表单中
+func parseSearchOptions(c *gin.Context) (service.SearchOptions, error) {
+	var opts service.SearchOptions
+
+	if v := searchParam(c, "limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return opts, fmt.Errorf("无效的 limit 参数: %s", v)
+		}
+		opts.Limit = limit
+	}
+
+	if v := searchParam(c, "max_distance"); v != "" {
+		maxDistance, err := strconv.ParseFloat(v, 32)
+		if err != nil || maxDistance < 0 {
+			return opts, fmt.Errorf("无效的 max_distance 参数: %s", v)
+		}
+		opts.MaxDistance = float32(maxDistance)
+	}
+
+	if v := searchParam(c, "extension"); v != "" {
+		for _, ext := range strings.Split(v, ",") {
+			ext = service.NormalizeFormat(strings.TrimSpace(ext))
+			if ext != "" {
+				opts.Extensions = append(opts.Extensions, ext)
+			}
+		}
+	}
+
+	if v := searchParam(c, "min_width"); v != "" {
+		minWidth, err := strconv.Atoi(v)
+		if err != nil || minWidth < 0 {
+			return opts, fmt.Errorf("无效的 min_width 参数: %s", v)
+		}
+		opts.MinWidth = minWidth
+	}
+
+	if v := searchParam(c, "min_height"); v != "" {
+		minHeight, err := strconv.Atoi(v)
+		if err != nil || minHeight < 0 {
+			return opts, fmt.Errorf("无效的 min_height 参数: %s", v)
+		}
+		opts.MinHeight = minHeight
+	}
+
+	opts.Namespace = searchParam(c, "namespace")
+
+	geo, err := parseGeoFilter(c)
+	if err != nil {
+		return opts, err
+	}
+	opts.Geo = geo
+
+	if v := searchParam(c, "diversify"); v != "" {
+		diversify, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("无效的 diversify 参数: %s", v)
+		}
+		opts.Diversify = diversify
+	}
+
+	if v := searchParam(c, "lambda"); v != "" {
+		lambda, err := strconv.ParseFloat(v, 64)
+		if err != nil || lambda <= 0 || lambda > 1 {
+			return opts, fmt.Errorf("无效的 lambda 参数: %s", v)
+		}
+		opts.Lambda = lambda
+	}
+
+	if v := searchParam(c, "collapse"); v != "" {
+		collapse, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("无效的 collapse 参数: %s", v)
+		}
+		opts.Collapse = collapse
+	}
+
+	if v := searchParam(c, "collapse_threshold"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 32)
+		if err != nil || threshold <= 0 {
+			return opts, fmt.Errorf("无效的 collapse_threshold 参数: %s", v)
+		}
+		opts.CollapseThreshold = float32(threshold)
+	}
+
+	if v := searchParam(c, "explain"); v != "" {
+		explain, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("无效的 explain 参数: %s", v)
+		}
+		opts.Explain = explain
+	}
+
+	return opts, nil
+}
+
+// searchParam 读取搜索参数，表单字段优先于查询字符串
+func searchParam(c *gin.Context, key string) string {
+	if v, ok := c.GetPostForm(key); ok {
+		return v
+	}
+	return c.Query(key)
+}
+
+// parseRenderOptions 解析图片处理参数
+func parseRenderOptions(c *gin.Context) (service.RenderOptions, error) {
+	var opts service.RenderOptions
+
+	for _, param := range []struct {
+		key   string
+		value *int
+	}{
+		{"w", &opts.Width},
+		{"h", &opts.Height},
+		{"q", &opts.Quality},
+	} {
+		if v := c.Query(param.key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("无效的 %s 参数: %s", param.key, v)
+			}
+			*param.value = n
+		}
+	}
+
+	opts.Fit = strings.ToLower(c.Query("fit"))
+	opts.Format = strings.ToLower(c.Query("format"))
+	return opts, nil
+}
+
+// negotiateImageFormat 根据 Accept 请求头选择输出格式
+// 返回空字符串表示 JPEG 和 PNG 同样可接受，沿用原图格式；两者都不可接受时返回 false
+func negotiateImageFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "", true
+	}
+
+	jpegQ := acceptQuality(accept, "image/jpeg")
+	pngQ := acceptQuality(accept, "image/png")
+	switch {
+	case jpegQ <= 0 && pngQ <= 0:
+		return "", false
+	case jpegQ > pngQ:
+		return "jpeg", true
+	case pngQ > jpegQ:
+		return "png", true
+	default:
+		return "", true
+	}
+}
+
+// acceptQuality 返回 Accept 请求头中媒体类型的权重，按最具体的匹配项计算，未匹配时为 0
+func acceptQuality(accept, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		candidate := strings.ToLower(strings.TrimSpace(params[0]))
+
+		level := -1
+		switch candidate {
+		case mediaType:
+			level = 2
+		case mainType + "/*":
+			level = 1
+		case "*/*":
+			level = 0
+		}
+		if level <= specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality, specificity = q, level
+	}
+	return quality
+}
+
+// buildSearchResponse 构建搜索响应数据
+func buildSearchResponse(outcome *service.SearchOutcome) SearchImagesResponse {
+	results := make([]SearchResult, len(outcome.Matches))
+	for i, match := range outcome.Matches {
 		results[i] = SearchResult{
-			Image:    img,
-			Distance: distances[i],
+			Image:    match.Image,
+			Distance: match.Distance,
 			// 生成图片URL
-			ImageURL: "/images/" + filepath.Base(img.FilePath),
+			ImageURL:       "/images/" + match.Image.StorageKey(),
+			Frame:          matchedFrame(match),
+			CollapsedCount: len(match.CollapsedIDs),
+			CollapsedIDs:   match.CollapsedIDs,
+			Embedding:      match.Embedding,
+			Contributions:  match.Contributions,
 		}
 	}
 
-	// 返回结果
-	c.JSON(http.StatusOK, SearchImagesResponse{
+	return SearchImagesResponse{
 		Results: results,
 		Total:   len(results),
-	})
+		Explain: outcome.Explain,
+	}
+}
+
+// matchedFrame 返回动图或多页 TIFF 中最匹配的帧序号，单帧图片返回 nil
+func matchedFrame(match service.SearchMatch) *int {
+	if match.Image.FrameCount <= 1 {
+		return nil
+	}
+	frame := match.Frame
+	return &frame
 }
 
 // 响应结构
@@ -303,13 +1075,33 @@ type ListImagesResponse struct {
 
 // SearchResult 搜索结果
 type SearchResult struct {
-	Image    interface{} `json:"image"`
-	Distance float32     `json:"distance"`
-	ImageURL string      `json:"image_url"`
+	Image          interface{} `json:"image"`
+	Distance       float32     `json:"distance"`
+	ImageURL       string      `json:"image_url"`
+	Frame          *int        `json:"frame,omitempty"`
+	CollapsedCount int         `json:"collapsed_count,omitempty"`
+	CollapsedIDs   []uuid.UUID `json:"collapsed_ids,omitempty"`
+	Embedding      []float32   `json:"embedding,omitempty"`
+	Contributions  []float32   `json:"contributions,omitempty"`
+}
+
+// BatchQueryResult 批量搜索中单个查询的结果，失败时只包含错误信息
+type BatchQueryResult struct {
+	*SearchImagesResponse
+	Error string `json:"error,omitempty"`
+}
+
+// BatchSearchResponse 批量搜索响应
+type BatchSearchResponse struct {
+	Results   map[string]BatchQueryResult `json:"results"`
+	Total     int                         `json:"total"`
+	Succeeded int                         `json:"succeeded"`
+	Failed    int                         `json:"failed"`
 }
 
 // SearchImagesResponse 图片搜索响应
 type SearchImagesResponse struct {
-	Results []SearchResult `json:"results"`
-	Total   int            `json:"total"`
+	Results []SearchResult         `json:"results"`
+	Total   int                    `json:"total"`
+	Explain *service.SearchExplain `json:"explain,omitempty"`
 }
\ No newline at end of file
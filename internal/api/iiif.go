@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// iiifPrefix IIIF Image API 的路由前缀
+const iiifPrefix = "/iiif/3"
+
+// registerIIIFRoutes 注册 IIIF Image API 路由
+func (h *Handler) registerIIIFRoutes(router *gin.Engine) {
+	router.GET(iiifPrefix+"/:id", h.IIIFBaseURI)
+	router.GET(iiifPrefix+"/:id/*params", h.IIIFImage)
+	router.HEAD(iiifPrefix+"/:id/*params", h.IIIFImage)
+}
+
+// IIIFBaseURI 将图片的 IIIF 基础地址重定向到 info.json
+// @Summary IIIF 基础地址
+// @Description 按 IIIF Image API 3.0 规范以 303 重定向到 info.json
+// @Tags IIIF
+// @Param id path string true "图片ID"
+// @Success 303
+// @Router /iiif/3/{id} [get]
+func (h *Handler) IIIFBaseURI(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Redirect(http.StatusSeeOther, iiifPrefix+"/"+c.Param("id")+"/info.json")
+}
+
+// IIIFImage 处理 IIIF 图片信息和图片请求
+// @Summary IIIF Image API 3.0
+// @Description {id}/info.json 返回图片信息；{id}/{region}/{size}/{rotation}/{quality}.{format} 返回处理后的图片，支持 level1 以及百分比区域、百分比和限定尺寸、放大、90 度旋转、镜像、灰度和黑白输出
+// @Tags IIIF
+// @Produce json,image/jpeg,image/png
+// @Param id path string true "图片ID"
+// @Param params path string true "info.json 或 {region}/{size}/{rotation}/{quality}.{format}"
+// @Success 200 {object} service.IIIFInfo
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /iiif/3/{id}/{params} [get]
+func (h *Handler) IIIFImage(c *gin.Context) {
+	// IIIF 查看器通常与图片服务不在同一个域名下
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "图片不存在",
+		})
+		return
+	}
+
+	params := strings.Split(strings.TrimPrefix(c.Param("params"), "/"), "/")
+	if len(params) == 1 && params[0] == "info.json" {
+		h.iiifInfo(c, id)
+		return
+	}
+	if len(params) != 4 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "IIIF 请求格式为 {region}/{size}/{rotation}/{quality}.{format}",
+		})
+		return
+	}
+
+	req, err := service.ParseIIIFRequest(params[0], params[1], params[2], params[3])
+	if err != nil {
+		writeIIIFError(c, err)
+		return
+	}
+
+	rendered, err := h.renderService.RenderIIIF(id, req)
+	if err != nil {
+		writeIIIFError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", rendered.ContentType)
+	c.Header("Link", `<`+service.IIIFProfileURI+`>;rel="profile"`)
+	c.Header("ETag", rendered.ETag)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	if rendered.Cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	http.ServeContent(c.Writer, c.Request, "", rendered.ModTime, bytes.NewReader(rendered.Data))
+}
+
+// iiifInfo 返回图片的 info.json
+func (h *Handler) iiifInfo(c *gin.Context, id uuid.UUID) {
+	info, err := h.renderService.IIIFInfo(id, iiifBaseURL(c))
+	if err != nil {
+		writeIIIFError(c, err)
+		return
+	}
+
+	// 客户端请求 JSON-LD 时按规范返回带 profile 的媒体类型
+	contentType := "application/json"
+	if strings.Contains(c.GetHeader("Accept"), "application/ld+json") {
+		contentType = `application/ld+json;profile="` + service.IIIFContext + `"`
+	}
+	c.Header("Link", `<`+service.IIIFProfileURI+`>;rel="profile"`)
+	c.Header("Vary", "Accept")
+	data, err := json.Marshal(info)
+	if err != nil {
+		writeIIIFError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// writeIIIFError 将 IIIF 处理错误转换为对应的状态码
+func writeIIIFError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrImageNotFound), errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "图片不存在",
+		})
+	case errors.Is(err, service.ErrInvalidIIIFRequest):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+	case errors.Is(err, service.ErrIIIFNotImplemented):
+		c.JSON(http.StatusNotImplemented, ErrorResponse{
+			Error: err.Error(),
+		})
+	default:
+		logrus.Errorf("处理 IIIF 请求失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "处理图片失败",
+		})
+	}
+}
+
+// iiifBaseURL 根据请求推断 IIIF 服务的对外地址，支持反向代理设置的 X-Forwarded-Proto 和 X-Forwarded-Host
+func iiifBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return scheme + "://" + host + iiifPrefix
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// errLocationForbidden 没有管理令牌的请求按拍摄地点查询
+const errLocationForbidden = "按拍摄地点查询需要管理令牌"
+
+// canSeeLocation 判断本次请求是否可以看到拍摄地点，配置了 PRIVACY_PUBLIC_LOCATION 或携带有效的管理令牌时可以
+func (h *Handler) canSeeLocation(c *gin.Context) bool {
+	return h.publicLocation || h.hasAdminToken(c)
+}
+
+// checkGeoAccess 检查请求是否可以按拍摄地点过滤，否则返回 403 并返回 false
+// 没有权限时按拍摄地点过滤同样可以推断出图片的位置，因此与拍摄地点字段使用相同的权限
+func (h *Handler) checkGeoAccess(c *gin.Context, geo *repository.GeoFilter) bool {
+	if geo == nil || h.canSeeLocation(c) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, ErrorResponse{
+		Error: errLocationForbidden,
+	})
+	return false
+}
+
+// redactImage 没有权限时删除图片元数据中的拍摄地点
+func (h *Handler) redactImage(c *gin.Context, image *model.Image) {
+	if image == nil || image.Metadata == nil || h.canSeeLocation(c) {
+		return
+	}
+	metadata := *image.Metadata
+	metadata.Latitude = nil
+	metadata.Longitude = nil
+	metadata.Altitude = nil
+	metadata.Geohash = ""
+	image.Metadata = &metadata
+}
+
+// redactImages 没有权限时删除一组图片元数据中的拍摄地点
+func (h *Handler) redactImages(c *gin.Context, images []model.Image) {
+	for i := range images {
+		h.redactImage(c, &images[i])
+	}
+}
+
+// redactOutcome 没有权限时删除搜索结果中的拍摄地点
+func (h *Handler) redactOutcome(c *gin.Context, outcome *service.SearchOutcome) {
+	for i := range outcome.Matches {
+		h.redactImage(c, &outcome.Matches[i].Image)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// QuotaErrorResponse 超出配额的错误响应
+type QuotaErrorResponse struct {
+	Error     string `json:"error"`
+	Scope     string `json:"scope"`
+	Namespace string `json:"namespace,omitempty"`
+	Resource  string `json:"resource"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Requested int64  `json:"requested"`
+}
+
+// writeQuotaError 返回超出配额的错误
+// 本次上传本身超过上限时返回 413，删除已有图片后可以上传时返回 507
+func writeQuotaError(c *gin.Context, err *service.QuotaError) {
+	status := http.StatusInsufficientStorage
+	if err.TooLarge() {
+		status = http.StatusRequestEntityTooLarge
+	}
+	c.JSON(status, QuotaErrorResponse{
+		Error:     err.Error(),
+		Scope:     err.Scope,
+		Namespace: err.Namespace,
+		Resource:  err.Resource,
+		Limit:     err.Limit,
+		Used:      err.Used,
+		Requested: err.Requested,
+	})
+}
+
+// Usage 存储用量
+// @Summary 存储用量
+// @Description 返回整个实例和每个命名空间的图片数量、占用字节数和配额上限，上限为 0 表示不限制
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} service.UsageReport
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/usage [get]
+func (h *Handler) Usage(c *gin.Context) {
+	report, err := h.imageService.Usage()
+	if err != nil {
+		logrus.Errorf("统计存储用量失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "统计存储用量失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ApplyRetention 执行保留策略
+// @Summary 执行保留策略
+// @Description 按 RETENTION_RULES 删除上传时间超过保留期的图片，默认只生成报告不删除
+// @Tags 管理
+// @Produce json
+// @Security AdminToken
+// @Param dry_run query bool false "只列出超过保留期的图片，不删除，默认 true"
+// @Success 200 {object} service.RetentionReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/retention [post]
+func (h *Handler) ApplyRetention(c *gin.Context) {
+	dryRun := true
+	if v := searchParam(c, "dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: fmt.Sprintf("无效的 dry_run 参数: %s", v),
+			})
+			return
+		}
+		dryRun = b
+	}
+
+	report, err := h.imageService.ApplyRetention(dryRun)
+	if err != nil {
+		logrus.Errorf("执行保留策略失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
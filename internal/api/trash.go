@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ListTrashResponse 回收站图片列表响应
+type ListTrashResponse struct {
+	Images []service.TrashedImage `json:"images"`
+	Total  int64                  `json:"total"`
+	Page   int                    `json:"page"`
+	Size   int                    `json:"size"`
+}
+
+// ListTrash 列出回收站中的图片
+// @Summary 列出回收站中的图片
+// @Description 分页列出已删除但尚未永久删除的图片，最近删除的在前，purge_at 为预计永久删除的时间
+// @Tags 回收站
+// @Produce json
+// @Security AdminToken
+// @Param page query int false "页码，默认1"
+// @Param page_size query int false "每页数量，默认10"
+// @Success 200 {object} ListTrashResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/trash [get]
+func (h *Handler) ListTrash(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+
+	images, total, err := h.imageService.ListTrash(page, pageSize)
+	if err != nil {
+		logrus.Errorf("获取回收站图片列表失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "获取回收站图片列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ListTrashResponse{
+		Images: images,
+		Total:  total,
+		Page:   page,
+		Size:   pageSize,
+	})
+}
+
+// RestoreImage 恢复回收站中的图片
+// @Summary 恢复图片
+// @Description 将回收站中的图片恢复，恢复后重新出现在图片列表和搜索结果中
+// @Tags 回收站
+// @Produce json
+// @Param id path string true "图片ID"
+// @Success 200 {object} model.Image
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/trash/{id}/restore [post]
+func (h *Handler) RestoreImage(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "无效的图片ID",
+		})
+		return
+	}
+
+	image, err := h.imageService.RestoreImage(id)
+	if err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "回收站中没有该图片",
+			})
+			return
+		}
+		logrus.Errorf("恢复图片失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "恢复图片失败",
+		})
+		return
+	}
+
+	h.redactImage(c, image)
+	c.JSON(http.StatusOK, image)
+}
+
+// PurgeImage 永久删除回收站中的图片
+// @Summary 永久删除图片
+// @Description 立即永久删除回收站中的图片及其嵌入向量，不再被其他图片引用的文件同时删除
+// @Tags 回收站
+// @Produce json
+// @Security AdminToken
+// @Param id path string true "图片ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/trash/{id} [delete]
+func (h *Handler) PurgeImage(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "无效的图片ID",
+		})
+		return
+	}
+
+	if err := h.imageService.PurgeImage(id); err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "回收站中没有该图片",
+			})
+			return
+		}
+		logrus.Errorf("永久删除图片失败: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "永久删除图片失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Message: "图片已永久删除",
+	})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead 请求体上限在图片大小上限之外为表单字段和 multipart 边界预留的字节数
+const multipartOverhead = 1 << 20
+
+// errRequestTooLarge 请求体超过上限
+var errRequestTooLarge = errors.New("请求体过大")
+
+// limitRequestBody 限制请求体的大小，files 为请求中允许的图片数量，小于 1 时按 1 计算
+// Content-Length 已经超过上限时直接返回 413 并返回 false，否则读取超过上限时解析表单失败
+func (h *Handler) limitRequestBody(c *gin.Context, files int) bool {
+	if h.maxUploadBytes <= 0 {
+		return true
+	}
+	if files < 1 {
+		files = 1
+	}
+	limit := h.maxUploadBytes*int64(files) + multipartOverhead
+	if c.Request.ContentLength > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error: fmt.Sprintf("%s: 超过 %d 字节", errRequestTooLarge, limit),
+		})
+		return false
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	return true
+}
+
+// isRequestTooLarge 判断解析表单失败是否因为请求体超过上限
+func isRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, errRequestTooLarge)
+}
+
+// writeImageError 将图片过大、格式不支持、图片损坏和解码繁忙的错误转换为对应的响应，其他错误返回 false
+// 过大返回 413，格式不支持返回 415，损坏返回 422，解码繁忙返回 503
+func writeImageError(c *gin.Context, err error) bool {
+	var status int
+	switch {
+	case errors.Is(err, service.ErrDecodeBusy):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrImageTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrUnsupportedFormat):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, service.ErrCorruptImage):
+		status = http.StatusUnprocessableEntity
+	default:
+		return false
+	}
+	c.JSON(status, ErrorResponse{
+		Error: err.Error(),
+	})
+	return true
+}
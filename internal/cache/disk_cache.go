@@ -0,0 +1,234 @@
+// Package cache 提供带容量上限的磁盘缓存
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidKey 缓存键包含路径分隔符等非法字符
+var ErrInvalidKey = errors.New("无效的缓存键")
+
+// entry 缓存条目
+type entry struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// Sealer 加密缓存内容，aad 为需要一并认证的附加数据
+type Sealer interface {
+	Seal(plaintext, aad []byte) ([]byte, error)
+	Open(data, aad []byte) ([]byte, error)
+}
+
+// DiskCache 磁盘缓存，每个条目保存为目录下的一个文件
+// 访问顺序只记录在内存中，启动时按文件修改时间恢复，总大小超过上限时淘汰最近最少使用的条目
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	// sealer 不为空时缓存文件加密保存
+	sealer Sealer
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewDiskCache 创建磁盘缓存并加载目录中已有的条目，maxBytes 不大于 0 时不限制容量
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetSealer 设置缓存文件的加密方式，需要在使用缓存之前调用
+// 之后读取到无法解密的条目（如未加密时写入的条目）会被删除
+func (c *DiskCache) SetSealer(sealer Sealer) {
+	c.sealer = sealer
+}
+
+// load 加载目录中已有的条目，修改时间越新越靠近队首，并清理上次写入中断留下的临时文件
+func (c *DiskCache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var existing []entry
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(file.Name(), ".tmp-") {
+			os.Remove(filepath.Join(c.dir, file.Name()))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, entry{key: file.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].modTime.After(existing[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range existing {
+		c.entries[e.key] = c.order.PushBack(&entry{key: e.key, size: e.size, modTime: e.modTime})
+		c.size += e.size
+	}
+	c.evict()
+	return nil
+}
+
+// Get 读取缓存条目，命中时将条目标记为最近使用
+func (c *DiskCache) Get(key string) ([]byte, time.Time, bool) {
+	if !validKey(key) {
+		return nil, time.Time{}, false
+	}
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, time.Time{}, false
+	}
+	c.order.MoveToFront(elem)
+	modTime := elem.Value.(*entry).modTime
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		// 文件被外部删除，移除索引
+		logrus.Warnf("读取缓存文件 %s 失败: %v", key, err)
+		c.remove(key)
+		return nil, time.Time{}, false
+	}
+	if c.sealer != nil {
+		if data, err = c.sealer.Open(data, []byte(key)); err != nil {
+			logrus.Warnf("解密缓存文件 %s 失败，删除该条目: %v", key, err)
+			c.remove(key)
+			os.Remove(filepath.Join(c.dir, key))
+			return nil, time.Time{}, false
+		}
+	}
+	return data, modTime, true
+}
+
+// Put 写入缓存条目，先写临时文件再重命名，读取方不会看到写了一半的文件
+func (c *DiskCache) Put(key string, data []byte) (time.Time, error) {
+	if !validKey(key) {
+		return time.Time{}, ErrInvalidKey
+	}
+	if c.maxBytes > 0 && int64(len(data)) > c.maxBytes {
+		// 单个条目超过容量上限，不缓存
+		return time.Now(), nil
+	}
+	if c.sealer != nil {
+		sealed, err := c.sealer.Seal(data, []byte(key))
+		if err != nil {
+			return time.Time{}, err
+		}
+		data = sealed
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		return time.Time{}, err
+	}
+
+	modTime := time.Now()
+	size := int64(len(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		c.size += size - e.size
+		e.size = size
+		e.modTime = modTime
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&entry{key: key, size: size, modTime: modTime})
+		c.size += size
+	}
+	c.evict()
+	return modTime, nil
+}
+
+// evict 淘汰最近最少使用的条目直到总大小不超过上限，调用方需持有锁
+func (c *DiskCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.size > c.maxBytes {
+		elem := c.order.Back()
+		if elem == nil {
+			return
+		}
+		e := elem.Value.(*entry)
+		c.order.Remove(elem)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		if err := os.Remove(filepath.Join(c.dir, e.key)); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("删除缓存文件 %s 失败: %v", e.key, err)
+		}
+	}
+}
+
+// remove 从索引中移除条目
+func (c *DiskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.size -= elem.Value.(*entry).size
+	}
+}
+
+// validKey 检查缓存键是否可以直接作为文件名
+func validKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, ".") && !strings.ContainsAny(key, `/\`)
+}
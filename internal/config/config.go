@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"image/color"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -12,6 +17,13 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	Storage  StorageConfig
+	Upload   UploadConfig
+	Pipeline PipelineConfig
+	Search   SearchConfig
+	Render   RenderConfig
+	Trash    TrashConfig
+	Quota    QuotaConfig
+	Privacy  PrivacyConfig
 	Log      LogConfig
 }
 
@@ -19,6 +31,8 @@ type Config struct {
 type ServerConfig struct {
 	Port int
 	Host string
+	// AdminToken 管理接口的访问令牌，为空时不开放管理接口
+	AdminToken string
 }
 
 // DatabaseConfig 数据库配置
@@ -28,7 +42,182 @@ type DatabaseConfig struct {
 
 // StorageConfig 存储配置
 type StorageConfig struct {
+	// Backend 存储后端，local 或 s3
+	Backend  string
 	ImageDir string
+	S3       S3Config
+	// DedupMode 重复上传的处理方式：reuse 直接返回已有图片记录，reference 创建新记录并共享同一文件
+	DedupMode string
+	// Renditions 上传时生成的衍生图片规格
+	Renditions []RenditionConfig
+	// Encryption 静态加密配置
+	Encryption EncryptionConfig
+	// SVGRasterSize SVG 图片栅格化后的最长边（像素），用于生成嵌入向量、衍生图片和实时处理
+	SVGRasterSize int
+}
+
+// EncryptionConfig 对象存储静态加密配置，KeyFile 为空时不加密
+type EncryptionConfig struct {
+	// KeyFile 密钥文件路径，每行一个 `密钥ID base64编码的密钥`
+	KeyFile string
+	// KeyID 加密新文件使用的密钥ID，为空时使用密钥文件中的最后一个密钥
+	KeyID string
+}
+
+// RenditionConfig 衍生图片规格，按比例缩小到不超过最大宽高，不放大
+type RenditionConfig struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+	// Format 输出格式，jpeg 或 png，为空时与原图相同，原图不是 JPEG 或 PNG 时使用 png
+	// 原图包含透明像素时 jpeg 改为 png，保留透明度
+	Format  string
+	Quality int
+}
+
+// S3Config S3 兼容对象存储配置
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// UsePathStyle 使用 endpoint/bucket/key 形式的路径访问，MinIO 等自建服务通常需要开启
+	UsePathStyle bool
+}
+
+// UploadConfig 上传和查询图片的限制，防止超大文件和解压炸弹耗尽内存或 CPU，各项为 0 时不限制
+type UploadConfig struct {
+	// MaxBytes 单个图片文件的最大字节数，上传和搜索请求的请求体也不能超过该值
+	MaxBytes int64
+	// MaxPixels 图片解码后的最大像素数，解码前按文件头中的宽高检查；GIF 按所有帧的像素数之和计算
+	MaxPixels int64
+	// MaxDimension 图片宽或高的最大值
+	MaxDimension int
+	// DecodeTimeout 等待解码图片并生成衍生图片和嵌入向量的最长时间，只限制请求的等待时间，超时的解码仍会在后台执行到结束
+	DecodeTimeout time.Duration
+	// DecodeConcurrency 同时执行的解码任务数量上限，超时后仍在后台执行的解码同样占用名额，0 表示不限制
+	DecodeConcurrency int
+}
+
+// PipelineConfig 上传和查询图片的处理流水线
+type PipelineConfig struct {
+	// Steps 按顺序执行的处理步骤
+	Steps []PipelineStep
+}
+
+// PipelineStep 处理流水线中的一步
+type PipelineStep struct {
+	// Name 步骤名称：orient、srgb、resize、format、quality 或 strip
+	Name string
+	// Width resize 步骤缩小到的最大宽度
+	Width int
+	// Format format 步骤保存原图使用的格式，jpeg 或 png
+	Format string
+	// Quality quality 步骤重新编码 JPEG 原图使用的质量
+	Quality int
+}
+
+// String 返回步骤在配置中的写法，如 resize:800
+func (s PipelineStep) String() string {
+	switch s.Name {
+	case "resize":
+		return fmt.Sprintf("resize:%d", s.Width)
+	case "format":
+		return "format:" + s.Format
+	case "quality":
+		return fmt.Sprintf("quality:%d", s.Quality)
+	default:
+		return s.Name
+	}
+}
+
+// SearchConfig 搜索配置
+type SearchConfig struct {
+	// BatchConcurrency 批量搜索时并发处理的查询数量
+	BatchConcurrency int
+	// BatchMaxQueries 单次批量搜索允许的最大查询数量
+	BatchMaxQueries int
+	// MaxFrames 动图和多页 TIFF 最多为多少帧生成嵌入向量，超过时均匀抽样，1 表示只使用第一帧
+	MaxFrames int
+	// EmbeddingBackground 生成嵌入向量前将透明图片合成到该背景色上，为空时按像素的不透明度加权
+	EmbeddingBackground *color.NRGBA
+}
+
+// RenderConfig 图片实时处理配置
+type RenderConfig struct {
+	// CacheDir 处理结果的磁盘缓存目录
+	CacheDir string
+	// CacheMaxBytes 磁盘缓存的最大容量，超出后按最近最少使用淘汰
+	CacheMaxBytes int64
+	// MaxDimension 输出图片允许的最大宽高
+	MaxDimension int
+	// IIIFTileSize IIIF 瓦片的边长
+	IIIFTileSize int
+	// IIIFBaseURL IIIF 服务的对外地址，为空时根据请求推断
+	IIIFBaseURL string
+}
+
+// TrashConfig 回收站配置
+type TrashConfig struct {
+	// Retention 图片在回收站中保留的时长，超过后永久删除；不大于 0 时删除图片立即永久删除
+	Retention time.Duration
+	// PurgeInterval 后台清理回收站的间隔
+	PurgeInterval time.Duration
+}
+
+// QuotaConfig 存储配额配置
+type QuotaConfig struct {
+	// Global 整个实例的上限，字节数按对象存储中实际占用计算，相同内容的文件只计一次
+	Global QuotaLimit
+	// Namespace 每个命名空间的默认上限，字节数按该命名空间中图片的原图和衍生图片大小之和计算
+	Namespace QuotaLimit
+	// Namespaces 单独配置的命名空间上限，覆盖默认值
+	Namespaces map[string]QuotaLimit
+	// Retention 按图片上传时间自动过期的保留策略
+	Retention []RetentionRule
+	// RetentionInterval 后台执行保留策略的间隔
+	RetentionInterval time.Duration
+}
+
+// QuotaLimit 配额上限，不大于 0 表示不限制
+type QuotaLimit struct {
+	MaxBytes  int64
+	MaxImages int64
+}
+
+// RetentionRule 保留策略，命名空间中上传时间超过 MaxAge 的图片自动删除
+type RetentionRule struct {
+	// Namespace 适用的命名空间，* 表示没有单独配置规则的所有命名空间
+	Namespace string
+	MaxAge    time.Duration
+}
+
+// LimitFor 返回指定命名空间的配额上限
+func (c QuotaConfig) LimitFor(namespace string) QuotaLimit {
+	if limit, ok := c.Namespaces[namespace]; ok {
+		return limit
+	}
+	return c.Namespace
+}
+
+// 访问图片文件时保留的元数据
+const (
+	// MetadataPolicyStrip 删除所有元数据，包括 ICC 配置文件
+	MetadataPolicyStrip = "strip"
+	// MetadataPolicyOrientation 只保留 EXIF 方向和 ICC 配置文件
+	MetadataPolicyOrientation = "orientation"
+	// MetadataPolicyCopyright 在 MetadataPolicyOrientation 的基础上保留版权和作者
+	MetadataPolicyCopyright = "copyright"
+)
+
+// PrivacyConfig 隐私配置
+type PrivacyConfig struct {
+	// MetadataPolicy 通过 /images 访问图片文件时保留的元数据
+	MetadataPolicy string
+	// PublicLocation 是否向没有管理令牌的请求返回拍摄地点和开放按拍摄地点查询
+	PublicLocation bool
 }
 
 // LogConfig 日志配置
@@ -39,17 +228,92 @@ type LogConfig struct {
 // LoadConfig 加载配置
 func LoadConfig() *Config {
 	port, _ := strconv.Atoi(getEnv("SERVER_PORT", "8080"))
+	batchConcurrency, _ := strconv.Atoi(getEnv("SEARCH_BATCH_CONCURRENCY", "4"))
+	batchMaxQueries, _ := strconv.Atoi(getEnv("SEARCH_BATCH_MAX_QUERIES", "100"))
+	maxFrames, _ := strconv.Atoi(getEnv("SEARCH_MAX_FRAMES", "16"))
+	svgRasterSize, _ := strconv.Atoi(getEnv("STORAGE_SVG_RASTER_SIZE", "1024"))
+	uploadMaxBytes, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_BYTES", "33554432"), 10, 64)
+	uploadMaxPixels, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_PIXELS", "50000000"), 10, 64)
+	uploadMaxDimension, _ := strconv.Atoi(getEnv("UPLOAD_MAX_DIMENSION", "20000"))
+	uploadDecodeConcurrency, _ := strconv.Atoi(getEnv("UPLOAD_DECODE_CONCURRENCY", strconv.Itoa(runtime.NumCPU())))
+	s3PathStyle, _ := strconv.ParseBool(getEnv("S3_USE_PATH_STYLE", "true"))
+	publicLocation, _ := strconv.ParseBool(getEnv("PRIVACY_PUBLIC_LOCATION", "false"))
+	renderCacheMaxBytes, _ := strconv.ParseInt(getEnv("RENDER_CACHE_MAX_BYTES", "268435456"), 10, 64)
+	renderMaxDimension, _ := strconv.Atoi(getEnv("RENDER_MAX_DIMENSION", "4096"))
+	iiifTileSize, _ := strconv.Atoi(getEnv("IIIF_TILE_SIZE", "512"))
+	trashRetention := getDurationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	trashPurgeInterval := getDurationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	quotaMaxBytes, _ := strconv.ParseInt(getEnv("QUOTA_MAX_BYTES", "0"), 10, 64)
+	quotaMaxImages, _ := strconv.ParseInt(getEnv("QUOTA_MAX_IMAGES", "0"), 10, 64)
+	namespaceMaxBytes, _ := strconv.ParseInt(getEnv("QUOTA_NAMESPACE_MAX_BYTES", "0"), 10, 64)
+	namespaceMaxImages, _ := strconv.ParseInt(getEnv("QUOTA_NAMESPACE_MAX_IMAGES", "0"), 10, 64)
 
 	return &Config{
 		Server: ServerConfig{
-			Port: port,
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:       port,
+			Host:       getEnv("SERVER_HOST", "0.0.0.0"),
+			AdminToken: getEnv("ADMIN_TOKEN", ""),
 		},
 		Database: DatabaseConfig{
 			DSN: getEnv("DATABASE_DSN", "./imagesearch.db"),
 		},
 		Storage: StorageConfig{
+			Backend:  getEnv("STORAGE_BACKEND", "local"),
 			ImageDir: getEnv("STORAGE_IMAGE_DIR", "./assets/images"),
+			S3: S3Config{
+				Endpoint:        getEnv("S3_ENDPOINT", ""),
+				Region:          getEnv("S3_REGION", "us-east-1"),
+				Bucket:          getEnv("S3_BUCKET", ""),
+				Prefix:          getEnv("S3_PREFIX", ""),
+				AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+				UsePathStyle:    s3PathStyle,
+			},
+			DedupMode:  getEnv("STORAGE_DEDUP_MODE", "reuse"),
+			Renditions: loadRenditions(),
+			Encryption: EncryptionConfig{
+				KeyFile: getEnv("ENCRYPTION_KEY_FILE", ""),
+				KeyID:   getEnv("ENCRYPTION_KEY_ID", ""),
+			},
+			SVGRasterSize: svgRasterSize,
+		},
+		Upload: UploadConfig{
+			MaxBytes:          uploadMaxBytes,
+			MaxPixels:         uploadMaxPixels,
+			MaxDimension:      uploadMaxDimension,
+			DecodeTimeout:     getDurationEnv("UPLOAD_DECODE_TIMEOUT", 30*time.Second),
+			DecodeConcurrency: uploadDecodeConcurrency,
+		},
+		Pipeline: PipelineConfig{
+			Steps: loadPipeline(),
+		},
+		Search: SearchConfig{
+			BatchConcurrency:    batchConcurrency,
+			BatchMaxQueries:     batchMaxQueries,
+			MaxFrames:           maxFrames,
+			EmbeddingBackground: loadEmbeddingBackground(),
+		},
+		Render: RenderConfig{
+			CacheDir:      getEnv("RENDER_CACHE_DIR", "./assets/cache/render"),
+			CacheMaxBytes: renderCacheMaxBytes,
+			MaxDimension:  renderMaxDimension,
+			IIIFTileSize:  iiifTileSize,
+			IIIFBaseURL:   strings.TrimSuffix(getEnv("IIIF_BASE_URL", ""), "/"),
+		},
+		Trash: TrashConfig{
+			Retention:     trashRetention,
+			PurgeInterval: trashPurgeInterval,
+		},
+		Quota: QuotaConfig{
+			Global:            QuotaLimit{MaxBytes: quotaMaxBytes, MaxImages: quotaMaxImages},
+			Namespace:         QuotaLimit{MaxBytes: namespaceMaxBytes, MaxImages: namespaceMaxImages},
+			Namespaces:        loadNamespaceQuotas(),
+			Retention:         loadRetentionRules(),
+			RetentionInterval: getDurationEnv("RETENTION_INTERVAL", time.Hour),
+		},
+		Privacy: PrivacyConfig{
+			MetadataPolicy: loadMetadataPolicy(),
+			PublicLocation: publicLocation,
 		},
 		Log: LogConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
@@ -69,6 +333,261 @@ func SetupLogger(config *LogConfig) {
 	})
 }
 
+// defaultRenditions 默认的衍生图片规格
+const defaultRenditions = "thumbnail:200x200:jpeg:80,medium:800x800:jpeg:85,large:1600x1600:jpeg:90"
+
+// loadRenditions 从环境变量加载衍生图片规格，格式错误时使用默认规格
+func loadRenditions() []RenditionConfig {
+	renditions, err := ParseRenditions(getEnv("STORAGE_RENDITIONS", defaultRenditions))
+	if err != nil {
+		logrus.Warnf("解析 STORAGE_RENDITIONS 失败，使用默认规格: %v", err)
+		renditions, _ = ParseRenditions(defaultRenditions)
+	}
+	return renditions
+}
+
+// ParseRenditions 解析衍生图片规格，多个规格用逗号分隔，每个规格的格式为 名称:宽x高[:格式[:质量]]
+// 例如 thumbnail:200x200:jpeg:80；值为 none 时不生成衍生图片
+func ParseRenditions(value string) ([]RenditionConfig, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "none" {
+		return nil, nil
+	}
+
+	var renditions []RenditionConfig
+	names := make(map[string]bool)
+	for _, spec := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+			return nil, fmt.Errorf("无效的衍生图片规格: %s", spec)
+		}
+
+		rendition := RenditionConfig{Name: parts[0], Quality: 90}
+		if names[rendition.Name] {
+			return nil, fmt.Errorf("衍生图片名称重复: %s", rendition.Name)
+		}
+		names[rendition.Name] = true
+
+		width, height, ok := strings.Cut(parts[1], "x")
+		var errW, errH error
+		rendition.MaxWidth, errW = strconv.Atoi(width)
+		rendition.MaxHeight, errH = strconv.Atoi(height)
+		if !ok || errW != nil || errH != nil || rendition.MaxWidth < 1 || rendition.MaxHeight < 1 {
+			return nil, fmt.Errorf("无效的衍生图片尺寸: %s", spec)
+		}
+
+		if len(parts) > 2 {
+			rendition.Format = strings.ToLower(parts[2])
+			if rendition.Format == "jpg" {
+				rendition.Format = "jpeg"
+			}
+			if rendition.Format != "" && rendition.Format != "jpeg" && rendition.Format != "png" {
+				return nil, fmt.Errorf("不支持的衍生图片格式: %s", spec)
+			}
+		}
+		if len(parts) > 3 {
+			quality, err := strconv.Atoi(parts[3])
+			if err != nil || quality < 1 || quality > 100 {
+				return nil, fmt.Errorf("无效的衍生图片质量: %s", spec)
+			}
+			rendition.Quality = quality
+		}
+
+		renditions = append(renditions, rendition)
+	}
+	return renditions, nil
+}
+
+// DefaultPipeline 默认的处理流水线，只处理用于生成嵌入向量的图片，原图原样保存
+const DefaultPipeline = "orient,srgb,resize:800"
+
+// loadPipeline 从环境变量加载处理流水线，格式错误时使用默认流水线
+func loadPipeline() []PipelineStep {
+	steps, err := ParsePipeline(getEnv("PIPELINE_STEPS", DefaultPipeline))
+	if err != nil {
+		logrus.Warnf("解析 PIPELINE_STEPS 失败，使用默认流水线: %v", err)
+		steps, _ = ParsePipeline(DefaultPipeline)
+	}
+	return steps
+}
+
+// ParsePipeline 解析处理流水线，多个步骤用逗号分隔，带参数的步骤格式为 名称:参数
+// 例如 orient,srgb,resize:800,format:jpeg,quality:85,strip；值为 none 时不做任何处理
+func ParsePipeline(value string) ([]PipelineStep, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "none" {
+		return nil, nil
+	}
+
+	var steps []PipelineStep
+	names := make(map[string]bool)
+	for _, spec := range strings.Split(value, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), ":")
+		step := PipelineStep{Name: strings.ToLower(name)}
+		if names[step.Name] {
+			return nil, fmt.Errorf("处理步骤重复: %s", spec)
+		}
+		names[step.Name] = true
+
+		switch step.Name {
+		case "orient", "srgb", "strip":
+			if hasArg {
+				return nil, fmt.Errorf("处理步骤 %s 不需要参数: %s", step.Name, spec)
+			}
+		case "resize":
+			width, err := strconv.Atoi(arg)
+			if err != nil || width < 1 {
+				return nil, fmt.Errorf("无效的缩放宽度: %s", spec)
+			}
+			step.Width = width
+		case "format":
+			step.Format = strings.ToLower(arg)
+			if step.Format == "jpg" {
+				step.Format = "jpeg"
+			}
+			if step.Format != "jpeg" && step.Format != "png" {
+				return nil, fmt.Errorf("不支持的原图格式: %s", spec)
+			}
+		case "quality":
+			quality, err := strconv.Atoi(arg)
+			if err != nil || quality < 1 || quality > 100 {
+				return nil, fmt.Errorf("无效的原图质量: %s", spec)
+			}
+			step.Quality = quality
+		default:
+			return nil, fmt.Errorf("未知的处理步骤: %s", spec)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// loadMetadataPolicy 从环境变量加载元数据保留策略，无效时删除所有元数据
+func loadMetadataPolicy() string {
+	policy := strings.ToLower(getEnv("PRIVACY_METADATA_POLICY", MetadataPolicyOrientation))
+	switch policy {
+	case MetadataPolicyStrip, MetadataPolicyOrientation, MetadataPolicyCopyright:
+		return policy
+	default:
+		logrus.Warnf("无效的 PRIVACY_METADATA_POLICY: %s，删除所有元数据", policy)
+		return MetadataPolicyStrip
+	}
+}
+
+// loadNamespaceQuotas 从环境变量加载单独配置的命名空间配额，格式错误时忽略
+func loadNamespaceQuotas() map[string]QuotaLimit {
+	quotas, err := ParseNamespaceQuotas(getEnv("QUOTA_NAMESPACES", ""))
+	if err != nil {
+		logrus.Warnf("解析 QUOTA_NAMESPACES 失败，所有命名空间使用默认配额: %v", err)
+		return nil
+	}
+	return quotas
+}
+
+// ParseNamespaceQuotas 解析命名空间配额，多个命名空间用逗号分隔，每项的格式为 命名空间:最大字节数:最大图片数量
+// 例如 team-a:10737418240:5000，数值为 0 表示不限制
+func ParseNamespaceQuotas(value string) (map[string]QuotaLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	quotas := make(map[string]QuotaLimit)
+	for _, spec := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("无效的命名空间配额: %s", spec)
+		}
+		if _, ok := quotas[parts[0]]; ok {
+			return nil, fmt.Errorf("命名空间配额重复: %s", parts[0])
+		}
+
+		maxBytes, errB := strconv.ParseInt(parts[1], 10, 64)
+		maxImages, errI := strconv.ParseInt(parts[2], 10, 64)
+		if errB != nil || errI != nil || maxBytes < 0 || maxImages < 0 {
+			return nil, fmt.Errorf("无效的命名空间配额: %s", spec)
+		}
+		quotas[parts[0]] = QuotaLimit{MaxBytes: maxBytes, MaxImages: maxImages}
+	}
+	return quotas, nil
+}
+
+// loadRetentionRules 从环境变量加载保留策略，格式错误时不启用
+func loadRetentionRules() []RetentionRule {
+	rules, err := ParseRetentionRules(getEnv("RETENTION_RULES", ""))
+	if err != nil {
+		logrus.Warnf("解析 RETENTION_RULES 失败，不启用保留策略: %v", err)
+		return nil
+	}
+	return rules
+}
+
+// ParseRetentionRules 解析保留策略，多条规则用逗号分隔，每条规则的格式为 命名空间:最长保留时长
+// 例如 tmp:72h,*:8760h，* 适用于没有单独配置规则的所有命名空间
+func ParseRetentionRules(value string) ([]RetentionRule, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var rules []RetentionRule
+	namespaces := make(map[string]bool)
+	for _, spec := range strings.Split(value, ",") {
+		namespace, age, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok || namespace == "" {
+			return nil, fmt.Errorf("无效的保留策略: %s", spec)
+		}
+		if namespaces[namespace] {
+			return nil, fmt.Errorf("保留策略重复: %s", namespace)
+		}
+		namespaces[namespace] = true
+
+		maxAge, err := time.ParseDuration(age)
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("无效的保留时长: %s", spec)
+		}
+		rules = append(rules, RetentionRule{Namespace: namespace, MaxAge: maxAge})
+	}
+	return rules, nil
+}
+
+// loadEmbeddingBackground 从环境变量加载生成嵌入向量使用的背景色，格式错误时不使用背景色
+func loadEmbeddingBackground() *color.NRGBA {
+	background, err := ParseColor(getEnv("SEARCH_EMBEDDING_BACKGROUND", ""))
+	if err != nil {
+		logrus.Warnf("解析 SEARCH_EMBEDDING_BACKGROUND 失败，按不透明度加权: %v", err)
+		return nil
+	}
+	return background
+}
+
+// ParseColor 解析 RRGGBB 格式的十六进制颜色，可以带 # 前缀，值为空时返回 nil
+func ParseColor(value string) (*color.NRGBA, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if value == "" {
+		return nil, nil
+	}
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil || len(value) != 6 {
+		return nil, fmt.Errorf("无效的颜色: %s", value)
+	}
+	return &color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// getDurationEnv 获取时长类型的环境变量，格式如 720h，不存在或无法解析时返回默认值
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.Warnf("解析 %s 失败，使用默认值 %s: %v", key, defaultValue, err)
+		return defaultValue
+	}
+	return duration
+}
+
 // getEnv 获取环境变量，如果不存在则返回默认值
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
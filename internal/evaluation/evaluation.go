@@ -0,0 +1,385 @@
+package evaluation
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/service"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// distanceTolerance 判断近似检索结果是否命中真实近邻时允许的距离误差，用于处理距离相同的并列结果
+const distanceTolerance = 1e-6
+
+// Sample 数据集中的一张带标签图片
+type Sample struct {
+	Path  string `json:"path"`
+	Class string `json:"class"`
+}
+
+// Options 评测选项
+type Options struct {
+	// Ks 需要统计 precision@k、recall@k 和 ANN recall@k 的 k 值
+	Ks []int
+	// MapDepth 计算 mAP 时检索的结果数量
+	MapDepth int
+}
+
+// Report 评测报告
+type Report struct {
+	Dataset    DatasetSummary `json:"dataset"`
+	Metric     string         `json:"metric"`
+	Index      string         `json:"index"`
+	Queries    int            `json:"queries"`
+	MapDepth   int            `json:"map_depth"`
+	MAP        float64        `json:"map"`
+	AtK        []KMetrics     `json:"at_k"`
+	Latency    LatencySummary `json:"query_latency_ms"`
+	Ingest     LatencySummary `json:"ingest_latency_ms"`
+	FailedRuns int            `json:"failed_queries"`
+}
+
+// DatasetSummary 数据集概况
+type DatasetSummary struct {
+	Classes  int `json:"classes"`
+	Images   int `json:"images"`
+	Ingested int `json:"ingested"`
+	Skipped  int `json:"skipped"`
+}
+
+// KMetrics 某个 k 值下的检索指标
+type KMetrics struct {
+	K         int     `json:"k"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	ANNRecall float64 `json:"ann_recall"`
+}
+
+// LatencySummary 耗时分布（毫秒）
+type LatencySummary struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// ingested 已入库的样本
+type ingested struct {
+	id        uuid.UUID
+	class     string
+	embedding []float32
+}
+
+// LoadDataset 读取带标签的数据集目录，每个子目录为一个类别
+func LoadDataset(dir string) ([]Sample, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		class := entry.Name()
+		files, err := os.ReadDir(filepath.Join(dir, class))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			samples = append(samples, Sample{
+				Path:  filepath.Join(dir, class, file.Name()),
+				Class: class,
+			})
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("数据集目录中没有图片: %s", dir)
+	}
+	return samples, nil
+}
+
+// Run 将样本导入服务，并以留一法逐张查询，统计检索质量和耗时
+func Run(svc service.ImageService, repo repository.ImageRepository, samples []Sample, opts Options) (*Report, error) {
+	maxK := 0
+	for _, k := range opts.Ks {
+		if k > maxK {
+			maxK = k
+		}
+	}
+	depth := opts.MapDepth
+	if depth < maxK {
+		depth = maxK
+	}
+	if depth > service.MaxSearchLimit || maxK > service.MaxSearchLimit {
+		return nil, fmt.Errorf("k 和 mAP 检索深度不能超过 %d", service.MaxSearchLimit)
+	}
+
+	report := &Report{MapDepth: depth}
+	classes := make(map[string]bool)
+	for _, sample := range samples {
+		classes[sample.Class] = true
+	}
+	report.Dataset.Classes = len(classes)
+	report.Dataset.Images = len(samples)
+
+	// 导入样本
+	var items []ingested
+	var ingestLatencies []time.Duration
+	for _, sample := range samples {
+		start := time.Now()
+		id, err := ingest(svc, sample)
+		if err != nil {
+			logrus.Warnf("导入样本失败，已跳过 %s: %v", sample.Path, err)
+			report.Dataset.Skipped++
+			continue
+		}
+		ingestLatencies = append(ingestLatencies, time.Since(start))
+
+		embedding, err := repo.GetImageEmbeddingByImageID(id)
+		if err != nil {
+			return nil, fmt.Errorf("获取样本嵌入向量失败 %s: %w", sample.Path, err)
+		}
+		items = append(items, ingested{id: id, class: sample.Class, embedding: embedding.Embedding})
+	}
+	report.Dataset.Ingested = len(items)
+	report.Ingest = summarizeLatencies(ingestLatencies)
+
+	classSizes := make(map[string]int)
+	for _, item := range items {
+		classSizes[item.class]++
+	}
+	classOf := make(map[uuid.UUID]string, len(items))
+	for _, item := range items {
+		classOf[item.id] = item.class
+	}
+
+	precisionSum := make([]float64, len(opts.Ks))
+	recallSum := make([]float64, len(opts.Ks))
+	annRecallSum := make([]float64, len(opts.Ks))
+	var apSum float64
+	var queryLatencies []time.Duration
+
+	// 留一法查询：每张图片作为查询，其余图片作为检索库
+	for _, query := range items {
+		relevant := classSizes[query.class] - 1
+		if relevant == 0 {
+			continue // 类别中只有一张图片时没有可召回的结果
+		}
+
+		searchOpts := service.SearchOptions{Explain: true}
+		searchOpts.Limit = depth
+		start := time.Now()
+		outcome, err := svc.SearchSimilarByImageID(query.id, searchOpts)
+		if err != nil {
+			logrus.Warnf("查询失败 %s: %v", query.id, err)
+			report.FailedRuns++
+			continue
+		}
+		queryLatencies = append(queryLatencies, time.Since(start))
+		report.Queries++
+		if outcome.Explain != nil {
+			report.Metric = outcome.Explain.Metric
+			report.Index = outcome.Explain.Index
+		}
+
+		hits := make([]bool, len(outcome.Matches))
+		for i, match := range outcome.Matches {
+			hits[i] = classOf[match.Image.ID] == query.class
+		}
+		apSum += averagePrecision(hits, relevant)
+
+		exact := bruteForceDistances(query, items)
+		for i, k := range opts.Ks {
+			found := 0
+			for j := 0; j < k && j < len(hits); j++ {
+				if hits[j] {
+					found++
+				}
+			}
+			precisionSum[i] += float64(found) / float64(k)
+			recallSum[i] += float64(found) / float64(relevant)
+			annRecallSum[i] += annRecall(outcome.Matches, exact, k)
+		}
+	}
+
+	if report.Queries > 0 {
+		n := float64(report.Queries)
+		report.MAP = apSum / n
+		for i, k := range opts.Ks {
+			report.AtK = append(report.AtK, KMetrics{
+				K:         k,
+				Precision: precisionSum[i] / n,
+				Recall:    recallSum[i] / n,
+				ANNRecall: annRecallSum[i] / n,
+			})
+		}
+	}
+	report.Latency = summarizeLatencies(queryLatencies)
+
+	return report, nil
+}
+
+// ingest 通过服务的上传流程导入一张样本图片
+func ingest(svc service.ImageService, sample Sample) (uuid.UUID, error) {
+	file, err := os.Open(sample.Path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	image, err := svc.UploadImage(file, &multipart.FileHeader{
+		Filename: filepath.Base(sample.Path),
+		Size:     info.Size(),
+	}, "")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	// 重复的图片会返回已有记录，同一张图片不能重复参与评测
+	if image.Duplicate {
+		return uuid.Nil, fmt.Errorf("与已导入的图片内容重复: %s", image.ID)
+	}
+	return image.ID, nil
+}
+
+// bruteForceDistances 暴力计算查询与其余所有样本的距离，按升序返回，作为近邻检索的真实结果
+func bruteForceDistances(query ingested, items []ingested) []float32 {
+	distances := make([]float32, 0, len(items))
+	for _, item := range items {
+		if item.id == query.id {
+			continue
+		}
+		distances = append(distances, repository.EuclideanDistance(query.embedding, item.embedding))
+	}
+	sort.Slice(distances, func(i, j int) bool { return distances[i] < distances[j] })
+	return distances
+}
+
+// annRecall 计算检索结果前 k 个中落在真实前 k 近邻范围内的比例
+// 以第 k 个真实近邻的距离为界判断命中，避免距离并列时误判
+func annRecall(matches []service.SearchMatch, exact []float32, k int) float64 {
+	if k > len(exact) {
+		k = len(exact)
+	}
+	if k == 0 {
+		return 1
+	}
+	bound := exact[k-1] + distanceTolerance
+
+	found := 0
+	for i := 0; i < k && i < len(matches); i++ {
+		if matches[i].Distance <= bound {
+			found++
+		}
+	}
+	return float64(found) / float64(k)
+}
+
+// averagePrecision 计算单次查询的平均精度，relevant 为相关结果总数
+func averagePrecision(hits []bool, relevant int) float64 {
+	if relevant > len(hits) {
+		relevant = len(hits)
+	}
+	if relevant == 0 {
+		return 0
+	}
+
+	var sum float64
+	found := 0
+	for i, hit := range hits {
+		if hit {
+			found++
+			sum += float64(found) / float64(i+1)
+		}
+	}
+	return sum / float64(relevant)
+}
+
+// summarizeLatencies 统计耗时分布
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	return LatencySummary{
+		Mean: toMillis(total / time.Duration(len(sorted))),
+		P50:  toMillis(percentile(sorted, 0.50)),
+		P90:  toMillis(percentile(sorted, 0.90)),
+		P99:  toMillis(percentile(sorted, 0.99)),
+		Max:  toMillis(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile 使用最近秩法计算已排序耗时的百分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// toMillis 将耗时转换为毫秒
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteTable 以便于阅读的表格形式输出评测报告
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "数据集\t%d 个类别，%d 张图片（导入 %d，跳过 %d）\n",
+		r.Dataset.Classes, r.Dataset.Images, r.Dataset.Ingested, r.Dataset.Skipped)
+	fmt.Fprintf(tw, "度量 / 索引\t%s / %s\n", r.Metric, r.Index)
+	fmt.Fprintf(tw, "查询数\t%d（失败 %d）\n", r.Queries, r.FailedRuns)
+	fmt.Fprintf(tw, "mAP@%d\t%.4f\n", r.MapDepth, r.MAP)
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "k\tprecision@k\trecall@k\tANN recall@k")
+	for _, m := range r.AtK {
+		fmt.Fprintf(tw, "%d\t%.4f\t%.4f\t%.4f\n", m.K, m.Precision, m.Recall, m.ANNRecall)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "耗时 (ms)\tmean\tp50\tp90\tp99\tmax")
+	for _, row := range []struct {
+		name string
+		l    LatencySummary
+	}{{"查询", r.Latency}, {"导入", r.Ingest}} {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n", row.name, row.l.Mean, row.l.P50, row.l.P90, row.l.P99, row.l.Max)
+	}
+
+	return tw.Flush()
+}
@@ -1,23 +1,127 @@
 package model
 
 import (
+	"path/filepath"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// Image 图片模型
+// ImageURLPrefix 图片文件的访问路径前缀
+const ImageURLPrefix = "/images/"
+
+// DefaultNamespace 上传时未指定命名空间的图片所属的命名空间
+const DefaultNamespace = "default"
+
+// Image 图片模型，FilePath 指向保存的原图，处理流水线没有要求重新编码时为未经修改的上传文件
+// ContentHash 为上传文件内容的 SHA-256，用于去重；Duplicate 表示本次上传的内容与已有图片重复，不持久化
+// Namespace 图片所属的命名空间（团队或业务方），用于按命名空间统计配额和执行保留策略
+// KeyID 原图和衍生图片加密使用的密钥ID，未启用静态加密时为空
+// FrameCount 动图的帧数或多页 TIFF 的页数，其他图片为 1；Frames 为抽样后单独生成嵌入向量的帧
+// Processing 上传时处理流水线每一步的执行情况，流水线引入之前上传的图片为空
+// GeoDistance 按地理位置查询时图片拍摄地点与中心点的距离（米），不持久化
+// DeletedAt 不为空表示图片已移入回收站，默认查询会自动排除，超过保留期后由后台任务永久删除
 type Image struct {
+	ID          uuid.UUID        `gorm:"type:uuid;primary_key" json:"id"`
+	FileName    string           `gorm:"size:255;not null" json:"file_name"`
+	FilePath    string           `gorm:"size:255;not null" json:"file_path"`
+	Extension   string           `gorm:"size:10;not null" json:"extension"`
+	Width       int              `gorm:"not null" json:"width"`
+	Height      int              `gorm:"not null" json:"height"`
+	Size        int64            `gorm:"not null" json:"size"`
+	ContentHash string           `gorm:"size:64;index" json:"content_hash"`
+	Namespace   string           `gorm:"size:64;not null;default:'default';index" json:"namespace"`
+	KeyID       string           `gorm:"size:64;index" json:"key_id,omitempty"`
+	CreatedAt   time.Time        `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time        `gorm:"not null" json:"updated_at"`
+	DeletedAt   gorm.DeletedAt   `gorm:"index" json:"-"`
+	URL         string           `gorm:"-" json:"url"`
+	Renditions  []Rendition      `gorm:"foreignKey:ImageID" json:"renditions,omitempty"`
+	Metadata    *ImageMetadata   `gorm:"foreignKey:ImageID" json:"metadata,omitempty"`
+	FrameCount  int              `gorm:"not null;default:1" json:"frame_count"`
+	Frames      []ImageFrame     `gorm:"foreignKey:ImageID" json:"frames,omitempty"`
+	Processing  []ProcessingStep `gorm:"type:text;serializer:json" json:"processing,omitempty"`
+	Duplicate   bool             `gorm:"-" json:"duplicate"`
+	GeoDistance *float64         `gorm:"-" json:"geo_distance,omitempty"`
+}
+
+// Rendition 由原图生成的衍生图片，如缩略图
+type Rendition struct {
 	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
-	FileName  string    `gorm:"size:255;not null" json:"file_name"`
+	ImageID   uuid.UUID `gorm:"type:uuid;not null;index" json:"image_id"`
+	Name      string    `gorm:"size:50;not null" json:"name"`
 	FilePath  string    `gorm:"size:255;not null" json:"file_path"`
-	Extension string    `gorm:"size:10;not null" json:"extension"`
+	Format    string    `gorm:"size:10;not null" json:"format"`
 	Width     int       `gorm:"not null" json:"width"`
 	Height    int       `gorm:"not null" json:"height"`
 	Size      int64     `gorm:"not null" json:"size"`
 	CreatedAt time.Time `gorm:"not null" json:"created_at"`
-	UpdatedAt time.Time `gorm:"not null" json:"updated_at"`
+	URL       string    `gorm:"-" json:"url"`
+}
+
+// ImageMetadata 从原图 EXIF 中提取的拍摄信息，没有 EXIF 也没有 ICC 配置文件的图片没有该记录
+// CapturedAt 为相机记录的拍摄时间，EXIF 不含时区，按 UTC 保存相机上的本地时间
+// ExposureTime 为曝光时间（秒），FocalLength 为焦距（毫米），没有记录的数值字段为 0
+// Latitude、Longitude 为 WGS84 坐标（度），Altitude 为海拔（米），没有 GPS 信息时为空
+// Geohash 为坐标的 geohash，用作按地理位置查询的空间索引，没有 GPS 信息时为空
+// ColorProfile 为原图嵌入的 ICC 配置文件名称（如 Adobe RGB (1998)、Display P3），衍生图片和嵌入向量均已转换为 sRGB
+type ImageMetadata struct {
+	ImageID      uuid.UUID  `gorm:"type:uuid;primary_key" json:"-"`
+	Orientation  int        `gorm:"not null;default:1" json:"orientation"`
+	CapturedAt   *time.Time `gorm:"index" json:"captured_at,omitempty"`
+	CameraMake   string     `gorm:"size:128" json:"camera_make,omitempty"`
+	CameraModel  string     `gorm:"size:128" json:"camera_model,omitempty"`
+	LensModel    string     `gorm:"size:128" json:"lens_model,omitempty"`
+	ExposureTime float64    `json:"exposure_time,omitempty"`
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+	Altitude     *float64   `json:"altitude,omitempty"`
+	Geohash      string     `gorm:"size:12;index" json:"geohash,omitempty"`
+	ColorProfile string     `gorm:"size:255" json:"color_profile,omitempty"`
+	CreatedAt    time.Time  `gorm:"not null" json:"-"`
+}
+
+// ImageFrame 动图中的一帧或多页 TIFF 中的一页，每帧单独生成嵌入向量，搜索时返回最匹配的帧
+// Index 为帧在原图中的序号（从 0 开始），帧数超过上限时均匀抽样，序号不连续
+// Delay 为 GIF 帧的显示时长（毫秒），TIFF 页为 0
+type ImageFrame struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"-"`
+	ImageID   uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	Index     int       `gorm:"column:frame_index;not null" json:"index"`
+	Delay     int       `gorm:"not null;default:0" json:"delay_ms,omitempty"`
+	Embedding []float32 `gorm:"type:blob;serializer:json;not null" json:"-"`
+	CreatedAt time.Time `gorm:"not null" json:"-"`
+}
+
+// ProcessingStep 处理流水线中一步的执行情况
+// Step 为步骤在配置中的写法（如 resize:800），Applied 表示该步骤是否改变了图片，Detail 为具体的变化或没有执行的原因
+type ProcessingStep struct {
+	Step    string `json:"step"`
+	Applied bool   `json:"applied"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Blob 对象存储中的文件，按内容哈希寻址，被多条图片记录共享时通过引用计数管理生命周期
+type Blob struct {
+	Key         string    `gorm:"size:255;primary_key" json:"key"`
+	ContentHash string    `gorm:"size:64;index" json:"content_hash"`
+	Size        int64     `gorm:"not null" json:"size"`
+	RefCount    int       `gorm:"not null;default:0" json:"ref_count"`
+	CreatedAt   time.Time `gorm:"not null" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// StagedBlob 上传过程中准备写入对象存储、但图片记录尚未提交的文件
+// 写入文件前先登记，图片记录提交时在同一事务中删除；进程崩溃后残留的记录用于启动时清理未提交的文件
+type StagedBlob struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UploadID  uuid.UUID `gorm:"type:uuid;not null;index" json:"upload_id"`
+	Key       string    `gorm:"size:255;not null;index" json:"key"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
 }
 
 // ImageEmbedding 图片嵌入向量模型
@@ -30,6 +134,52 @@ type ImageEmbedding struct {
 	Image     Image     `gorm:"foreignKey:ImageID" json:"image,omitempty"`
 }
 
+// StorageKey 返回图片文件在对象存储中的键
+// 早期版本的 FilePath 保存的是本地路径，文件名部分即为对象键
+func (i *Image) StorageKey() string {
+	return filepath.Base(i.FilePath)
+}
+
+// AfterFind 查询后的钩子函数，用于生成访问URL
+func (i *Image) AfterFind(tx *gorm.DB) error {
+	i.URL = ImageURLPrefix + i.StorageKey()
+	return nil
+}
+
+// AfterCreate 创建后的钩子函数，用于生成访问URL
+func (i *Image) AfterCreate(tx *gorm.DB) error {
+	return i.AfterFind(tx)
+}
+
+// RenditionByName 根据名称查找衍生图片
+func (i *Image) RenditionByName(name string) *Rendition {
+	for idx := range i.Renditions {
+		if i.Renditions[idx].Name == name {
+			return &i.Renditions[idx]
+		}
+	}
+	return nil
+}
+
+// BeforeCreate 创建前的钩子函数，用于生成UUID
+func (r *Rendition) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// AfterFind 查询后的钩子函数，用于生成访问URL
+func (r *Rendition) AfterFind(tx *gorm.DB) error {
+	r.URL = ImageURLPrefix + r.FilePath
+	return nil
+}
+
+// AfterCreate 创建后的钩子函数，用于生成访问URL
+func (r *Rendition) AfterCreate(tx *gorm.DB) error {
+	return r.AfterFind(tx)
+}
+
 // BeforeCreate 创建前的钩子函数，用于生成UUID
 func (i *Image) BeforeCreate(tx *gorm.DB) error {
 	if i.ID == uuid.Nil {
@@ -38,6 +188,22 @@ func (i *Image) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate 创建前的钩子函数，用于生成UUID
+func (sb *StagedBlob) BeforeCreate(tx *gorm.DB) error {
+	if sb.ID == uuid.Nil {
+		sb.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate 创建前的钩子函数，用于生成UUID
+func (f *ImageFrame) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
+
 // BeforeCreate 创建前的钩子函数，用于生成UUID
 func (ie *ImageEmbedding) BeforeCreate(tx *gorm.DB) error {
 	if ie.ID == uuid.Nil {
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// eachImageBatchSize 遍历图片时每批查询的数量
+const eachImageBatchSize = 500
+
+// EmbeddingRecord 未解析的嵌入向量记录，用于检查格式错误的数据
+type EmbeddingRecord struct {
+	ID        uuid.UUID
+	ImageID   uuid.UUID
+	Embedding []byte
+}
+
+// EachImage 分批遍历所有图片（包括回收站中的图片）及其衍生图片，fn 返回错误时停止遍历
+func (r *imageRepository) EachImage(fn func(*model.Image) error) error {
+	var images []*model.Image
+	var fnErr error
+	result := r.DB.Unscoped().Preload("Renditions").Order("id").FindInBatches(&images, eachImageBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, image := range images {
+			if fnErr = fn(image); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return result.Error
+}
+
+// SetImageKeyID 更新图片（包括回收站中的图片）文件加密使用的密钥ID，不修改更新时间
+func (r *imageRepository) SetImageKeyID(id uuid.UUID, keyID string) error {
+	return r.DB.Unscoped().Model(&model.Image{}).Where("id = ?", id).UpdateColumn("key_id", keyID).Error
+}
+
+// ListEmbeddingRecords 列出所有嵌入向量记录的原始数据
+func (r *imageRepository) ListEmbeddingRecords() ([]EmbeddingRecord, error) {
+	var records []EmbeddingRecord
+	if err := r.DB.Table("image_embeddings").Order("created_at").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeleteEmbedding 删除一条嵌入向量记录
+func (r *imageRepository) DeleteEmbedding(id uuid.UUID) error {
+	return r.DB.Delete(&model.ImageEmbedding{}, "id = ?", id).Error
+}
+
+// DeleteRendition 删除衍生图片记录并减少其文件的引用计数，文件不再被引用时返回 true
+func (r *imageRepository) DeleteRendition(id uuid.UUID) (bool, error) {
+	var orphaned bool
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var rendition model.Rendition
+		if err := tx.First(&rendition, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&model.Rendition{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		var err error
+		orphaned, err = releaseBlob(tx, rendition.FilePath)
+		return err
+	})
+	return orphaned, err
+}
+
+// ListBlobs 列出所有文件记录
+func (r *imageRepository) ListBlobs() ([]model.Blob, error) {
+	var blobs []model.Blob
+	if err := r.DB.Order("key").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// SetBlobRefCount 将文件记录的引用计数设置为指定值，记录不存在时创建，计数为 0 时删除记录
+func (r *imageRepository) SetBlobRefCount(blob model.Blob) error {
+	if blob.RefCount <= 0 {
+		return r.DB.Delete(&model.Blob{}, "key = ?", blob.Key).Error
+	}
+
+	now := time.Now()
+	if blob.CreatedAt.IsZero() {
+		blob.CreatedAt = now
+	}
+	blob.UpdatedAt = now
+	return r.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  blob.RefCount,
+			"updated_at": now,
+		}),
+	}).Create(&blob).Error
+}
@@ -48,11 +48,20 @@ func (d *Database) AutoMigrate() error {
 	err := d.DB.AutoMigrate(
 		&model.Image{},
 		&model.ImageEmbedding{},
+		&model.Blob{},
+		&model.Rendition{},
+		&model.StagedBlob{},
+		&model.ImageMetadata{},
+		&model.ImageFrame{},
 	)
 	if err != nil {
 		logrus.Errorf("自动迁移数据库表结构失败: %v", err)
 		return err
 	}
+	if err := d.BackfillGeohash(); err != nil {
+		logrus.Errorf("计算 geohash 失败: %v", err)
+		return err
+	}
 
 	logrus.Info("数据库表结构迁移成功")
 	return nil
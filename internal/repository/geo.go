@@ -0,0 +1,353 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// GeohashPrecision 保存的 geohash 长度，12 位精度约为 4 厘米
+	GeohashPrecision = 12
+	// earthRadius 地球平均半径（米）
+	earthRadius = 6371008.8
+	// maxGeohashCells 查询时覆盖范围使用的 geohash 前缀数量上限，超过时降低前缀精度
+	maxGeohashCells = 32
+	// geohashAlphabet geohash 使用的 base32 字符表，按 ASCII 升序排列，前缀范围查询依赖该顺序
+	geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// GeoCircle 以中心点和半径表示的圆形范围，坐标为 WGS84（度），半径单位为米
+type GeoCircle struct {
+	Lat    float64
+	Lon    float64
+	Radius float64
+}
+
+// GeoBox 以西南角和东北角表示的矩形范围，MinLon 大于 MaxLon 时表示跨越 180 度经线
+type GeoBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// GeoFilter 按拍摄地点筛选图片，只匹配 EXIF 中包含 GPS 坐标的图片
+// Near 和 Within 同时设置时需要同时满足；设置 Near 时结果按与中心点的距离升序排列
+type GeoFilter struct {
+	Near   *GeoCircle
+	Within *GeoBox
+}
+
+// Validate 检查坐标和半径是否有效
+func (f *GeoFilter) Validate() error {
+	if f.Near != nil {
+		if !validLatLon(f.Near.Lat, f.Near.Lon) {
+			return fmt.Errorf("无效的坐标: %g,%g", f.Near.Lat, f.Near.Lon)
+		}
+		if !(f.Near.Radius > 0) || math.IsInf(f.Near.Radius, 0) {
+			return fmt.Errorf("无效的半径: %g", f.Near.Radius)
+		}
+	}
+	if b := f.Within; b != nil {
+		if !validLatLon(b.MinLat, b.MinLon) || !validLatLon(b.MaxLat, b.MaxLon) || b.MinLat > b.MaxLat {
+			return fmt.Errorf("无效的矩形范围: %g,%g,%g,%g", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+		}
+	}
+	return nil
+}
+
+// Contains 判断坐标是否在范围内，设置 Near 时同时返回与中心点的距离（米）
+func (f *GeoFilter) Contains(lat, lon float64) (float64, bool) {
+	if b := f.Within; b != nil {
+		if lat < b.MinLat || lat > b.MaxLat {
+			return 0, false
+		}
+		if b.MinLon <= b.MaxLon && (lon < b.MinLon || lon > b.MaxLon) {
+			return 0, false
+		}
+		if b.MinLon > b.MaxLon && lon < b.MinLon && lon > b.MaxLon {
+			return 0, false
+		}
+	}
+	if c := f.Near; c != nil {
+		distance := HaversineDistance(c.Lat, c.Lon, lat, lon)
+		return distance, distance <= c.Radius
+	}
+	return 0, true
+}
+
+// boxes 返回覆盖过滤范围、不跨越 180 度经线的矩形，用于索引查询
+func (f *GeoFilter) boxes() []GeoBox {
+	// 同时设置 Near 和 Within 时只使用圆形范围的外接矩形，精确过滤交给 Contains
+	var box GeoBox
+	if f.Near != nil {
+		box = f.Near.bounds()
+	} else {
+		box = *f.Within
+	}
+
+	if box.MinLon > box.MaxLon {
+		return []GeoBox{
+			{MinLat: box.MinLat, MinLon: box.MinLon, MaxLat: box.MaxLat, MaxLon: 180},
+			{MinLat: box.MinLat, MinLon: -180, MaxLat: box.MaxLat, MaxLon: box.MaxLon},
+		}
+	}
+	return []GeoBox{box}
+}
+
+// bounds 返回圆形范围的外接矩形，范围包含极点时经度覆盖全部范围
+func (c *GeoCircle) bounds() GeoBox {
+	dLat := c.Radius / earthRadius * 180 / math.Pi
+	box := GeoBox{
+		MinLat: math.Max(c.Lat-dLat, -90),
+		MaxLat: math.Min(c.Lat+dLat, 90),
+		MinLon: -180,
+		MaxLon: 180,
+	}
+	if box.MinLat == -90 || box.MaxLat == 90 {
+		return box
+	}
+
+	// 外接矩形的经度范围由圆与经线的切点决定
+	ratio := math.Sin(c.Radius/earthRadius) / math.Cos(c.Lat*math.Pi/180)
+	if ratio >= 1 {
+		return box
+	}
+	dLon := math.Asin(ratio) * 180 / math.Pi
+	box.MinLon = normalizeLon(c.Lon - dLon)
+	box.MaxLon = normalizeLon(c.Lon + dLon)
+	return box
+}
+
+// HaversineDistance 计算两个坐标之间的球面距离（米）
+func HaversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(a, 1)))
+}
+
+// EncodeGeohash 计算坐标的 geohash
+func EncodeGeohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	hash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(hash) < precision {
+		// 偶数位编码经度，奇数位编码纬度
+		r, v := &latRange, lat
+		if even {
+			r, v = &lonRange, lon
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// geohashCellSize 返回指定长度的 geohash 单元格的高度和宽度（度）
+func geohashCellSize(precision int) (float64, float64) {
+	bits := precision * 5
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// geohashCover 返回覆盖矩形的 geohash 前缀，选择使前缀数量不超过上限的最高精度
+// 矩形不能跨越 180 度经线
+func geohashCover(box GeoBox) []string {
+	precision := GeohashPrecision
+	for ; precision > 1; precision-- {
+		height, width := geohashCellSize(precision)
+		rows := math.Floor(box.MaxLat/height) - math.Floor(box.MinLat/height) + 1
+		cols := math.Floor(box.MaxLon/width) - math.Floor(box.MinLon/width) + 1
+		if rows*cols <= maxGeohashCells {
+			break
+		}
+	}
+	height, width := geohashCellSize(precision)
+
+	// 按单元格尺寸取样，与矩形相交的每个单元格都至少包含一个取样点
+	seen := make(map[string]bool)
+	var prefixes []string
+	for lat := box.MinLat; ; lat += height {
+		lat = math.Min(lat, box.MaxLat)
+		for lon := box.MinLon; ; lon += width {
+			lon = math.Min(lon, box.MaxLon)
+			if hash := EncodeGeohash(lat, lon, precision); !seen[hash] {
+				seen[hash] = true
+				prefixes = append(prefixes, hash)
+			}
+			if lon >= box.MaxLon {
+				break
+			}
+		}
+		if lat >= box.MaxLat {
+			break
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// geoCandidate 满足地理位置过滤条件的图片
+type geoCandidate struct {
+	imageID  uuid.UUID
+	distance float64
+}
+
+// geoCandidates 通过 geohash 索引查询满足过滤条件的图片，按距离（设置 Near 时）或上传时间排列
+// geohash 前缀只用于缩小范围，每个坐标都会再精确判断是否在范围内
+func (r *imageRepository) geoCandidates(filter *GeoFilter, namespace string) ([]geoCandidate, error) {
+	type location struct {
+		ImageID   uuid.UUID
+		Latitude  float64
+		Longitude float64
+	}
+
+	// 每个前缀转换为范围条件，使 geohash 列上的索引可以生效；'~' 大于字符表中的所有字符
+	var conditions []string
+	var args []interface{}
+	for _, box := range filter.boxes() {
+		for _, prefix := range geohashCover(box) {
+			conditions = append(conditions, "(image_metadata.geohash >= ? AND image_metadata.geohash < ?)")
+			args = append(args, prefix, prefix+"~")
+		}
+	}
+
+	query := r.DB.Table("image_metadata").
+		Select("image_metadata.image_id, image_metadata.latitude, image_metadata.longitude").
+		Joins("JOIN images ON images.id = image_metadata.image_id AND images.deleted_at IS NULL").
+		Where("("+strings.Join(conditions, " OR ")+")", args...).
+		Order("images.created_at")
+	if namespace != "" {
+		query = query.Where("images.namespace = ?", namespace)
+	}
+
+	var locations []location
+	if err := query.Find(&locations).Error; err != nil {
+		return nil, err
+	}
+
+	candidates := make([]geoCandidate, 0, len(locations))
+	for _, l := range locations {
+		if distance, ok := filter.Contains(l.Latitude, l.Longitude); ok {
+			candidates = append(candidates, geoCandidate{imageID: l.ImageID, distance: distance})
+		}
+	}
+	if filter.Near != nil {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].distance < candidates[j].distance
+		})
+	}
+	return candidates, nil
+}
+
+// listImagesNear 按地理位置分页列出图片，total 为满足条件的图片总数
+func (r *imageRepository) listImagesNear(page, pageSize int, filter ListFilter) ([]*model.Image, int64, error) {
+	candidates, err := r.geoCandidates(filter.Geo, filter.Namespace)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(len(candidates))
+
+	offset := (page - 1) * pageSize
+	if offset >= len(candidates) {
+		return []*model.Image{}, total, nil
+	}
+	candidates = candidates[offset:]
+	if len(candidates) > pageSize {
+		candidates = candidates[:pageSize]
+	}
+
+	ids := make([]uuid.UUID, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.imageID
+	}
+	var found []*model.Image
+	if err := r.DB.Preload("Renditions").Preload("Metadata").Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// 按候选顺序返回
+	byID := make(map[uuid.UUID]*model.Image, len(found))
+	for _, image := range found {
+		byID[image.ID] = image
+	}
+	images := make([]*model.Image, 0, len(candidates))
+	for _, c := range candidates {
+		image, ok := byID[c.imageID]
+		if !ok {
+			continue
+		}
+		if filter.Geo.Near != nil {
+			distance := c.distance
+			image.GeoDistance = &distance
+		}
+		images = append(images, image)
+	}
+	return images, total, nil
+}
+
+// BackfillGeohash 为升级前已经保存了 GPS 坐标、但还没有 geohash 的拍摄信息计算 geohash
+func (d *Database) BackfillGeohash() error {
+	var metadata []*model.ImageMetadata
+	result := d.DB.Where("latitude IS NOT NULL AND longitude IS NOT NULL AND (geohash IS NULL OR geohash = '')").
+		FindInBatches(&metadata, eachImageBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, m := range metadata {
+				hash := EncodeGeohash(*m.Latitude, *m.Longitude, GeohashPrecision)
+				if err := d.DB.Model(&model.ImageMetadata{}).Where("image_id = ?", m.ImageID).
+					Update("geohash", hash).Error; err != nil {
+					return err
+				}
+			}
+			if len(metadata) > 0 {
+				logrus.Infof("已为 %d 条拍摄信息计算 geohash", len(metadata))
+			}
+			return nil
+		})
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return result.Error
+	}
+	return nil
+}
+
+// validLatLon 判断坐标是否在有效范围内
+func validLatLon(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// normalizeLon 将经度换算到 [-180, 180] 范围内
+func normalizeLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"encoding/json"
+	"errors"
 	"math"
 	"sort"
 	"time"
@@ -9,17 +10,122 @@ import (
 	"github.com/bytedance/ImageSearch/internal/model"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ImageRepository 图片仓库接口
 type ImageRepository interface {
-	CreateImage(image *model.Image) error
+	CreateImage(image *model.Image, embedding *model.ImageEmbedding, check UsageCheck) error
 	GetImageByID(id uuid.UUID) (*model.Image, error)
-	ListImages(page, pageSize int) ([]*model.Image, int64, error)
-	DeleteImage(id uuid.UUID) error
+	GetImageByContentHash(hash, namespace string) (*model.Image, error)
+	ListImages(page, pageSize int, filter ListFilter) ([]*model.Image, int64, error)
+	PurgeImage(id uuid.UUID) ([]string, error)
 	CreateImageEmbedding(embedding *model.ImageEmbedding) error
 	GetImageEmbeddingByImageID(imageID uuid.UUID) (*model.ImageEmbedding, error)
-	SearchSimilarImages(targetEmbedding []float32, limit int) ([]*model.Image, []float32, error)
+	SearchSimilarImages(targetEmbedding []float32, opts SearchOptions) ([]SearchHit, SearchStats, error)
+
+	// 一致性检查
+	EachImage(fn func(*model.Image) error) error
+	ListEmbeddingRecords() ([]EmbeddingRecord, error)
+	DeleteEmbedding(id uuid.UUID) error
+	DeleteRendition(id uuid.UUID) (bool, error)
+	ListBlobs() ([]model.Blob, error)
+	SetBlobRefCount(blob model.Blob) error
+
+	// 回收站
+	TrashImage(id uuid.UUID) error
+	RestoreImage(id uuid.UUID) error
+	GetDeletedImage(id uuid.UUID) (*model.Image, error)
+	ListDeletedImages(page, pageSize int) ([]*model.Image, int64, error)
+	ListExpiredImages(before time.Time, limit int) ([]uuid.UUID, error)
+
+	// 配额和保留策略
+	TotalUsage() (Usage, error)
+	NamespaceUsage(namespace string) (Usage, error)
+	ListNamespaceUsage() (map[string]Usage, error)
+	EachImageCreatedBefore(before time.Time, filter NamespaceFilter, fn func(*model.Image) error) error
+
+	// 静态加密
+	SetImageKeyID(id uuid.UUID, keyID string) error
+
+	// 上传暂存
+	StageBlobs(uploadID uuid.UUID, keys []string) error
+	CommitUpload(uploadID uuid.UUID, image *model.Image, embedding *model.ImageEmbedding, check UsageCheck) error
+	UncommittedBlobs(uploadID uuid.UUID) ([]string, error)
+	UnreferencedBlobs(keys []string) ([]string, error)
+	DeleteStagedUpload(uploadID uuid.UUID) error
+	ListStagedUploads(before time.Time) ([]uuid.UUID, error)
+	ListStagedKeys() ([]string, error)
+}
+
+const (
+	// MetricEuclidean 欧几里得距离度量
+	MetricEuclidean = "euclidean"
+	// IndexFlat 暴力扫描全部嵌入向量（无近似索引）
+	IndexFlat = "flat"
+)
+
+// SearchStats 相似图片搜索的统计信息，用于 explain 模式排查问题
+type SearchStats struct {
+	Metric string
+	Index  string
+	// Scanned 扫描的嵌入向量数量
+	Scanned int
+	// Frames 扫描的帧嵌入向量数量
+	Frames int
+	// Malformed 解析失败被跳过的嵌入向量数量
+	Malformed int
+	// Excluded 被显式排除的数量
+	Excluded int
+	// OverThreshold 超过最大距离阈值的数量
+	OverThreshold int
+	// Hydrated 查询过图片信息的候选数量
+	Hydrated int
+	// Filtered 被图片属性过滤条件排除或已不存在的数量
+	Filtered int
+	// OutsideGeo 不满足地理位置过滤条件（包括没有 GPS 坐标）而未计算距离的数量
+	OutsideGeo int
+	// ScanDuration 加载嵌入向量、计算距离并排序的耗时
+	ScanDuration time.Duration
+	// HydrateDuration 查询图片信息的耗时
+	HydrateDuration time.Duration
+}
+
+// SearchHit 相似图片搜索命中结果，动图和多页 TIFF 的距离和嵌入向量为最匹配的帧
+type SearchHit struct {
+	Image     *model.Image
+	Distance  float32
+	Embedding []float32
+	// Frame 最匹配的帧序号，单帧图片为 0
+	Frame int
+}
+
+// SearchOptions 相似图片搜索选项
+type SearchOptions struct {
+	// Limit 返回结果的最大数量
+	Limit int
+	// MaxDistance 最大距离阈值，0 表示不限制
+	MaxDistance float32
+	// Extensions 只返回指定格式的图片，为空表示不限制
+	Extensions []string
+	// MinWidth 最小宽度，0 表示不限制
+	MinWidth int
+	// MinHeight 最小高度，0 表示不限制
+	MinHeight int
+	// ExcludeIDs 需要从结果中排除的图片ID
+	ExcludeIDs []uuid.UUID
+	// Namespace 只返回指定命名空间的图片，为空表示不限制
+	Namespace string
+	// Geo 只返回拍摄地点在指定范围内的图片，为空表示不限制
+	Geo *GeoFilter
+}
+
+// ListFilter 图片列表过滤条件
+type ListFilter struct {
+	// Namespace 只列出指定命名空间的图片，为空表示所有命名空间
+	Namespace string
+	// Geo 只列出拍摄地点在指定范围内的图片，设置 Near 时按距离排序
+	Geo *GeoFilter
 }
 
 // imageRepository 图片仓库实现
@@ -34,32 +140,86 @@ func NewImageRepository(db *Database) ImageRepository {
 	}
 }
 
-// CreateImage 创建图片记录
-func (r *imageRepository) CreateImage(image *model.Image) error {
-	return r.DB.Create(image).Error
+// CreateImage 在同一事务中创建图片记录、衍生图片记录和嵌入向量，并增加其引用文件的引用计数
+// check 不为 nil 时在同一事务中检查创建后的用量
+func (r *imageRepository) CreateImage(image *model.Image, embedding *model.ImageEmbedding, check UsageCheck) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return createImage(tx, image, embedding, check)
+	})
 }
 
-// GetImageByID 根据ID获取图片
+// createImage 在事务中创建图片记录、衍生图片记录、拍摄信息、帧记录和嵌入向量，embedding 的 ImageID 由图片记录的ID填充
+// check 不为 nil 时在创建之后检查用量
+func createImage(tx *gorm.DB, image *model.Image, embedding *model.ImageEmbedding, check UsageCheck) error {
+	if err := tx.Create(image).Error; err != nil {
+		return err
+	}
+
+	if err := acquireBlob(tx, image.StorageKey(), image.ContentHash, image.Size); err != nil {
+		return err
+	}
+	for _, rendition := range image.Renditions {
+		if err := acquireBlob(tx, rendition.FilePath, "", rendition.Size); err != nil {
+			return err
+		}
+	}
+
+	embedding.ImageID = image.ID
+	if err := createEmbedding(tx, embedding); err != nil {
+		return err
+	}
+	if check == nil {
+		return nil
+	}
+	return check(&imageRepository{DB: tx})
+}
+
+// GetImageByID 根据ID获取图片及其衍生图片、拍摄信息和帧
 func (r *imageRepository) GetImageByID(id uuid.UUID) (*model.Image, error) {
 	var image model.Image
-	result := r.DB.First(&image, "id = ?", id)
+	result := r.DB.Preload("Renditions").Preload("Metadata").Preload("Frames").First(&image, "id = ?", id)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &image, nil
 }
 
-// ListImages 列出图片
-func (r *imageRepository) ListImages(page, pageSize int) ([]*model.Image, int64, error) {
+// GetImageByContentHash 根据内容哈希获取最早上传的图片，namespace 为空时不限制命名空间
+func (r *imageRepository) GetImageByContentHash(hash, namespace string) (*model.Image, error) {
+	var image model.Image
+	query := r.DB.Preload("Renditions").Preload("Metadata").Preload("Frames").Order("created_at")
+	if namespace != "" {
+		query = query.Where("namespace = ?", namespace)
+	}
+	result := query.First(&image, "content_hash = ?", hash)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &image, nil
+}
+
+// ListImages 列出图片，设置地理位置过滤条件时通过 geohash 索引查询
+func (r *imageRepository) ListImages(page, pageSize int, filter ListFilter) ([]*model.Image, int64, error) {
+	if filter.Geo != nil {
+		return r.listImagesNear(page, pageSize, filter)
+	}
+
 	var images []*model.Image
 	var total int64
 
+	scope := func(db *gorm.DB) *gorm.DB {
+		if filter.Namespace != "" {
+			return db.Where("namespace = ?", filter.Namespace)
+		}
+		return db
+	}
+
 	// 计算总数
-	r.DB.Model(&model.Image{}).Count(&total)
+	r.DB.Model(&model.Image{}).Scopes(scope).Count(&total)
 
 	// 分页查询
 	offset := (page - 1) * pageSize
-	result := r.DB.Offset(offset).Limit(pageSize).Find(&images)
+	result := r.DB.Preload("Renditions").Scopes(scope).Offset(offset).Limit(pageSize).Find(&images)
 	if result.Error != nil {
 		return nil, 0, result.Error
 	}
@@ -67,32 +227,125 @@ func (r *imageRepository) ListImages(page, pageSize int) ([]*model.Image, int64,
 	return images, total, nil
 }
 
-// DeleteImage 删除图片
-func (r *imageRepository) DeleteImage(id uuid.UUID) error {
+// PurgeImage 永久删除图片（包括回收站中的图片）及其衍生图片记录、拍摄信息、帧记录和嵌入向量
+// 返回不再被任何图片引用的文件，调用方应删除这些文件
+func (r *imageRepository) PurgeImage(id uuid.UUID) ([]string, error) {
+	var orphaned []string
+
 	// 开启事务
-	return r.DB.Transaction(func(tx *gorm.DB) error {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var image model.Image
+		if err := tx.Unscoped().Preload("Renditions").First(&image, "id = ?", id).Error; err != nil {
+			return err
+		}
+
 		// 删除图片嵌入向量
 		if err := tx.Where("image_id = ?", id).Delete(&model.ImageEmbedding{}).Error; err != nil {
 			return err
 		}
 
+		// 删除衍生图片记录
+		if err := tx.Where("image_id = ?", id).Delete(&model.Rendition{}).Error; err != nil {
+			return err
+		}
+
+		// 删除拍摄信息
+		if err := tx.Where("image_id = ?", id).Delete(&model.ImageMetadata{}).Error; err != nil {
+			return err
+		}
+
+		// 删除帧记录
+		if err := tx.Where("image_id = ?", id).Delete(&model.ImageFrame{}).Error; err != nil {
+			return err
+		}
+
 		// 删除图片记录
-		if err := tx.Delete(&model.Image{}, "id = ?", id).Error; err != nil {
+		if err := tx.Unscoped().Delete(&model.Image{}, "id = ?", id).Error; err != nil {
 			return err
 		}
 
+		// 减少引用计数
+		keys := []string{image.StorageKey()}
+		for _, rendition := range image.Renditions {
+			keys = append(keys, rendition.FilePath)
+		}
+		for _, key := range keys {
+			released, err := releaseBlob(tx, key)
+			if err != nil {
+				return err
+			}
+			if released {
+				orphaned = append(orphaned, key)
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return orphaned, nil
+}
+
+// acquireBlob 增加文件的引用计数，文件记录不存在时创建
+func acquireBlob(tx *gorm.DB, key, contentHash string, size int64) error {
+	blob := &model.Blob{
+		Key:         key,
+		ContentHash: contentHash,
+		Size:        size,
+		RefCount:    1,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("ref_count + 1"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(blob).Error
+}
+
+// releaseBlob 减少文件的引用计数，计数归零时删除文件记录并返回 true
+// 早期上传的图片没有对应的文件记录，视为没有其他引用
+func releaseBlob(tx *gorm.DB, key string) (bool, error) {
+	if err := tx.Model(&model.Blob{}).Where("key = ?", key).
+		Update("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		return false, err
+	}
+
+	var blob model.Blob
+	err := tx.First(&blob, "key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+	return true, tx.Delete(&model.Blob{}, "key = ?", key).Error
 }
 
 // CreateImageEmbedding 创建图片嵌入向量
 func (r *imageRepository) CreateImageEmbedding(embedding *model.ImageEmbedding) error {
+	return createEmbedding(r.DB, embedding)
+}
+
+// createEmbedding 使用指定的连接或事务创建图片嵌入向量
+func createEmbedding(db *gorm.DB, embedding *model.ImageEmbedding) error {
 	// 将浮点数数组转换为JSON字符串
 	embeddingJSON, err := json.Marshal(embedding.Embedding)
 	if err != nil {
 		return err
 	}
 	
+	// 临时结构体不会触发模型的 BeforeCreate 钩子，需要在这里生成ID
+	if embedding.ID == uuid.Nil {
+		embedding.ID = uuid.New()
+	}
+
 	// 创建一个临时结构体用于数据库操作
 	type TempEmbedding struct {
 		ID        uuid.UUID
@@ -110,7 +363,7 @@ func (r *imageRepository) CreateImageEmbedding(embedding *model.ImageEmbedding)
 		UpdatedAt: embedding.UpdatedAt,
 	}
 	
-	return r.DB.Table("image_embeddings").Create(&temp).Error
+	return db.Table("image_embeddings").Create(&temp).Error
 }
 
 // GetImageEmbeddingByImageID 根据图片ID获取嵌入向量
@@ -149,7 +402,13 @@ func (r *imageRepository) GetImageEmbeddingByImageID(imageID uuid.UUID) (*model.
 }
 
 // SearchSimilarImages 搜索相似图片
-func (r *imageRepository) SearchSimilarImages(targetEmbedding []float32, limit int) ([]*model.Image, []float32, error) {
+func (r *imageRepository) SearchSimilarImages(targetEmbedding []float32, opts SearchOptions) ([]SearchHit, SearchStats, error) {
+	stats := SearchStats{
+		Metric: MetricEuclidean,
+		Index:  IndexFlat,
+	}
+	scanStart := time.Now()
+
 	// 获取所有图片嵌入向量
 	type TempEmbedding struct {
 		ID        uuid.UUID
@@ -159,14 +418,16 @@ func (r *imageRepository) SearchSimilarImages(targetEmbedding []float32, limit i
 	
 	var tempEmbeddings []*TempEmbedding
 	if err := r.DB.Table("image_embeddings").Find(&tempEmbeddings).Error; err != nil {
-		return nil, nil, err
+		return nil, stats, err
 	}
+	stats.Scanned = len(tempEmbeddings)
 	
 	// 转换为model.ImageEmbedding格式
 	embeddings := make([]*model.ImageEmbedding, len(tempEmbeddings))
 	for i, temp := range tempEmbeddings {
 		var embeddingData []float32
 		if err := json.Unmarshal(temp.Embedding, &embeddingData); err != nil {
+			stats.Malformed++
 			continue // 跳过解析失败的嵌入向量
 		}
 		embeddings[i] = &model.ImageEmbedding{
@@ -178,47 +439,139 @@ func (r *imageRepository) SearchSimilarImages(targetEmbedding []float32, limit i
 
 	// 计算距离并排序
 	type imageDistance struct {
-		imageID  uuid.UUID
-		distance float32
+		imageID   uuid.UUID
+		distance  float32
+		embedding []float32
+		frame     int
+	}
+
+	excluded := make(map[uuid.UUID]bool, len(opts.ExcludeIDs))
+	for _, id := range opts.ExcludeIDs {
+		excluded[id] = true
+	}
+
+	// 设置地理位置过滤条件时先通过 geohash 索引确定候选图片，只计算候选图片的距离
+	var geoDistances map[uuid.UUID]float64
+	if opts.Geo != nil {
+		candidates, err := r.geoCandidates(opts.Geo, opts.Namespace)
+		if err != nil {
+			return nil, stats, err
+		}
+		geoDistances = make(map[uuid.UUID]float64, len(candidates))
+		for _, c := range candidates {
+			geoDistances[c.imageID] = c.distance
+		}
+	}
+
+	// 每张图片取图片嵌入向量和各帧嵌入向量中距离最近的一个
+	best := make(map[uuid.UUID]imageDistance, len(embeddings))
+	consider := func(imageID uuid.UUID, frame int, embedding []float32) {
+		dist := EuclideanDistance(targetEmbedding, embedding)
+		if current, ok := best[imageID]; ok && current.distance <= dist {
+			return
+		}
+		best[imageID] = imageDistance{
+			imageID:   imageID,
+			distance:  dist,
+			embedding: embedding,
+			frame:     frame,
+		}
 	}
 
-	var distances []imageDistance
 	for _, emb := range embeddings {
-		dist := calculateEuclideanDistance(targetEmbedding, emb.Embedding)
-		distances = append(distances, imageDistance{
-			imageID:  emb.ImageID,
-			distance: dist,
-		})
+		if emb == nil {
+			continue
+		}
+		if excluded[emb.ImageID] {
+			stats.Excluded++
+			continue
+		}
+		if _, ok := geoDistances[emb.ImageID]; geoDistances != nil && !ok {
+			stats.OutsideGeo++
+			continue
+		}
+		consider(emb.ImageID, 0, emb.Embedding)
+	}
+
+	var frames []model.ImageFrame
+	if err := r.DB.Select("image_id", "frame_index", "embedding").Find(&frames).Error; err != nil {
+		return nil, stats, err
+	}
+	stats.Frames = len(frames)
+	for _, f := range frames {
+		// 图片已被排除或不满足过滤条件时跳过它的帧
+		if _, ok := best[f.ImageID]; ok {
+			consider(f.ImageID, f.Index, f.Embedding)
+		}
+	}
+
+	var distances []imageDistance
+	for _, d := range best {
+		if opts.MaxDistance > 0 && d.distance > opts.MaxDistance {
+			stats.OverThreshold++
+			continue
+		}
+		distances = append(distances, d)
 	}
 
-	// 按距离排序（升序）
+	// 按距离排序（升序），距离相同时按图片ID排序，保证结果稳定
 	sort.Slice(distances, func(i, j int) bool {
-		return distances[i].distance < distances[j].distance
+		if distances[i].distance != distances[j].distance {
+			return distances[i].distance < distances[j].distance
+		}
+		return distances[i].imageID.String() < distances[j].imageID.String()
 	})
 
-	// 限制结果数量
-	if len(distances) > limit {
-		distances = distances[:limit]
-	}
+	stats.ScanDuration = time.Since(scanStart)
 
-	// 获取图片信息
-	var images []*model.Image
-	var resultDistances []float32
+	// 获取图片信息，按过滤条件筛选并限制结果数量
+	hydrateStart := time.Now()
+	var hits []SearchHit
 
 	for _, d := range distances {
+		if len(hits) >= opts.Limit {
+			break
+		}
+		stats.Hydrated++
 		var image model.Image
-		if err := r.DB.First(&image, "id = ?", d.imageID).Error; err != nil {
+		if err := r.applyImageFilters(r.DB.Preload("Renditions"), opts).First(&image, "id = ?", d.imageID).Error; err != nil {
+			stats.Filtered++
 			continue
 		}
-		images = append(images, &image)
-		resultDistances = append(resultDistances, d.distance)
+		if geoDistance, ok := geoDistances[d.imageID]; ok && opts.Geo.Near != nil {
+			image.GeoDistance = &geoDistance
+		}
+		hits = append(hits, SearchHit{
+			Image:     &image,
+			Distance:  d.distance,
+			Embedding: d.embedding,
+			Frame:     d.frame,
+		})
 	}
+	stats.HydrateDuration = time.Since(hydrateStart)
+
+	return hits, stats, nil
+}
 
-	return images, resultDistances, nil
+// applyImageFilters 将搜索选项中的图片属性过滤条件应用到查询上
+func (r *imageRepository) applyImageFilters(db *gorm.DB, opts SearchOptions) *gorm.DB {
+	if len(opts.Extensions) > 0 {
+		db = db.Where("extension IN ?", opts.Extensions)
+	}
+	if opts.MinWidth > 0 {
+		db = db.Where("width >= ?", opts.MinWidth)
+	}
+	if opts.MinHeight > 0 {
+		db = db.Where("height >= ?", opts.MinHeight)
+	}
+	if opts.Namespace != "" {
+		db = db.Where("namespace = ?", opts.Namespace)
+	}
+	return db
 }
 
-// calculateEuclideanDistance 计算欧几里得距离
-func calculateEuclideanDistance(v1, v2 []float32) float32 {
+// EuclideanDistance 计算欧几里得距离，维度不一致时返回最大距离
+func EuclideanDistance(v1, v2 []float32) float32 {
 	if len(v1) != len(v2) {
 		return float32(math.MaxFloat32)
 	}
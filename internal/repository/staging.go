@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StageBlobs 登记一次上传将要写入的文件，必须在写入对象存储之前调用
+func (r *imageRepository) StageBlobs(uploadID uuid.UUID, keys []string) error {
+	staged := make([]model.StagedBlob, len(keys))
+	for i, key := range keys {
+		staged[i] = model.StagedBlob{
+			UploadID:  uploadID,
+			Key:       key,
+			CreatedAt: time.Now(),
+		}
+	}
+	return r.DB.Create(&staged).Error
+}
+
+// CommitUpload 在同一事务中创建图片记录和嵌入向量，并删除该上传的暂存记录
+// check 不为 nil 时在同一事务中检查创建后的用量，检查失败时不创建任何记录
+func (r *imageRepository) CommitUpload(uploadID uuid.UUID, image *model.Image, embedding *model.ImageEmbedding, check UsageCheck) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createImage(tx, image, embedding, check); err != nil {
+			return err
+		}
+		return tx.Where("upload_id = ?", uploadID).Delete(&model.StagedBlob{}).Error
+	})
+}
+
+// UncommittedBlobs 返回一次未提交的上传中可以安全删除的文件
+// 已被图片记录引用，或同时被其他未提交的上传登记的文件（内容相同的并发上传）不会返回
+func (r *imageRepository) UncommittedBlobs(uploadID uuid.UUID) ([]string, error) {
+	var keys []string
+	err := r.DB.Model(&model.StagedBlob{}).
+		Where("upload_id = ?", uploadID).
+		Where("key NOT IN (?)", r.DB.Model(&model.Blob{}).Select("key")).
+		Where("key NOT IN (?)", r.DB.Model(&model.StagedBlob{}).Select("key").Where("upload_id <> ?", uploadID)).
+		Distinct().
+		Pluck("key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UnreferencedBlobs 返回 keys 中既没有文件记录、也没有被未提交的上传登记的文件
+// 引用计数归零的文件可能已被内容相同的新上传重新登记或引用，删除文件之前需要用它复查
+func (r *imageRepository) UnreferencedBlobs(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var referenced []string
+	if err := r.DB.Model(&model.Blob{}).Where("key IN ?", keys).Pluck("key", &referenced).Error; err != nil {
+		return nil, err
+	}
+	var staged []string
+	if err := r.DB.Model(&model.StagedBlob{}).Where("key IN ?", keys).Distinct().Pluck("key", &staged).Error; err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool, len(referenced)+len(staged))
+	for _, key := range append(referenced, staged...) {
+		inUse[key] = true
+	}
+	var unreferenced []string
+	for _, key := range keys {
+		if !inUse[key] {
+			unreferenced = append(unreferenced, key)
+			inUse[key] = true
+		}
+	}
+	return unreferenced, nil
+}
+
+// DeleteStagedUpload 删除一次上传的暂存记录
+func (r *imageRepository) DeleteStagedUpload(uploadID uuid.UUID) error {
+	return r.DB.Where("upload_id = ?", uploadID).Delete(&model.StagedBlob{}).Error
+}
+
+// ListStagedUploads 列出在指定时间之前开始且尚未提交的上传
+func (r *imageRepository) ListStagedUploads(before time.Time) ([]uuid.UUID, error) {
+	var uploadIDs []uuid.UUID
+	err := r.DB.Model(&model.StagedBlob{}).
+		Where("created_at < ?", before).
+		Distinct().
+		Pluck("upload_id", &uploadIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploadIDs, nil
+}
+
+// ListStagedKeys 列出所有未提交的上传登记的文件
+func (r *imageRepository) ListStagedKeys() ([]string, error) {
+	var keys []string
+	if err := r.DB.Model(&model.StagedBlob{}).Distinct().Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TrashImage 将图片移入回收站，文件、衍生图片记录和嵌入向量保留到永久删除
+func (r *imageRepository) TrashImage(id uuid.UUID) error {
+	result := r.DB.Delete(&model.Image{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RestoreImage 将回收站中的图片恢复
+func (r *imageRepository) RestoreImage(id uuid.UUID) error {
+	result := r.DB.Unscoped().Model(&model.Image{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]interface{}{"deleted_at": nil, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeletedImage 根据ID获取回收站中的图片
+func (r *imageRepository) GetDeletedImage(id uuid.UUID) (*model.Image, error) {
+	var image model.Image
+	result := r.DB.Unscoped().Preload("Renditions").
+		Where("deleted_at IS NOT NULL").
+		First(&image, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &image, nil
+}
+
+// ListDeletedImages 列出回收站中的图片，最近删除的在前
+func (r *imageRepository) ListDeletedImages(page, pageSize int) ([]*model.Image, int64, error) {
+	var images []*model.Image
+	var total int64
+
+	deleted := r.DB.Unscoped().Model(&model.Image{}).Where("deleted_at IS NOT NULL")
+	if err := deleted.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	result := r.DB.Unscoped().Preload("Renditions").
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").
+		Offset(offset).Limit(pageSize).
+		Find(&images)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	return images, total, nil
+}
+
+// ListExpiredImages 列出在指定时间之前移入回收站的图片，最早删除的在前
+func (r *imageRepository) ListExpiredImages(before time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.DB.Unscoped().Model(&model.Image{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"gorm.io/gorm"
+)
+
+// Usage 存储用量，回收站中尚未永久删除的图片同样计入
+type Usage struct {
+	Images int64 `json:"images"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// UsageReader 统计用量
+type UsageReader interface {
+	TotalUsage() (Usage, error)
+	NamespaceUsage(namespace string) (Usage, error)
+}
+
+// UsageCheck 在创建图片记录的事务中检查用量，此时用量已包含新创建的图片，返回错误时回滚事务
+// 创建图片记录时已取得数据库写锁，并发上传的检查依次执行，不会同时通过
+type UsageCheck func(usage UsageReader) error
+
+// NamespaceFilter 按命名空间筛选图片，Include 为空时表示所有命名空间
+type NamespaceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// TotalUsage 统计整个实例的用量，字节数为对象存储中实际占用的大小，共享的文件只计一次
+func (r *imageRepository) TotalUsage() (Usage, error) {
+	var usage Usage
+	if err := r.DB.Unscoped().Model(&model.Image{}).Count(&usage.Images).Error; err != nil {
+		return usage, err
+	}
+	if err := r.DB.Model(&model.Blob{}).Select("COALESCE(SUM(size), 0)").Scan(&usage.Bytes).Error; err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// NamespaceUsage 统计命名空间的用量，字节数为该命名空间中图片的原图和衍生图片大小之和
+func (r *imageRepository) NamespaceUsage(namespace string) (Usage, error) {
+	usages, err := r.namespaceUsage(func(db *gorm.DB) *gorm.DB {
+		return db.Where("images.namespace = ?", namespace)
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	return usages[namespace], nil
+}
+
+// ListNamespaceUsage 统计所有命名空间的用量
+func (r *imageRepository) ListNamespaceUsage() (map[string]Usage, error) {
+	return r.namespaceUsage(func(db *gorm.DB) *gorm.DB { return db })
+}
+
+// namespaceUsage 按命名空间分组统计图片数量、原图和衍生图片大小
+func (r *imageRepository) namespaceUsage(scope func(*gorm.DB) *gorm.DB) (map[string]Usage, error) {
+	type row struct {
+		Namespace string
+		Images    int64
+		Bytes     int64
+	}
+
+	var images []row
+	err := r.DB.Unscoped().Model(&model.Image{}).Scopes(scope).
+		Select("images.namespace AS namespace, COUNT(*) AS images, COALESCE(SUM(images.size), 0) AS bytes").
+		Group("images.namespace").
+		Scan(&images).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var renditions []row
+	err = r.DB.Model(&model.Rendition{}).
+		Joins("JOIN images ON images.id = renditions.image_id").
+		Scopes(scope).
+		Select("images.namespace AS namespace, COALESCE(SUM(renditions.size), 0) AS bytes").
+		Group("images.namespace").
+		Scan(&renditions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make(map[string]Usage, len(images))
+	for _, u := range images {
+		usages[u.Namespace] = Usage{Images: u.Images, Bytes: u.Bytes}
+	}
+	for _, u := range renditions {
+		usage := usages[u.Namespace]
+		usage.Bytes += u.Bytes
+		usages[u.Namespace] = usage
+	}
+	return usages, nil
+}
+
+// EachImageCreatedBefore 分批遍历上传时间早于 before 且不在回收站中的图片，fn 返回错误时停止遍历
+func (r *imageRepository) EachImageCreatedBefore(before time.Time, filter NamespaceFilter, fn func(*model.Image) error) error {
+	query := r.DB.Where("created_at < ?", before)
+	if len(filter.Include) > 0 {
+		query = query.Where("namespace IN ?", filter.Include)
+	}
+	if len(filter.Exclude) > 0 {
+		query = query.Where("namespace NOT IN ?", filter.Exclude)
+	}
+
+	var images []*model.Image
+	var fnErr error
+	result := query.Order("id").FindInBatches(&images, eachImageBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, image := range images {
+			if fnErr = fn(image); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return result.Error
+}
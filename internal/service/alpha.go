@@ -0,0 +1,35 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// hasAlpha 判断图片是否包含透明或半透明像素
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenImage 将透明图片合成到指定背景色上，不透明的图片原样返回
+func flattenImage(img image.Image, background color.Color) image.Image {
+	if !hasAlpha(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
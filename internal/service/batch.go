@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrTooManyQueries 批量搜索的查询数量超过限制
+var ErrTooManyQueries = errors.New("批量搜索的查询数量超过限制")
+
+// BatchQuery 批量搜索中的单个查询，File 和 ImageID 二选一
+type BatchQuery struct {
+	// Name 客户端指定的查询名称，用于在结果中标识该查询
+	Name string
+	// File 用于搜索的图片文件
+	File *multipart.FileHeader
+	// ImageID 已入库图片的ID
+	ImageID uuid.UUID
+}
+
+// BatchResult 批量搜索中单个查询的结果
+type BatchResult struct {
+	Name    string
+	Outcome *SearchOutcome
+	Err     error
+}
+
+// SearchBatch 批量搜索相似图片，以有限的并发处理各个查询
+// 单个查询失败不会影响其他查询，错误记录在对应的结果中，结果顺序与查询顺序一致
+func (s *imageService) SearchBatch(queries []BatchQuery, opts SearchOptions) ([]BatchResult, error) {
+	if s.searchConfig.BatchMaxQueries > 0 && len(queries) > s.searchConfig.BatchMaxQueries {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooManyQueries, len(queries), s.searchConfig.BatchMaxQueries)
+	}
+
+	concurrency := s.searchConfig.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(queries))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, query := range queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, query BatchQuery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			outcome, err := s.searchBatchQuery(query, opts)
+			if err != nil {
+				logrus.Errorf("批量搜索查询 %s 失败: %v", query.Name, err)
+			}
+			results[i] = BatchResult{
+				Name:    query.Name,
+				Outcome: outcome,
+				Err:     err,
+			}
+		}(i, query)
+	}
+	wg.Wait()
+
+	logrus.Infof("批量搜索完成，共 %d 个查询", len(queries))
+	return results, nil
+}
+
+// searchBatchQuery 执行批量搜索中的单个查询
+func (s *imageService) searchBatchQuery(query BatchQuery, opts SearchOptions) (*SearchOutcome, error) {
+	// 每个查询使用独立的排除列表，避免并发修改共享切片
+	opts.ExcludeIDs = append([]uuid.UUID(nil), opts.ExcludeIDs...)
+
+	if query.File == nil {
+		return s.SearchSimilarByImageID(query.ImageID, opts)
+	}
+
+	file, err := query.File.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.SearchImagesByImage(file, opts)
+}
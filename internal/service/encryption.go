@@ -0,0 +1,141 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrEncryptionDisabled 对象存储没有启用静态加密
+var ErrEncryptionDisabled = errors.New("未启用静态加密，请配置 ENCRYPTION_KEY_FILE")
+
+// PlaintextKeyID 密钥轮换报告中表示未加密文件的密钥ID
+const PlaintextKeyID = "plaintext"
+
+// KeyRotationError 重新加密失败的文件
+type KeyRotationError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// KeyRotationReport 密钥轮换报告
+type KeyRotationReport struct {
+	// DryRun 为 true 时只统计需要重新加密的文件，不修改
+	DryRun bool `json:"dry_run"`
+	// KeyID 当前密钥ID，所有文件重新加密后都使用该密钥
+	KeyID string `json:"key_id"`
+	Files int    `json:"files"`
+	// Keys 轮换前每个密钥加密的文件数量，未加密的文件计入 plaintext
+	Keys          map[string]int     `json:"keys"`
+	Reencrypted   int                `json:"reencrypted"`
+	Failed        int                `json:"failed"`
+	Images        int                `json:"images"`
+	ImagesUpdated int                `json:"images_updated"`
+	Errors        []KeyRotationError `json:"errors"`
+	StartedAt     time.Time          `json:"started_at"`
+	DurationMs    float64            `json:"duration_ms"`
+}
+
+// activeKeyID 返回新写入文件使用的密钥ID，未启用静态加密时为空
+func (s *imageService) activeKeyID() string {
+	if rotator, ok := s.blobs.(storage.KeyRotator); ok {
+		return rotator.ActiveKeyID()
+	}
+	return ""
+}
+
+// RotateKeys 使用当前密钥重新加密对象存储中所有未使用当前密钥的文件（包括隔离区中的文件和启用加密前写入的明文文件），
+// 然后更新图片记录中的密钥ID；dryRun 为 true 时只统计
+func (s *imageService) RotateKeys(dryRun bool) (*KeyRotationReport, error) {
+	rotator, ok := s.blobs.(storage.KeyRotator)
+	if !ok {
+		return nil, ErrEncryptionDisabled
+	}
+
+	report := &KeyRotationReport{
+		DryRun:    dryRun,
+		KeyID:     rotator.ActiveKeyID(),
+		Keys:      make(map[string]int),
+		Errors:    []KeyRotationError{},
+		StartedAt: time.Now(),
+	}
+
+	// 先列出所有文件再逐个重写，避免遍历过程中修改存储
+	var keys []string
+	if err := s.blobs.List("", func(info storage.BlobInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("遍历对象存储失败: %w", err)
+	}
+
+	// 每个文件轮换后使用的密钥ID
+	fileKeys := make(map[string]string, len(keys))
+	for _, key := range keys {
+		report.Files++
+
+		var (
+			previous string
+			err      error
+		)
+		if dryRun {
+			previous, err = rotator.KeyIDOf(key)
+		} else {
+			previous, err = rotator.Reencrypt(key)
+		}
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, KeyRotationError{Key: key, Error: err.Error()})
+			logrus.Errorf("重新加密文件 %s 失败: %v", key, err)
+			continue
+		}
+
+		if previous == "" {
+			report.Keys[PlaintextKeyID]++
+		} else {
+			report.Keys[previous]++
+		}
+		fileKeys[key] = report.KeyID
+		if previous != report.KeyID {
+			report.Reencrypted++
+		}
+	}
+
+	// 原图和所有衍生图片都已使用当前密钥时更新图片记录，否则记录原图使用的密钥
+	err := s.imageRepo.EachImage(func(img *model.Image) error {
+		report.Images++
+
+		keyID, ok := fileKeys[img.StorageKey()]
+		for _, rendition := range img.Renditions {
+			if fileKeys[rendition.FilePath] != report.KeyID {
+				ok = false
+			}
+		}
+		if !ok {
+			current, err := rotator.KeyIDOf(img.StorageKey())
+			if err != nil {
+				return nil
+			}
+			keyID = current
+		}
+		if keyID == img.KeyID {
+			return nil
+		}
+
+		report.ImagesUpdated++
+		if dryRun {
+			return nil
+		}
+		return s.imageRepo.SetImageKeyID(img.ID, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("更新图片密钥ID失败: %w", err)
+	}
+
+	report.DurationMs = milliseconds(time.Since(report.StartedAt))
+	return report, nil
+}
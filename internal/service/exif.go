@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/google/uuid"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"github.com/sirupsen/logrus"
+)
+
+// exifTimeLayout EXIF 日期时间的格式
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// exifPayload 返回可以交给 exif.Decode 解析的 EXIF 数据，没有 EXIF 时返回 nil
+// JPEG 和 TIFF 为整个文件，PNG 为 eXIf 块，WebP 为 EXIF 块
+func exifPayload(data []byte, format string) []byte {
+	switch format {
+	case "jpeg", "tiff":
+		return data
+	case "png":
+		chunks, err := pngChunks(data)
+		if err != nil {
+			return nil
+		}
+		for _, chunk := range chunks {
+			if chunk.typ == "eXIf" {
+				return chunk.data
+			}
+		}
+	case "webp":
+		return webpChunkData(data, "EXIF")
+	}
+	return nil
+}
+
+// webpChunkData 返回 WebP 中第一个指定类型的块的内容，没有时返回 nil
+func webpChunkData(data []byte, fourCC string) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) || pos+8+size < pos {
+			return nil
+		}
+		if string(data[pos:pos+4]) == fourCC {
+			return data[pos+8 : pos+8+size]
+		}
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+// decodeImage 解码图片，按处理流水线将嵌入的 ICC 配置文件转换为 sRGB，并按 EXIF 方向旋转，返回的图片与在浏览器中看到的一致
+// SVG 栅格化为最长边为 opts.svgSize 的图片
+// 上传、搜索、实时处理和一致性检查都必须使用该函数解码，保证尺寸、颜色、衍生图片和嵌入向量一致
+func decodeImage(r io.Reader, opts decodeOptions) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if isSVG(data) {
+		img, err := rasterizeSVG(data, opts.svgSize)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrCorruptImage, err)
+		}
+		return img, "svg", nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	// Adobe RGB、Display P3 等广色域图片按 sRGB 解释时颜色会偏淡，需要先转换
+	if opts.srgb {
+		if profile := readICCProfile(data, format); profile != nil && profile.convertible && !profile.isSRGB() {
+			img = convertToSRGB(img, profile)
+		}
+	}
+	if opts.orient {
+		img = orientImage(img, exifOrientation(data, format))
+	}
+	return img, format, nil
+}
+
+// exifOrientation 读取 EXIF 中的方向，没有 EXIF 或方向无效时返回 1
+func exifOrientation(data []byte, format string) int {
+	payload := exifPayload(data, format)
+	if payload == nil {
+		return 1
+	}
+	x, err := exif.Decode(bytes.NewReader(payload))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return 1
+	}
+	if orientation, ok := exifInt(x, exif.Orientation); ok && orientation >= 1 && orientation <= 8 {
+		return orientation
+	}
+	return 1
+}
+
+// orientImage 按 EXIF 方向（1-8）变换图片
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return mirrorImage(img)
+	case 3:
+		return rotateImage(img, 180)
+	case 4:
+		return rotateImage(mirrorImage(img), 180)
+	case 5:
+		return rotateImage(mirrorImage(img), 270)
+	case 6:
+		return rotateImage(img, 90)
+	case 7:
+		return rotateImage(mirrorImage(img), 90)
+	case 8:
+		return rotateImage(img, 270)
+	default:
+		return img
+	}
+}
+
+// extractMetadata 从原图 EXIF 中提取拍摄信息，并记录嵌入的 ICC 配置文件名称，两者都没有时返回 nil
+func extractMetadata(data []byte, format string) *model.ImageMetadata {
+	metadata := extractEXIF(data, format)
+	if profile := readICCProfile(data, format); profile != nil && profile.name != "" {
+		if metadata == nil {
+			metadata = &model.ImageMetadata{Orientation: 1, CreatedAt: time.Now()}
+		}
+		metadata.ColorProfile = profile.name
+	}
+	return metadata
+}
+
+// extractEXIF 从原图 EXIF 中提取拍摄信息，没有 EXIF 时返回 nil
+// EXIF 部分损坏时尽量提取可以解析的字段
+func extractEXIF(data []byte, format string) *model.ImageMetadata {
+	payload := exifPayload(data, format)
+	if payload == nil {
+		return nil
+	}
+	x, err := exif.Decode(bytes.NewReader(payload))
+	if err != nil {
+		if x == nil || exif.IsCriticalError(err) {
+			// 大多数没有 EXIF 的图片会走到这里，不作为警告
+			logrus.Debugf("解析 EXIF 失败: %v", err)
+			return nil
+		}
+		logrus.Warnf("EXIF 部分字段解析失败: %v", err)
+	}
+
+	metadata := &model.ImageMetadata{
+		Orientation: 1,
+		CameraMake:  exifString(x, exif.Make),
+		CameraModel: exifString(x, exif.Model),
+		LensModel:   exifString(x, exif.LensModel),
+		CreatedAt:   time.Now(),
+	}
+	if orientation, ok := exifInt(x, exif.Orientation); ok && orientation >= 1 && orientation <= 8 {
+		metadata.Orientation = orientation
+	}
+
+	// 优先使用拍摄时间，其次是文件修改时间
+	for _, field := range []exif.FieldName{exif.DateTimeOriginal, exif.DateTime} {
+		if value := exifString(x, field); value != "" {
+			if capturedAt, err := time.ParseInLocation(exifTimeLayout, value, time.UTC); err == nil {
+				metadata.CapturedAt = &capturedAt
+				break
+			}
+		}
+	}
+
+	metadata.ExposureTime, _ = exifRat(x, exif.ExposureTime)
+	metadata.FNumber, _ = exifRat(x, exif.FNumber)
+	metadata.FocalLength, _ = exifRat(x, exif.FocalLength)
+	metadata.ISO, _ = exifInt(x, exif.ISOSpeedRatings)
+
+	if lat, long, err := x.LatLong(); err == nil {
+		metadata.Latitude, metadata.Longitude = &lat, &long
+		metadata.Geohash = repository.EncodeGeohash(lat, long, repository.GeohashPrecision)
+		if altitude, ok := exifRat(x, exif.GPSAltitude); ok {
+			// GPSAltitudeRef 为 1 表示海平面以下
+			if ref, ok := exifInt(x, exif.GPSAltitudeRef); ok && ref == 1 {
+				altitude = -altitude
+			}
+			metadata.Altitude = &altitude
+		}
+	}
+	return metadata
+}
+
+// copyMetadata 复制拍摄信息，用于与已有图片共享文件的新记录
+func copyMetadata(metadata *model.ImageMetadata) *model.ImageMetadata {
+	if metadata == nil {
+		return nil
+	}
+	c := *metadata
+	c.ImageID = uuid.Nil
+	c.CreatedAt = time.Now()
+	return &c
+}
+
+// exifString 读取字符串字段，去掉结尾的空字符和空格
+func exifString(x *exif.Exif, field exif.FieldName) string {
+	tag, err := x.Get(field)
+	if err != nil || tag.Format() != tiff.StringVal {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// exifInt 读取整数字段的第一个值
+func exifInt(x *exif.Exif, field exif.FieldName) (int, bool) {
+	tag, err := x.Get(field)
+	if err != nil || tag.Format() != tiff.IntVal {
+		return 0, false
+	}
+	value, err := tag.Int(0)
+	return value, err == nil
+}
+
+// exifRat 读取有理数字段的第一个值
+func exifRat(x *exif.Exif, field exif.FieldName) (float64, bool) {
+	tag, err := x.Get(field)
+	if err != nil || tag.Format() != tiff.RatVal {
+		return 0, false
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
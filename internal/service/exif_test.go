@@ -0,0 +1,64 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEXIFFromChunks(t *testing.T) {
+	cases := []struct {
+		format string
+		data   []byte
+	}{
+		{"jpeg", testJPEG(t)},
+		{"png", testPNG(t)},
+		{"webp", testWebP()},
+		{"tiff", testTIFF()},
+	}
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			if got := exifOrientation(c.data, c.format); got != 6 {
+				t.Errorf("方向为 %d，期望 6", got)
+			}
+			metadata := extractEXIF(c.data, c.format)
+			if metadata == nil {
+				t.Fatal("没有提取到拍摄信息")
+			}
+			if metadata.Orientation != 6 {
+				t.Errorf("拍摄信息中的方向为 %d，期望 6", metadata.Orientation)
+			}
+			if metadata.Latitude == nil || metadata.Longitude == nil {
+				t.Error("没有提取到 GPS 坐标")
+			}
+		})
+	}
+}
+
+func TestDecodeImageOrientsPNG(t *testing.T) {
+	img, format, err := decodeImage(bytes.NewReader(testPNG(t)), decodeOptions{orient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" {
+		t.Fatalf("格式为 %s，期望 png", format)
+	}
+	// 8x4 的图片按方向 6 顺时针旋转 90 度
+	if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 8 {
+		t.Errorf("解码后尺寸为 %dx%d，期望 4x8", b.Dx(), b.Dy())
+	}
+}
+
+func TestEXIFPayloadMissing(t *testing.T) {
+	var buf bytes.Buffer
+	writePNGChunk(&buf, "IEND", nil)
+	data := append([]byte("\x89PNG\r\n\x1a\n"), buf.Bytes()...)
+	if payload := exifPayload(data, "png"); payload != nil {
+		t.Errorf("没有 eXIf 块时返回了 %d 字节", len(payload))
+	}
+	if payload := exifPayload([]byte("RIFF\x04\x00\x00\x00WEBP"), "webp"); payload != nil {
+		t.Errorf("没有 EXIF 块时返回了 %d 字节", len(payload))
+	}
+	if payload := exifPayload(nil, "gif"); payload != nil {
+		t.Error("GIF 不应包含 EXIF")
+	}
+}
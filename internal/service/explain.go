@@ -0,0 +1,156 @@
+package service
+
+import (
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/repository"
+)
+
+// embeddingFeatures 嵌入向量每个维度对应的特征名称，需要与 generateEmbedding 保持一致
+var embeddingFeatures = []string{"mean_red", "mean_green", "mean_blue"}
+
+// SearchExplain 搜索过程的详细信息，用于排查搜索结果不符合预期的问题
+type SearchExplain struct {
+	QueryEmbedding []float32      `json:"query_embedding"`
+	Features       []string       `json:"features"`
+	Metric         string         `json:"metric"`
+	Index          string         `json:"index"`
+	Candidates     ExplainCounts  `json:"candidates"`
+	Timings        ExplainTimings `json:"timings_ms"`
+	Rerank         *ExplainRerank `json:"rerank,omitempty"`
+	Filters        ExplainFilters `json:"filters"`
+}
+
+// ExplainCounts 搜索各阶段的候选数量
+type ExplainCounts struct {
+	Scanned       int `json:"scanned"`
+	Frames        int `json:"frames"`
+	Malformed     int `json:"malformed"`
+	Excluded      int `json:"excluded"`
+	OverThreshold int `json:"over_threshold"`
+	Hydrated      int `json:"hydrated"`
+	Filtered      int `json:"filtered"`
+	OutsideGeo    int `json:"outside_geo"`
+	Reranked      int `json:"reranked"`
+	Returned      int `json:"returned"`
+}
+
+// ExplainTimings 搜索各阶段耗时（毫秒）
+type ExplainTimings struct {
+	Decode        float64 `json:"decode"`
+	Resize        float64 `json:"resize"`
+	Embed         float64 `json:"embed"`
+	LoadEmbedding float64 `json:"load_embedding"`
+	Scan          float64 `json:"scan"`
+	Hydrate       float64 `json:"hydrate"`
+	Rerank        float64 `json:"rerank"`
+	Total         float64 `json:"total"`
+}
+
+// ExplainRerank 结果重排参数
+type ExplainRerank struct {
+	Diversify         bool    `json:"diversify"`
+	Lambda            float64 `json:"lambda,omitempty"`
+	Collapse          bool    `json:"collapse"`
+	CollapseThreshold float32 `json:"collapse_threshold,omitempty"`
+}
+
+// ExplainFilters 实际生效的过滤条件
+type ExplainFilters struct {
+	Limit       int               `json:"limit"`
+	Namespace   string            `json:"namespace,omitempty"`
+	MaxDistance float32           `json:"max_distance,omitempty"`
+	Extensions  []string          `json:"extensions,omitempty"`
+	MinWidth    int               `json:"min_width,omitempty"`
+	MinHeight   int               `json:"min_height,omitempty"`
+	ExcludedIDs int               `json:"excluded_ids,omitempty"`
+	Near        *ExplainGeoCircle `json:"near,omitempty"`
+	Within      *ExplainGeoBox    `json:"within,omitempty"`
+}
+
+// ExplainGeoCircle 圆形地理位置过滤范围
+type ExplainGeoCircle struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius_m"`
+}
+
+// ExplainGeoBox 矩形地理位置过滤范围
+type ExplainGeoBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// newSearchExplain 根据仓库层统计信息和搜索选项构建 explain 信息
+func newSearchExplain(query []float32, stats repository.SearchStats, opts SearchOptions) *SearchExplain {
+	explain := &SearchExplain{
+		QueryEmbedding: query,
+		Features:       embeddingFeatures,
+		Metric:         stats.Metric,
+		Index:          stats.Index,
+		Candidates: ExplainCounts{
+			Scanned:       stats.Scanned,
+			Frames:        stats.Frames,
+			Malformed:     stats.Malformed,
+			Excluded:      stats.Excluded,
+			OverThreshold: stats.OverThreshold,
+			Hydrated:      stats.Hydrated,
+			Filtered:      stats.Filtered,
+			OutsideGeo:    stats.OutsideGeo,
+		},
+		Timings: ExplainTimings{
+			Scan:    milliseconds(stats.ScanDuration),
+			Hydrate: milliseconds(stats.HydrateDuration),
+		},
+		Filters: ExplainFilters{
+			Limit:       opts.Limit,
+			Namespace:   opts.Namespace,
+			MaxDistance: opts.MaxDistance,
+			Extensions:  opts.Extensions,
+			MinWidth:    opts.MinWidth,
+			MinHeight:   opts.MinHeight,
+			ExcludedIDs: len(opts.ExcludeIDs),
+		},
+	}
+
+	if opts.Geo != nil {
+		if c := opts.Geo.Near; c != nil {
+			explain.Filters.Near = &ExplainGeoCircle{Lat: c.Lat, Lon: c.Lon, Radius: c.Radius}
+		}
+		if b := opts.Geo.Within; b != nil {
+			explain.Filters.Within = &ExplainGeoBox{MinLat: b.MinLat, MinLon: b.MinLon, MaxLat: b.MaxLat, MaxLon: b.MaxLon}
+		}
+	}
+
+	if opts.Diversify || opts.Collapse {
+		explain.Rerank = &ExplainRerank{Diversify: opts.Diversify, Collapse: opts.Collapse}
+		if opts.Diversify {
+			explain.Rerank.Lambda = opts.Lambda
+		}
+		if opts.Collapse {
+			explain.Rerank.CollapseThreshold = opts.CollapseThreshold
+		}
+	}
+
+	return explain
+}
+
+// distanceContributions 计算每个维度对欧几里得距离平方的贡献，各项之和等于距离的平方
+func distanceContributions(query, embedding []float32) []float32 {
+	if len(query) != len(embedding) {
+		return nil
+	}
+	contributions := make([]float32, len(query))
+	for i := range query {
+		diff := query[i] - embedding[i]
+		contributions[i] = diff * diff
+	}
+	return contributions
+}
+
+// milliseconds 将耗时转换为毫秒
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
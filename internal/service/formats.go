@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	// 注册 GIF、BMP、TIFF 和 WebP 解码器
+	_ "image/gif"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrUnsupportedFormat 上传的文件不是支持的图片格式
+var ErrUnsupportedFormat = errors.New("只支持 JPEG、PNG、GIF、WebP、BMP、TIFF 和 SVG 格式的图片")
+
+// imageFormat 上传图片格式的处理方式
+type imageFormat struct {
+	// store 保存原图使用的格式，与上传格式不同时转换后保存
+	store string
+	// derivative 衍生图片未指定格式、实时处理未指定输出格式时使用的格式，必须是可编码的 jpeg 或 png
+	derivative string
+}
+
+// imageFormats 支持上传的图片格式，键为 image.Decode 返回的格式名
+// 浏览器可以直接显示的格式原样保存；BMP 未压缩且没有额外信息，无损转换为 PNG 保存；
+// TIFF 常用于扫描文档，原样保存以保留原始数据，通过衍生图片和实时处理在浏览器中查看；
+// SVG 清理脚本和外部引用后保存矢量原图，栅格化后生成衍生图片和嵌入向量
+var imageFormats = map[string]imageFormat{
+	"jpeg": {store: "jpeg", derivative: "jpeg"},
+	"png":  {store: "png", derivative: "png"},
+	"gif":  {store: "gif", derivative: "png"},
+	"webp": {store: "webp", derivative: "png"},
+	"bmp":  {store: "png", derivative: "png"},
+	"tiff": {store: "tiff", derivative: "png"},
+	"svg":  {store: "svg", derivative: "png"},
+}
+
+// NormalizeFormat 将格式名称的常见别名转换为存储使用的格式名，如 jpg 转换为 jpeg、tif 转换为 tiff
+func NormalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	switch format {
+	case "jpg":
+		return "jpeg"
+	case "tif":
+		return "tiff"
+	}
+	return format
+}
+
+// derivativeFormat 返回指定存储格式的图片生成衍生图片时默认使用的格式
+func derivativeFormat(storedFormat string) string {
+	for _, f := range imageFormats {
+		if f.store == storedFormat {
+			return f.derivative
+		}
+	}
+	return "png"
+}
+
+// prepareOriginal 按 checkImage 识别出的格式准备保存的原图，需要转换的格式解码后按存储格式重新编码，SVG 清理后保存
+// 返回保存的原图内容和格式，以及已解码的图片（没有解码时为 nil）
+func prepareOriginal(data []byte, format string) ([]byte, string, image.Image, error) {
+	if format == "svg" {
+		sanitized, err := sanitizeSVG(data)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+		}
+		return sanitized, format, nil, nil
+	}
+
+	f, ok := imageFormats[format]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if f.store == format {
+		return data, format, nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	converted := bytes.NewBuffer(nil)
+	if err := encodeImage(converted, img, f.store, 0); err != nil {
+		return nil, "", nil, fmt.Errorf("转换图片格式失败: %w", err)
+	}
+	return converted.Bytes(), f.store, img, nil
+}
\ No newline at end of file
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/tiff"
+)
+
+const (
+	// tiffOrientationTag TIFF 和 EXIF 中方向标签的编号
+	tiffOrientationTag = 0x0112
+	// tiffShortType TIFF 中 SHORT 类型的编号
+	tiffShortType = 3
+	// tiffLongType TIFF 中 LONG 类型的编号
+	tiffLongType = 4
+	// maxTIFFPages 解析 TIFF 页链表时允许的最大页数，避免损坏的文件导致长时间循环
+	maxTIFFPages = 10000
+)
+
+// frame 动图中的一帧或多页 TIFF 中的一页
+type frame struct {
+	// index 帧在原图中的序号，从 0 开始
+	index int
+	// delay 帧的显示时长（毫秒）
+	delay int
+	img   image.Image
+}
+
+// tiffPage 多页 TIFF 中一页的 IFD 位置和方向
+type tiffPage struct {
+	offset      uint32
+	orientation int
+}
+
+// generateFrames 为动图的每一帧或多页 TIFF 的每一页生成嵌入向量，返回总帧数和抽样后的帧
+// 单帧图片或只抽取到一帧时不返回帧记录，搜索只使用图片的嵌入向量
+func (s *imageService) generateFrames(data []byte, format string) (int, []model.ImageFrame, error) {
+	var frames []model.ImageFrame
+	total, err := eachFrame(data, format, s.searchConfig.MaxFrames, func(f frame) error {
+		frames = append(frames, model.ImageFrame{
+			Index:     f.index,
+			Delay:     f.delay,
+			Embedding: s.generateEmbedding(s.pipeline.embeddingInput(f.img)),
+			CreatedAt: time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(frames) < 2 {
+		frames = nil
+	}
+	return total, frames, nil
+}
+
+// copyFrames 复制帧记录，用于与已有图片共享文件的新记录
+func copyFrames(frames []model.ImageFrame) []model.ImageFrame {
+	if len(frames) == 0 {
+		return nil
+	}
+	copies := make([]model.ImageFrame, len(frames))
+	for i, f := range frames {
+		copies[i] = model.ImageFrame{
+			Index:     f.Index,
+			Delay:     f.Delay,
+			Embedding: f.Embedding,
+			CreatedAt: time.Now(),
+		}
+	}
+	return copies
+}
+
+// eachFrame 依次解码动图的每一帧或多页 TIFF 的每一页，帧数超过 maxFrames 时均匀抽样，返回总帧数
+// 其他格式的图片返回 1 且不调用 fn；fn 返回后传入的图片可能被修改，不能保留
+func eachFrame(data []byte, format string, maxFrames int, fn func(frame) error) (int, error) {
+	switch format {
+	case "gif":
+		return eachGIFFrame(data, maxFrames, fn)
+	case "tiff":
+		return eachTIFFPage(data, maxFrames, fn)
+	default:
+		return 1, nil
+	}
+}
+
+// eachGIFFrame 按帧的处置方式逐帧合成 GIF 动画，对抽样的帧调用 fn
+func eachGIFFrame(data []byte, maxFrames int, fn func(frame) error) (int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("%w: 解码 GIF 动画失败: %v", ErrCorruptImage, err)
+	}
+	total := len(g.Image)
+	if total <= 1 {
+		return total, nil
+	}
+	sampled := sampleFrames(total, maxFrames)
+
+	// 后续帧只覆盖画布的一部分，需要在完整的画布上合成
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, p := range g.Image {
+		bounds = bounds.Union(p.Bounds())
+	}
+	canvas := image.NewRGBA(bounds)
+
+	for i, p := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+		if sampled[i] {
+			delay := 0
+			if i < len(g.Delay) {
+				delay = g.Delay[i] * 10
+			}
+			if err := fn(frame{index: i, delay: delay, img: canvas}); err != nil {
+				return total, err
+			}
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return total, nil
+}
+
+// eachTIFFPage 逐页解码多页 TIFF 并按每页的方向旋转，对抽样的页调用 fn
+// 无法解码的页（如使用了不支持的压缩方式）记录警告后跳过
+func eachTIFFPage(data []byte, maxFrames int, fn func(frame) error) (int, error) {
+	order, pages, err := tiffPages(data)
+	if err != nil {
+		return 0, fmt.Errorf("%w: 解析 TIFF 页面失败: %v", ErrCorruptImage, err)
+	}
+	total := len(pages)
+	if total <= 1 {
+		return total, nil
+	}
+	sampled := sampleFrames(total, maxFrames)
+
+	for i, page := range pages {
+		if !sampled[i] {
+			continue
+		}
+		// 将文件头中第一个 IFD 的偏移替换为该页的偏移，解码器即可解码该页
+		reader := &tiffPageReader{data: data}
+		copy(reader.header[:], data[:8])
+		order.PutUint32(reader.header[4:], page.offset)
+
+		img, err := tiff.Decode(io.NewSectionReader(reader, 0, int64(len(data))))
+		if err != nil {
+			logrus.Warnf("解码 TIFF 第 %d 页失败: %v", i+1, err)
+			continue
+		}
+		if err := fn(frame{index: i, img: orientImage(img, page.orientation)}); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// tiffPages 沿 IFD 链表解析 TIFF 中所有页的位置和方向
+func tiffPages(data []byte) (binary.ByteOrder, []tiffPage, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("文件头不完整")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("无效的字节序标记")
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, nil, errors.New("不支持的 TIFF 版本")
+	}
+
+	var pages []tiffPage
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if seen[offset] || len(pages) >= maxTIFFPages {
+			return nil, nil, errors.New("IFD 链表存在循环")
+		}
+		seen[offset] = true
+
+		start := int(offset)
+		if start+2 > len(data) {
+			return nil, nil, fmt.Errorf("IFD 偏移 %d 超出文件范围", offset)
+		}
+		count := int(order.Uint16(data[start:]))
+		end := start + 2 + count*12
+		if end+4 > len(data) {
+			return nil, nil, fmt.Errorf("IFD 偏移 %d 处的目录不完整", offset)
+		}
+
+		page := tiffPage{offset: offset, orientation: 1}
+		for entry := start + 2; entry < end; entry += 12 {
+			if order.Uint16(data[entry:]) == tiffOrientationTag && order.Uint16(data[entry+2:]) == tiffShortType {
+				if orientation := int(order.Uint16(data[entry+8:])); orientation >= 1 && orientation <= 8 {
+					page.orientation = orientation
+				}
+			}
+		}
+		pages = append(pages, page)
+		offset = order.Uint32(data[end:])
+	}
+	return order, pages, nil
+}
+
+// tiffPageReader 读取 TIFF 文件内容，文件头替换为 header
+type tiffPageReader struct {
+	data   []byte
+	header [8]byte
+}
+
+// ReadAt 实现 io.ReaderAt
+func (r *tiffPageReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	for i := off; i < int64(len(r.header)) && i < off+int64(n); i++ {
+		p[i-off] = r.header[i]
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sampleFrames 从 total 帧中均匀选取不超过 maxFrames 帧，总是包含第一帧和最后一帧
+// maxFrames 小于 1 时只选取第一帧
+func sampleFrames(total, maxFrames int) map[int]bool {
+	sampled := make(map[int]bool)
+	switch {
+	case maxFrames >= total:
+		for i := 0; i < total; i++ {
+			sampled[i] = true
+		}
+	case maxFrames <= 1:
+		sampled[0] = true
+	default:
+		for i := 0; i < maxFrames; i++ {
+			sampled[(i*(total-1)+(maxFrames-1)/2)/(maxFrames-1)] = true
+		}
+	}
+	return sampled
+}
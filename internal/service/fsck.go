@@ -0,0 +1,583 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/google/uuid"
+	"github.com/nfnt/resize"
+	"github.com/sirupsen/logrus"
+)
+
+// 一致性检查发现的问题类型
+const (
+	// FsckOrphanFile 对象存储中没有被任何图片或衍生图片引用的文件
+	FsckOrphanFile = "orphan_file"
+	// FsckMissingFile 图片记录引用的原图文件不存在
+	FsckMissingFile = "missing_file"
+	// FsckMissingRenditionFile 衍生图片记录引用的文件不存在
+	FsckMissingRenditionFile = "missing_rendition_file"
+	// FsckSizeMismatch 原图文件大小与记录不一致
+	FsckSizeMismatch = "size_mismatch"
+	// FsckChecksumMismatch 原图文件内容的 SHA-256 与记录不一致
+	FsckChecksumMismatch = "checksum_mismatch"
+	// FsckMissingEmbedding 图片没有嵌入向量
+	FsckMissingEmbedding = "missing_embedding"
+	// FsckMalformedEmbedding 嵌入向量无法解析或维度不正确
+	FsckMalformedEmbedding = "malformed_embedding"
+	// FsckDuplicateEmbedding 同一图片有多条嵌入向量
+	FsckDuplicateEmbedding = "duplicate_embedding"
+	// FsckOrphanEmbedding 嵌入向量对应的图片不存在
+	FsckOrphanEmbedding = "orphan_embedding"
+	// FsckRefCountMismatch 文件记录的引用计数与实际引用数量不一致
+	FsckRefCountMismatch = "ref_count_mismatch"
+)
+
+// 修复动作
+const (
+	FsckActionQuarantine   = "quarantine"
+	FsckActionDeleteFile   = "delete_file"
+	FsckActionDeleteRecord = "delete_record"
+	FsckActionReembed      = "reembed"
+	FsckActionRegenerate   = "regenerate"
+	FsckActionFixRefCount  = "fix_ref_count"
+)
+
+// QuarantinePrefix 被隔离文件的对象键前缀，一致性检查不扫描该前缀下的文件
+const QuarantinePrefix = "quarantine/"
+
+// defaultFsckGracePeriod 默认的孤立文件宽限期，刚写入的文件可能属于尚未完成的上传
+const defaultFsckGracePeriod = 10 * time.Minute
+
+// FsckOptions 一致性检查选项
+type FsckOptions struct {
+	// Repair 是否修复发现的问题
+	Repair bool
+	// VerifyChecksums 是否读取原图并校验 SHA-256，需要读取全部原图
+	VerifyChecksums bool
+	// DeleteOrphans 修复时直接删除孤立文件，默认移动到隔离区
+	DeleteOrphans bool
+	// GracePeriod 修改时间在宽限期内的孤立文件不报告，为 0 时使用默认值，小于 0 时不设宽限期
+	GracePeriod time.Duration
+}
+
+// FsckIssue 一致性检查发现的问题
+type FsckIssue struct {
+	Kind        string `json:"kind"`
+	Key         string `json:"key,omitempty"`
+	ImageID     string `json:"image_id,omitempty"`
+	EmbeddingID string `json:"embedding_id,omitempty"`
+	Detail      string `json:"detail"`
+	// Action 修复动作，只检查时为空
+	Action   string `json:"action,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FsckReport 一致性检查报告
+type FsckReport struct {
+	Repair          bool           `json:"repair"`
+	VerifyChecksums bool           `json:"verify_checksums"`
+	Files           int            `json:"files"`
+	Images          int            `json:"images"`
+	Renditions      int            `json:"renditions"`
+	Embeddings      int            `json:"embeddings"`
+	Blobs           int            `json:"blobs"`
+	Counts          map[string]int `json:"counts"`
+	Repaired        int            `json:"repaired"`
+	Failed          int            `json:"failed"`
+	Issues          []FsckIssue    `json:"issues"`
+	StartedAt       time.Time      `json:"started_at"`
+	DurationMs      float64        `json:"duration_ms"`
+}
+
+// Unresolved 返回未修复的问题数量
+func (r *FsckReport) Unresolved() int {
+	return len(r.Issues) - r.Repaired
+}
+
+// fsckRun 一次一致性检查的状态
+type fsckRun struct {
+	s      *imageService
+	opts   FsckOptions
+	report *FsckReport
+
+	files  map[string]storage.BlobInfo
+	images []*model.Image
+	// broken 原图缺失或损坏的图片，无法恢复，修复时删除
+	broken map[uuid.UUID]bool
+	// deleted 修复过程中已删除的图片
+	deleted map[uuid.UUID]bool
+	// removedKeys 修复过程中已删除或隔离的文件
+	removedKeys map[string]bool
+}
+
+// Fsck 检查对象存储、图片记录和嵌入向量之间的一致性，可选地修复发现的问题
+func (s *imageService) Fsck(opts FsckOptions) (*FsckReport, error) {
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = defaultFsckGracePeriod
+	}
+
+	run := &fsckRun{
+		s:    s,
+		opts: opts,
+		report: &FsckReport{
+			Repair:          opts.Repair,
+			VerifyChecksums: opts.VerifyChecksums,
+			Counts:          make(map[string]int),
+			Issues:          []FsckIssue{},
+			StartedAt:       time.Now(),
+		},
+		files:       make(map[string]storage.BlobInfo),
+		broken:      make(map[uuid.UUID]bool),
+		deleted:     make(map[uuid.UUID]bool),
+		removedKeys: make(map[string]bool),
+	}
+
+	steps := []func() error{
+		run.scanFiles,
+		run.checkImages,
+		run.checkEmbeddings,
+		run.checkOrphanFiles,
+		run.checkRefCounts,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+
+	run.report.DurationMs = milliseconds(time.Since(run.report.StartedAt))
+	logrus.Infof("一致性检查完成: 发现 %d 个问题，修复 %d 个，失败 %d 个",
+		len(run.report.Issues), run.report.Repaired, run.report.Failed)
+	return run.report, nil
+}
+
+// addIssue 记录问题，修复时执行 repair 并记录结果
+func (r *fsckRun) addIssue(issue FsckIssue, action string, repair func() error) {
+	r.report.Counts[issue.Kind]++
+	if r.opts.Repair && repair != nil {
+		issue.Action = action
+		if err := repair(); err != nil {
+			issue.Error = err.Error()
+			r.report.Failed++
+			logrus.Errorf("修复 %s 失败: %v", issue.Kind, err)
+		} else {
+			issue.Repaired = true
+			r.report.Repaired++
+		}
+	}
+	r.report.Issues = append(r.report.Issues, issue)
+}
+
+// scanFiles 列出对象存储中的所有文件，隔离区除外
+func (r *fsckRun) scanFiles() error {
+	err := r.s.blobs.List("", func(info storage.BlobInfo) error {
+		if strings.HasPrefix(info.Key, QuarantinePrefix) {
+			return nil
+		}
+		r.files[info.Key] = info
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("列出对象存储文件失败: %w", err)
+	}
+	r.report.Files = len(r.files)
+	return nil
+}
+
+// checkImages 检查每张图片的原图和衍生图片文件，原图缺失或损坏的图片无法恢复，修复时删除
+func (r *fsckRun) checkImages() error {
+	// 多条记录可能共享同一文件，每个文件只校验一次
+	checked := make(map[string]bool)
+	corrupt := make(map[string]string)
+
+	err := r.s.imageRepo.EachImage(func(img *model.Image) error {
+		r.images = append(r.images, img)
+		r.report.Images++
+		r.report.Renditions += len(img.Renditions)
+		deleteImage := func() error { return r.deleteImage(img) }
+
+		key := img.StorageKey()
+		info, exists := r.files[key]
+		if !exists {
+			r.broken[img.ID] = true
+			r.addIssue(FsckIssue{
+				Kind:    FsckMissingFile,
+				Key:     key,
+				ImageID: img.ID.String(),
+				Detail:  "原图文件不存在",
+			}, FsckActionDeleteRecord, deleteImage)
+			return nil
+		}
+
+		if !checked[key] {
+			checked[key] = true
+			kind, detail := "", ""
+			if info.Size != img.Size {
+				kind, detail = FsckSizeMismatch, fmt.Sprintf("文件大小 %d 与记录 %d 不一致", info.Size, img.Size)
+			} else if r.opts.VerifyChecksums && img.ContentHash != "" {
+				sum, err := r.checksum(key)
+				if err != nil {
+					return err
+				}
+				if sum != img.ContentHash {
+					kind, detail = FsckChecksumMismatch, fmt.Sprintf("文件 SHA-256 %s 与记录 %s 不一致", sum, img.ContentHash)
+				}
+			}
+			if kind != "" {
+				corrupt[key] = kind
+				r.broken[img.ID] = true
+				r.addIssue(FsckIssue{
+					Kind:    kind,
+					Key:     key,
+					ImageID: img.ID.String(),
+					Detail:  detail,
+				}, FsckActionQuarantine, func() error {
+					if err := r.quarantine(key); err != nil {
+						return err
+					}
+					return r.deleteImage(img)
+				})
+				return nil
+			}
+		} else if kind, ok := corrupt[key]; ok {
+			// 与已发现损坏的图片共享同一文件
+			r.broken[img.ID] = true
+			r.addIssue(FsckIssue{
+				Kind:    kind,
+				Key:     key,
+				ImageID: img.ID.String(),
+				Detail:  "引用的文件已损坏",
+			}, FsckActionDeleteRecord, deleteImage)
+			return nil
+		}
+
+		for i := range img.Renditions {
+			rendition := img.Renditions[i]
+			if _, ok := r.files[rendition.FilePath]; ok {
+				continue
+			}
+			r.addIssue(FsckIssue{
+				Kind:    FsckMissingRenditionFile,
+				Key:     rendition.FilePath,
+				ImageID: img.ID.String(),
+				Detail:  fmt.Sprintf("衍生图片 %s 的文件不存在", rendition.Name),
+			}, FsckActionRegenerate, func() error { return r.regenerateRendition(img, rendition) })
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("检查图片记录失败: %w", err)
+	}
+	return nil
+}
+
+// checkEmbeddings 检查嵌入向量记录
+func (r *fsckRun) checkEmbeddings() error {
+	records, err := r.s.imageRepo.ListEmbeddingRecords()
+	if err != nil {
+		return fmt.Errorf("读取嵌入向量失败: %w", err)
+	}
+	r.report.Embeddings = len(records)
+
+	images := make(map[uuid.UUID]*model.Image, len(r.images))
+	for _, img := range r.images {
+		images[img.ID] = img
+	}
+
+	valid := make(map[uuid.UUID]bool)
+	for _, record := range records {
+		record := record
+		deleteRecord := func() error { return r.s.imageRepo.DeleteEmbedding(record.ID) }
+
+		if images[record.ImageID] == nil {
+			r.addIssue(FsckIssue{
+				Kind:        FsckOrphanEmbedding,
+				ImageID:     record.ImageID.String(),
+				EmbeddingID: record.ID.String(),
+				Detail:      "嵌入向量对应的图片不存在",
+			}, FsckActionDeleteRecord, deleteRecord)
+			continue
+		}
+
+		if problem := validateEmbedding(record.Embedding); problem != "" {
+			r.addIssue(FsckIssue{
+				Kind:        FsckMalformedEmbedding,
+				ImageID:     record.ImageID.String(),
+				EmbeddingID: record.ID.String(),
+				Detail:      problem,
+			}, FsckActionDeleteRecord, deleteRecord)
+			continue
+		}
+
+		// 保留最早创建的一条
+		if valid[record.ImageID] {
+			r.addIssue(FsckIssue{
+				Kind:        FsckDuplicateEmbedding,
+				ImageID:     record.ImageID.String(),
+				EmbeddingID: record.ID.String(),
+				Detail:      "同一图片有多条嵌入向量",
+			}, FsckActionDeleteRecord, deleteRecord)
+			continue
+		}
+		valid[record.ImageID] = true
+	}
+
+	for _, img := range r.images {
+		if valid[img.ID] || r.deleted[img.ID] {
+			continue
+		}
+		img := img
+		// 原图缺失或损坏时无法重新生成
+		var repair func() error
+		if !r.broken[img.ID] {
+			repair = func() error { return r.reembed(img) }
+		}
+		r.addIssue(FsckIssue{
+			Kind:    FsckMissingEmbedding,
+			ImageID: img.ID.String(),
+			Detail:  "图片没有可用的嵌入向量",
+		}, FsckActionReembed, repair)
+	}
+	return nil
+}
+
+// checkOrphanFiles 检查没有被任何记录引用的文件
+func (r *fsckRun) checkOrphanFiles() error {
+	referenced := make(map[string]bool)
+	for _, img := range r.images {
+		if r.deleted[img.ID] {
+			continue
+		}
+		referenced[img.StorageKey()] = true
+		for _, rendition := range img.Renditions {
+			referenced[rendition.FilePath] = true
+		}
+	}
+
+	// 正在上传的文件尚未提交，由上传失败处理或启动恢复负责清理
+	staged, err := r.s.imageRepo.ListStagedKeys()
+	if err != nil {
+		return fmt.Errorf("读取上传登记记录失败: %w", err)
+	}
+	for _, key := range staged {
+		referenced[key] = true
+	}
+
+	keys := make([]string, 0, len(r.files))
+	for key := range r.files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	for _, key := range keys {
+		info := r.files[key]
+		if referenced[key] || r.removedKeys[key] {
+			continue
+		}
+		if r.opts.GracePeriod > 0 && now.Sub(info.ModTime) < r.opts.GracePeriod {
+			continue
+		}
+		key := key
+		action, repair := FsckActionQuarantine, func() error { return r.quarantine(key) }
+		if r.opts.DeleteOrphans {
+			action, repair = FsckActionDeleteFile, func() error { return r.s.blobs.Delete(key) }
+		}
+		r.addIssue(FsckIssue{
+			Kind:   FsckOrphanFile,
+			Key:    key,
+			Detail: fmt.Sprintf("文件没有被任何图片引用，大小 %d，修改时间 %s", info.Size, info.ModTime.Format(time.RFC3339)),
+		}, action, repair)
+	}
+	return nil
+}
+
+// checkRefCounts 检查文件记录的引用计数
+func (r *fsckRun) checkRefCounts() error {
+	expected := make(map[string]model.Blob)
+	for _, img := range r.images {
+		if r.deleted[img.ID] {
+			continue
+		}
+		blob := expected[img.StorageKey()]
+		blob.Key, blob.ContentHash, blob.Size = img.StorageKey(), img.ContentHash, img.Size
+		blob.RefCount++
+		expected[blob.Key] = blob
+		for _, rendition := range img.Renditions {
+			blob := expected[rendition.FilePath]
+			blob.Key, blob.Size = rendition.FilePath, rendition.Size
+			blob.RefCount++
+			expected[blob.Key] = blob
+		}
+	}
+
+	blobs, err := r.s.imageRepo.ListBlobs()
+	if err != nil {
+		return fmt.Errorf("读取文件记录失败: %w", err)
+	}
+	r.report.Blobs = len(blobs)
+
+	actual := make(map[string]int, len(blobs))
+	for _, blob := range blobs {
+		actual[blob.Key] = blob.RefCount
+		if _, ok := expected[blob.Key]; !ok {
+			blob := blob
+			blob.RefCount = 0
+			expected[blob.Key] = blob
+		}
+	}
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		blob := expected[key]
+		if actual[key] == blob.RefCount {
+			continue
+		}
+		r.addIssue(FsckIssue{
+			Kind:   FsckRefCountMismatch,
+			Key:    key,
+			Detail: fmt.Sprintf("引用计数 %d 与实际引用数量 %d 不一致", actual[key], blob.RefCount),
+		}, FsckActionFixRefCount, func() error { return r.s.imageRepo.SetBlobRefCount(blob) })
+	}
+	return nil
+}
+
+// deleteImage 删除图片记录，以及不再被任何记录引用的文件
+func (r *fsckRun) deleteImage(img *model.Image) error {
+	orphaned, err := r.s.imageRepo.PurgeImage(img.ID)
+	if err != nil {
+		return err
+	}
+	r.deleted[img.ID] = true
+
+	var keys []string
+	for _, key := range orphaned {
+		if !r.removedKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	removed, err := r.s.deleteUnreferenced(keys)
+	for _, key := range removed {
+		r.removedKeys[key] = true
+	}
+	return err
+}
+
+// quarantine 将文件移动到隔离区
+func (r *fsckRun) quarantine(key string) error {
+	if r.removedKeys[key] {
+		return nil
+	}
+	reader, info, err := r.s.blobs.Get(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := r.s.blobs.Put(QuarantinePrefix+key, reader, info.Size, info.ContentType); err != nil {
+		return err
+	}
+	if err := r.s.blobs.Delete(key); err != nil {
+		return err
+	}
+	r.removedKeys[key] = true
+	return nil
+}
+
+// checksum 计算文件内容的 SHA-256
+func (r *fsckRun) checksum(key string) (string, error) {
+	reader, _, err := r.s.blobs.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("读取文件 %s 失败: %w", key, err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", fmt.Errorf("读取文件 %s 失败: %w", key, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// decodeOriginal 读取并解码图片的原图
+func (r *fsckRun) decodeOriginal(img *model.Image) (image.Image, error) {
+	reader, _, err := r.s.blobs.Get(img.StorageKey())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decoded, _, err := decodeImage(reader, r.s.decode)
+	return decoded, err
+}
+
+// reembed 从原图重新生成嵌入向量
+func (r *fsckRun) reembed(img *model.Image) error {
+	decoded, err := r.decodeOriginal(img)
+	if err != nil {
+		return err
+	}
+	return r.s.imageRepo.CreateImageEmbedding(&model.ImageEmbedding{
+		ImageID:   img.ID,
+		Embedding: r.s.generateEmbedding(r.s.pipeline.embeddingInput(decoded)),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+}
+
+// regenerateRendition 按记录中的尺寸和格式从原图重新生成衍生图片文件
+func (r *fsckRun) regenerateRendition(img *model.Image, rendition model.Rendition) error {
+	decoded, err := r.decodeOriginal(img)
+	if err != nil {
+		return err
+	}
+
+	quality := defaultRenderQuality
+	for _, cfg := range r.s.storageConfig.Renditions {
+		if cfg.Name == rendition.Name {
+			quality = cfg.Quality
+		}
+	}
+
+	scaled := resize.Thumbnail(uint(rendition.Width), uint(rendition.Height), decoded, resize.Lanczos3)
+	encoded := bytes.NewBuffer(nil)
+	if err := encodeImage(encoded, scaled, rendition.Format, quality); err != nil {
+		return err
+	}
+	return r.s.blobs.Put(rendition.FilePath, encoded, int64(encoded.Len()), storage.ContentTypeByExtension(rendition.Format))
+}
+
+// validateEmbedding 检查嵌入向量能否解析且维度正确，返回问题描述
+func validateEmbedding(raw []byte) string {
+	var embedding []float32
+	if err := json.Unmarshal(raw, &embedding); err != nil {
+		return fmt.Sprintf("无法解析嵌入向量: %v", err)
+	}
+	if len(embedding) != len(embeddingFeatures) {
+		return fmt.Sprintf("嵌入向量维度 %d 与预期 %d 不一致", len(embedding), len(embeddingFeatures))
+	}
+	for _, v := range embedding {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return "嵌入向量包含 NaN 或 Inf"
+		}
+	}
+	return ""
+}
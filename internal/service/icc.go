@@ -0,0 +1,415 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// iccHeaderSize ICC 配置文件头的长度
+const iccHeaderSize = 128
+
+// maxICCProfileSize 允许解析的 ICC 配置文件的最大长度
+const maxICCProfileSize = 4 << 20
+
+// srgbFromD50 将 D50 白点下的 XYZ（ICC 的 PCS）转换为线性 sRGB 的矩阵，已包含 Bradford 色适应
+var srgbFromD50 = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccProfile 解析后的 ICC 配置文件
+// 只支持矩阵/TRC 型的 RGB 配置文件（Adobe RGB、Display P3、ProPhoto RGB 等均属此类），
+// 其他配置文件（如 CMYK 或基于查找表的配置文件）只读取名称，不转换颜色
+type iccProfile struct {
+	name string
+	// matrix 线性 RGB 到 D50 XYZ 的矩阵，列为红、绿、蓝三原色
+	matrix [3][3]float64
+	curves [3]iccCurve
+	// convertible 是否可以转换到 sRGB
+	convertible bool
+}
+
+// iccCurve 将编码值（0-1）转换为线性值的色调响应曲线
+type iccCurve func(float64) float64
+
+// srgbColorants sRGB 三原色在 D50 白点下的 XYZ（已做 Bradford 色适应），列为红、绿、蓝
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+const (
+	// srgbColorantTolerance 判断三原色与 sRGB 相同时允许的误差，覆盖定点数舍入和不同厂商配置文件之间的差异
+	srgbColorantTolerance = 0.003
+	// srgbCurveTolerance 判断色调响应曲线与 sRGB 相同时允许的误差，按 sRGB 编码值计算，约为 8 位颜色的一级
+	srgbCurveTolerance = 1.0 / 255
+	// srgbCurveSamples 比较色调响应曲线时的采样点数
+	srgbCurveSamples = 64
+)
+
+// isSRGB 判断配置文件的三原色和色调响应曲线是否与 sRGB 相同，相同时图片不需要转换
+// 按配置文件的实际内容而不是名称判断，名称中带有 sRGB 但内容不同的配置文件同样会转换
+func (p *iccProfile) isSRGB() bool {
+	if !p.convertible {
+		return false
+	}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			if math.Abs(p.matrix[row][col]-srgbColorants[row][col]) > srgbColorantTolerance {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for i := 0; i <= srgbCurveSamples; i++ {
+			x := float64(i) / srgbCurveSamples
+			if math.Abs(encodeSRGB(curve(x))-x) > srgbCurveTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// encodeSRGB 将线性值转换为 sRGB 编码值
+func encodeSRGB(v float64) float64 {
+	v = math.Min(math.Max(v, 0), 1)
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// readICCProfile 读取图片中嵌入的 ICC 配置文件，支持 JPEG 和 PNG，没有时返回 nil
+func readICCProfile(data []byte, format string) *iccProfile {
+	var raw []byte
+	switch format {
+	case "jpeg":
+		raw = jpegICCData(data)
+	case "png":
+		raw = pngICCData(data)
+	}
+	if raw == nil {
+		return nil
+	}
+	profile, err := parseICCProfile(raw)
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+// jpegICCData 拼接 JPEG 中 APP2 段保存的 ICC 配置文件，配置文件较大时分为多段按序号保存
+func jpegICCData(data []byte) []byte {
+	const iccMarker = "ICC_PROFILE\x00"
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+
+	chunks := make(map[int][]byte)
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil
+		}
+		marker := data[pos+1]
+		// 填充字节和没有长度的标记
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			pos += 2
+			continue
+		}
+		// 图像数据开始后不再有 APP 段
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xe2 && len(segment) > len(iccMarker)+2 && string(segment[:len(iccMarker)]) == iccMarker {
+			seq := int(segment[len(iccMarker)])
+			chunks[seq] = segment[len(iccMarker)+2:]
+		}
+		pos += 2 + length
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	var profile []byte
+	for _, seq := range seqs {
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile
+}
+
+// pngICCData 读取 PNG 中 iCCP 块保存的 ICC 配置文件
+func pngICCData(data []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil
+	}
+	for pos := len(signature); pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || chunkType == "IDAT" {
+			return nil
+		}
+		if chunkType == "iCCP" {
+			chunk := data[pos+8 : pos+8+length]
+			// 配置文件名称（以空字符结尾）、压缩方式（只有 0，即 zlib），然后是压缩后的配置文件
+			nameEnd := bytes.IndexByte(chunk, 0)
+			if nameEnd < 0 || nameEnd+2 > len(chunk) || chunk[nameEnd+1] != 0 {
+				return nil
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(chunk[nameEnd+2:]))
+			if err != nil {
+				return nil
+			}
+			defer reader.Close()
+			profile, err := io.ReadAll(io.LimitReader(reader, maxICCProfileSize))
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		pos += 12 + length
+	}
+	return nil
+}
+
+// parseICCProfile 解析 ICC 配置文件的名称，以及矩阵/TRC 型 RGB 配置文件的三原色和色调响应曲线
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < iccHeaderSize+4 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("无效的 ICC 配置文件")
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[iccHeaderSize:]))
+	for i := 0; i < count; i++ {
+		entry := iccHeaderSize + 4 + i*12
+		if entry+12 > len(data) {
+			break
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			continue
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &iccProfile{name: iccDescription(tags["desc"])}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return profile, nil
+	}
+
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := iccXYZ(tags[sig])
+		if !ok {
+			return profile, nil
+		}
+		for row := 0; row < 3; row++ {
+			profile.matrix[row][i] = xyz[row]
+		}
+	}
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, ok := iccTRC(tags[sig])
+		if !ok {
+			return profile, nil
+		}
+		profile.curves[i] = curve
+	}
+	profile.convertible = true
+	return profile, nil
+}
+
+// iccDescription 读取 desc 标签中的配置文件名称，支持 ICC v2 的 desc 类型和 v4 的 mluc 类型
+func iccDescription(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n <= 0 || 12+n > len(tag) {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimRight(string(tag[12:12+n]), "\x00"))
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		// 使用第一条记录
+		n := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if n <= 0 || offset+n > len(tag) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimSpace(strings.TrimRight(string(utf16.Decode(units)), "\x00"))
+	}
+	return ""
+}
+
+// iccXYZ 读取 XYZ 类型标签中的第一组 XYZ 值
+func iccXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+	return xyz, true
+}
+
+// iccTRC 读取 curv 或 para 类型的色调响应曲线
+func iccTRC(tag []byte) (iccCurve, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(x float64) float64 { return x }, true
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, true
+		case n > 1 && len(tag) >= 12+n*2:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+			}
+			return func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := int(pos)
+				if i >= n-1 {
+					return table[n-1]
+				}
+				frac := pos - float64(i)
+				return table[i]*(1-frac) + table[i+1]*frac
+			}, true
+		}
+	case "para":
+		funcType := int(binary.BigEndian.Uint16(tag[8:]))
+		paramCounts := []int{1, 3, 4, 5, 7}
+		if funcType >= len(paramCounts) || len(tag) < 12+paramCounts[funcType]*4 {
+			return nil, false
+		}
+		// 参数依次为 g、a、b、c、d、e、f，未使用的参数按 ICC 规范取值
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < paramCounts[funcType]; i++ {
+			p[i] = s15Fixed16(tag[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		// 类型 1 和 2 的分段点为 -b/a，a 为 0 的曲线无效
+		if (funcType == 1 || funcType == 2) && a == 0 {
+			return nil, false
+		}
+		switch funcType {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(math.Max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, true
+	}
+	return nil, false
+}
+
+// s15Fixed16 读取 ICC 的 s15Fixed16Number 定点数
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// convertToSRGB 按配置文件将图片转换为 sRGB，结果为 8 位的 RGBA 图片，透明度不变
+func convertToSRGB(img image.Image, profile *iccProfile) image.Image {
+	// 编码值到线性值的查找表
+	var linear [3][256]float64
+	for c := 0; c < 3; c++ {
+		for i := 0; i < 256; i++ {
+			linear[c][i] = profile.curves[c](float64(i) / 255)
+		}
+	}
+
+	// 组合 RGB -> XYZ -> 线性 sRGB 两个矩阵
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += srgbFromD50[i][k] * profile.matrix[k][j]
+			}
+		}
+	}
+
+	// 线性值到 sRGB 编码值的查找表
+	const encodeSteps = 4096
+	var encode [encodeSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(encodeSRGB(float64(i)/encodeSteps) * 255))
+	}
+	toSRGB := func(v float64) uint8 {
+		return encode[int(math.Round(math.Min(math.Max(v, 0), 1)*encodeSteps))]
+	}
+
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		pix := out.Pix[i : i+4 : i+4]
+		a := pix[3]
+		if a == 0 {
+			continue
+		}
+		// RGBA 为预乘 alpha 的颜色，转换前先还原
+		var rgb [3]float64
+		for c := 0; c < 3; c++ {
+			v := pix[c]
+			if a != 0xff {
+				v = uint8(math.Min(float64(v)*255/float64(a)+0.5, 255))
+			}
+			rgb[c] = linear[c][v]
+		}
+		for c := 0; c < 3; c++ {
+			v := toSRGB(m[c][0]*rgb[0] + m[c][1]*rgb[1] + m[c][2]*rgb[2])
+			if a != 0xff {
+				v = uint8((int(v)*int(a) + 127) / 255)
+			}
+			pix[c] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// iccFixed 编码 s15Fixed16Number 定点数
+func iccFixed(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+// iccXYZTag 生成 XYZ 类型标签
+func iccXYZTag(xyz [3]float64) []byte {
+	tag := make([]byte, 8, 20)
+	copy(tag, "XYZ ")
+	for _, v := range xyz {
+		tag = binary.BigEndian.AppendUint32(tag, iccFixed(v))
+	}
+	return tag
+}
+
+// iccDescTag 生成 ICC v2 的 desc 标签
+func iccDescTag(name string) []byte {
+	tag := make([]byte, 12)
+	copy(tag, "desc")
+	binary.BigEndian.PutUint32(tag[8:], uint32(len(name)+1))
+	tag = append(tag, name...)
+	return append(tag, make([]byte, 80)...)
+}
+
+// iccGammaTag 生成单一 gamma 值的 curv 标签
+func iccGammaTag(gamma float64) []byte {
+	tag := make([]byte, 14)
+	copy(tag, "curv")
+	binary.BigEndian.PutUint32(tag[8:], 1)
+	binary.BigEndian.PutUint16(tag[12:], uint16(math.Round(gamma*256)))
+	return tag
+}
+
+// iccTableTag 生成按 sRGB 曲线采样 n 个点的 curv 标签
+func iccTableTag(n int) []byte {
+	tag := make([]byte, 12)
+	copy(tag, "curv")
+	binary.BigEndian.PutUint32(tag[8:], uint32(n))
+	for i := 0; i < n; i++ {
+		x := float64(i) / float64(n-1)
+		v := x / 12.92
+		if x > 0.04045 {
+			v = math.Pow((x+0.055)/1.055, 2.4)
+		}
+		tag = binary.BigEndian.AppendUint16(tag, uint16(math.Round(v*65535)))
+	}
+	return tag
+}
+
+// iccParaTag 生成 para 类型标签
+func iccParaTag(funcType uint16, params ...float64) []byte {
+	tag := make([]byte, 12)
+	copy(tag, "para")
+	binary.BigEndian.PutUint16(tag[8:], funcType)
+	for _, v := range params {
+		tag = binary.BigEndian.AppendUint32(tag, iccFixed(v))
+	}
+	return tag
+}
+
+// srgbParaTag sRGB 的色调响应曲线
+var srgbParaTag = iccParaTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+// displayP3Colorants Display P3 三原色在 D50 白点下的 XYZ，列为红、绿、蓝
+var displayP3Colorants = [3][3]float64{
+	{0.5151, 0.2920, 0.1571},
+	{0.2412, 0.6922, 0.0666},
+	{-0.0011, 0.0419, 0.7841},
+}
+
+// iccTag 配置文件中的一个标签
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+// buildICCProfile 生成矩阵/TRC 型的 RGB 配置文件，三个通道使用相同的曲线
+func buildICCProfile(name string, colorants [3][3]float64, trc []byte) []byte {
+	tags := []iccTag{{"desc", iccDescTag(name)}}
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tags = append(tags, iccTag{sig, iccXYZTag([3]float64{colorants[0][i], colorants[1][i], colorants[2][i]})})
+	}
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags = append(tags, iccTag{sig, trc})
+	}
+
+	header := make([]byte, iccHeaderSize)
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := iccHeaderSize + 4 + 12*len(tags)
+	var data []byte
+	for _, tag := range tags {
+		for (offset+len(data))%4 != 0 {
+			data = append(data, 0)
+		}
+		table = append(table, tag.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		data = append(data, tag.data...)
+	}
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func TestICCProfileIsSRGB(t *testing.T) {
+	// 常见 sRGB 配置文件中的三原色与标准值有少量舍入差异
+	rounded := [3][3]float64{
+		{0.4361, 0.3851, 0.1431},
+		{0.2225, 0.7169, 0.0606},
+		{0.0139, 0.0971, 0.7141},
+	}
+
+	tests := []struct {
+		name      string
+		desc      string
+		colorants [3][3]float64
+		trc       []byte
+		want      bool
+	}{
+		{"sRGB 参数曲线", "sRGB IEC61966-2.1", srgbColorants, srgbParaTag, true},
+		{"sRGB 查找表曲线", "sRGB IEC61966-2.1", srgbColorants, iccTableTag(1024), true},
+		{"名称不同的 sRGB", "c2", rounded, iccTableTag(4096), true},
+		{"gamma 2.2 曲线", "sRGB-like gamma 2.2", srgbColorants, iccGammaTag(2.2), false},
+		{"线性曲线", "Linear sRGB", srgbColorants, iccGammaTag(1), false},
+		{"名称带 sRGB 的 Display P3", "sRGB (wrong name)", displayP3Colorants, srgbParaTag, false},
+		{"Display P3", "Display P3", displayP3Colorants, srgbParaTag, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := parseICCProfile(buildICCProfile(tt.desc, tt.colorants, tt.trc))
+			if err != nil {
+				t.Fatalf("parseICCProfile: %v", err)
+			}
+			if !profile.convertible {
+				t.Fatal("配置文件不可转换")
+			}
+			if profile.name != tt.desc {
+				t.Errorf("name = %q, want %q", profile.name, tt.desc)
+			}
+			if got := profile.isSRGB(); got != tt.want {
+				t.Errorf("isSRGB() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICCParametricCurve(t *testing.T) {
+	tests := []struct {
+		name   string
+		tag    []byte
+		ok     bool
+		at     float64
+		expect float64
+	}{
+		{"类型 0", iccParaTag(0, 2.0), true, 0.5, 0.25},
+		{"类型 1", iccParaTag(1, 1.0, 2.0, -0.5), true, 0.5, 0.5},
+		{"类型 1 分段点以下", iccParaTag(1, 1.0, 2.0, -0.5), true, 0.2, 0},
+		{"类型 2", iccParaTag(2, 1.0, 2.0, -0.5, 0.1), true, 0.2, 0.1},
+		{"类型 3 sRGB", srgbParaTag, true, 0.5, 0.2140},
+		{"类型 1 a 为 0", iccParaTag(1, 2.2, 0, 0.1), false, 0, 0},
+		{"类型 2 a 为 0", iccParaTag(2, 2.2, 0, 0.1, 0.2), false, 0, 0},
+		{"参数不足", iccParaTag(3, 2.4, 1), false, 0, 0},
+		{"未知类型", iccParaTag(5, 1), false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curve, ok := iccTRC(tt.tag)
+			if ok != tt.ok {
+				t.Fatalf("iccTRC ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			for i := 0; i <= 16; i++ {
+				if v := curve(float64(i) / 16); math.IsNaN(v) || math.IsInf(v, 0) {
+					t.Fatalf("curve(%v) = %v", float64(i)/16, v)
+				}
+			}
+			if got := curve(tt.at); math.Abs(got-tt.expect) > 0.001 {
+				t.Errorf("curve(%v) = %v, want %v", tt.at, got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestICCProfileZeroSlopeNotConvertible(t *testing.T) {
+	profile, err := parseICCProfile(buildICCProfile("broken", srgbColorants, iccParaTag(1, 2.2, 0, 0.1)))
+	if err != nil {
+		t.Fatalf("parseICCProfile: %v", err)
+	}
+	if profile.convertible || profile.isSRGB() {
+		t.Errorf("a 为 0 的曲线不应可以转换: convertible=%v", profile.convertible)
+	}
+}
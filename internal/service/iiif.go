@@ -0,0 +1,585 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/google/uuid"
+	"github.com/nfnt/resize"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// IIIFContext IIIF Image API 3.0 的 JSON-LD 上下文
+	IIIFContext = "http://iiif.io/api/image/3/context.json"
+	// IIIFProfileURI 支持的 IIIF 合规级别
+	IIIFProfileURI = "http://iiif.io/api/image/3/level1.json"
+	// defaultIIIFTileSize 默认的瓦片边长
+	defaultIIIFTileSize = 512
+)
+
+var (
+	// ErrInvalidIIIFRequest IIIF 请求参数语法错误或超出图片范围
+	ErrInvalidIIIFRequest = errors.New("无效的 IIIF 请求")
+	// ErrIIIFNotImplemented IIIF 请求参数合法，但服务不支持
+	ErrIIIFNotImplemented = errors.New("不支持的 IIIF 功能")
+)
+
+// iiifFormats IIIF 规范定义的输出格式，只有 jpg 和 png 被支持
+var iiifFormats = map[string]bool{
+	"jpg": true, "png": true, "tif": true, "gif": true, "pdf": true, "jp2": true, "webp": true,
+}
+
+// iiifQualities 支持的图像质量
+var iiifQualities = map[string]bool{
+	"default": true, "color": true, "gray": true, "bitonal": true,
+}
+
+// IIIFRegion IIIF 区域参数
+type IIIFRegion struct {
+	Full    bool
+	Square  bool
+	Percent bool
+	X       float64
+	Y       float64
+	W       float64
+	H       float64
+}
+
+// IIIFSize IIIF 尺寸参数
+type IIIFSize struct {
+	// Upscale 允许输出大于区域尺寸，对应 ^ 前缀
+	Upscale bool
+	Max     bool
+	// Confined 在宽高范围内等比缩放，对应 ! 前缀
+	Confined bool
+	Percent  float64
+	// Width、Height 为 0 表示按另一边等比计算
+	Width  int
+	Height int
+}
+
+// IIIFRequest IIIF 图片请求
+type IIIFRequest struct {
+	Region   IIIFRegion
+	Size     IIIFSize
+	Rotation int
+	// Mirror 先水平镜像再旋转，对应 ! 前缀
+	Mirror  bool
+	Quality string
+	Format  string
+}
+
+// IIIFSizeInfo info.json 中的尺寸
+type IIIFSizeInfo struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// IIIFTileInfo info.json 中的瓦片描述
+type IIIFTileInfo struct {
+	Width        int   `json:"width"`
+	Height       int   `json:"height"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+// IIIFInfo IIIF 图片信息，对应 info.json
+type IIIFInfo struct {
+	Context        string         `json:"@context"`
+	ID             string         `json:"id"`
+	Type           string         `json:"type"`
+	Protocol       string         `json:"protocol"`
+	Profile        string         `json:"profile"`
+	Width          int            `json:"width"`
+	Height         int            `json:"height"`
+	MaxWidth       int            `json:"maxWidth,omitempty"`
+	MaxHeight      int            `json:"maxHeight,omitempty"`
+	Sizes          []IIIFSizeInfo `json:"sizes,omitempty"`
+	Tiles          []IIIFTileInfo `json:"tiles,omitempty"`
+	ExtraFormats   []string       `json:"extraFormats,omitempty"`
+	ExtraQualities []string       `json:"extraQualities,omitempty"`
+	ExtraFeatures  []string       `json:"extraFeatures,omitempty"`
+}
+
+// ParseIIIFRequest 解析 IIIF 图片请求的 {region}/{size}/{rotation}/{quality}.{format} 参数
+func ParseIIIFRequest(region, size, rotation, qualityFormat string) (IIIFRequest, error) {
+	var req IIIFRequest
+	var err error
+
+	if req.Region, err = parseIIIFRegion(region); err != nil {
+		return req, err
+	}
+	if req.Size, err = parseIIIFSize(size); err != nil {
+		return req, err
+	}
+
+	if strings.HasPrefix(rotation, "!") {
+		req.Mirror = true
+		rotation = rotation[1:]
+	}
+	degrees, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || degrees < 0 || degrees > 360 {
+		return req, fmt.Errorf("%w: 无效的旋转角度 %s", ErrInvalidIIIFRequest, rotation)
+	}
+	if degrees != math.Trunc(degrees) || int(degrees)%90 != 0 {
+		return req, fmt.Errorf("%w: 只支持 90 度整数倍的旋转", ErrIIIFNotImplemented)
+	}
+	req.Rotation = int(degrees) % 360
+
+	quality, format, ok := strings.Cut(qualityFormat, ".")
+	if !ok || !iiifQualities[quality] {
+		return req, fmt.Errorf("%w: 无效的图像质量 %s", ErrInvalidIIIFRequest, qualityFormat)
+	}
+	if !iiifFormats[format] {
+		return req, fmt.Errorf("%w: 无效的输出格式 %s", ErrInvalidIIIFRequest, format)
+	}
+	if format != "jpg" && format != "png" {
+		return req, fmt.Errorf("%w: 不支持的输出格式 %s", ErrIIIFNotImplemented, format)
+	}
+	req.Quality = quality
+	req.Format = format
+	return req, nil
+}
+
+// parseIIIFRegion 解析区域参数：full、square、x,y,w,h 或 pct:x,y,w,h
+func parseIIIFRegion(value string) (IIIFRegion, error) {
+	var region IIIFRegion
+	switch value {
+	case "full":
+		region.Full = true
+		return region, nil
+	case "square":
+		region.Square = true
+		return region, nil
+	}
+
+	if strings.HasPrefix(value, "pct:") {
+		region.Percent = true
+		value = strings.TrimPrefix(value, "pct:")
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return region, fmt.Errorf("%w: 无效的区域 %s", ErrInvalidIIIFRequest, value)
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		// 像素区域只允许整数
+		if err != nil || v < 0 || (!region.Percent && v != math.Trunc(v)) {
+			return region, fmt.Errorf("%w: 无效的区域 %s", ErrInvalidIIIFRequest, value)
+		}
+		values[i] = v
+	}
+	region.X, region.Y, region.W, region.H = values[0], values[1], values[2], values[3]
+	if region.W <= 0 || region.H <= 0 {
+		return region, fmt.Errorf("%w: 区域宽高必须大于 0", ErrInvalidIIIFRequest)
+	}
+	return region, nil
+}
+
+// parseIIIFSize 解析尺寸参数：max、w,、,h、pct:n、w,h、!w,h，均可带 ^ 前缀
+func parseIIIFSize(value string) (IIIFSize, error) {
+	var size IIIFSize
+	original := value
+	if strings.HasPrefix(value, "^") {
+		size.Upscale = true
+		value = value[1:]
+	}
+
+	invalid := fmt.Errorf("%w: 无效的尺寸 %s", ErrInvalidIIIFRequest, original)
+	switch {
+	case value == "max":
+		size.Max = true
+		return size, nil
+	case strings.HasPrefix(value, "pct:"):
+		pct, err := strconv.ParseFloat(strings.TrimPrefix(value, "pct:"), 64)
+		if err != nil || pct <= 0 || (pct > 100 && !size.Upscale) {
+			return size, invalid
+		}
+		size.Percent = pct
+		return size, nil
+	case strings.HasPrefix(value, "!"):
+		size.Confined = true
+		value = value[1:]
+	}
+
+	width, height, ok := strings.Cut(value, ",")
+	if !ok {
+		return size, invalid
+	}
+	var err error
+	if width != "" {
+		if size.Width, err = strconv.Atoi(width); err != nil || size.Width <= 0 {
+			return size, invalid
+		}
+	}
+	if height != "" {
+		if size.Height, err = strconv.Atoi(height); err != nil || size.Height <= 0 {
+			return size, invalid
+		}
+	}
+	if size.Width == 0 && size.Height == 0 {
+		return size, invalid
+	}
+	if size.Confined && (size.Width == 0 || size.Height == 0) {
+		return size, invalid
+	}
+	return size, nil
+}
+
+// cacheKey 返回规范化的请求参数，等价的请求得到相同的结果
+func (r IIIFRequest) cacheKey() string {
+	region := "full"
+	switch {
+	case r.Region.Square:
+		region = "square"
+	case r.Region.Percent:
+		region = fmt.Sprintf("pct:%g,%g,%g,%g", r.Region.X, r.Region.Y, r.Region.W, r.Region.H)
+	case !r.Region.Full:
+		region = fmt.Sprintf("%g,%g,%g,%g", r.Region.X, r.Region.Y, r.Region.W, r.Region.H)
+	}
+
+	size := ""
+	if r.Size.Upscale {
+		size = "^"
+	}
+	switch {
+	case r.Size.Max:
+		size += "max"
+	case r.Size.Percent > 0:
+		size += fmt.Sprintf("pct:%g", r.Size.Percent)
+	default:
+		if r.Size.Confined {
+			size += "!"
+		}
+		if r.Size.Width > 0 {
+			size += strconv.Itoa(r.Size.Width)
+		}
+		size += ","
+		if r.Size.Height > 0 {
+			size += strconv.Itoa(r.Size.Height)
+		}
+	}
+
+	rotation := strconv.Itoa(r.Rotation)
+	if r.Mirror {
+		rotation = "!" + rotation
+	}
+	quality := r.Quality
+	if quality == "default" {
+		quality = "color"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s.%s", region, size, rotation, quality, r.Format)
+}
+
+// IIIFInfo 返回图片的 IIIF 信息，baseURL 为根据请求推断的服务地址，配置了对外地址时以配置为准
+func (s *renderService) IIIFInfo(id uuid.UUID, baseURL string) (*IIIFInfo, error) {
+	image, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.renderConfig.IIIFBaseURL != "" {
+		baseURL = s.renderConfig.IIIFBaseURL
+	}
+
+	info := &IIIFInfo{
+		Context:        IIIFContext,
+		ID:             baseURL + "/" + image.ID.String(),
+		Type:           "ImageService3",
+		Protocol:       "http://iiif.io/api/image",
+		Profile:        "level1",
+		Width:          image.Width,
+		Height:         image.Height,
+		ExtraFormats:   []string{"png"},
+		ExtraQualities: []string{"color", "gray", "bitonal"},
+		// level1 之外额外支持的功能
+		ExtraFeatures: []string{
+			"mirroring", "profileLinkHeader", "regionByPct", "rotationBy90s",
+			"sizeByConfinedWh", "sizeByPct", "sizeUpscaling",
+		},
+	}
+
+	maxDimension := s.renderConfig.MaxDimension
+	if maxDimension > 0 && (image.Width > maxDimension || image.Height > maxDimension) {
+		info.MaxWidth = maxDimension
+		info.MaxHeight = maxDimension
+	}
+
+	// 按 2 的幂缩小，直到整张图片能放进一个瓦片
+	tileSize := s.tileSize()
+	scaleFactors := []int{1}
+	for factor := 2; image.Width/(factor/2) > tileSize || image.Height/(factor/2) > tileSize; factor *= 2 {
+		scaleFactors = append(scaleFactors, factor)
+	}
+	info.Tiles = []IIIFTileInfo{{Width: tileSize, Height: tileSize, ScaleFactors: scaleFactors}}
+
+	// 推荐尺寸从小到大排列，不超过最大宽高
+	for i := len(scaleFactors) - 1; i >= 0; i-- {
+		width := int(math.Ceil(float64(image.Width) / float64(scaleFactors[i])))
+		height := int(math.Ceil(float64(image.Height) / float64(scaleFactors[i])))
+		if maxDimension > 0 && (width > maxDimension || height > maxDimension) {
+			break
+		}
+		info.Sizes = append(info.Sizes, IIIFSizeInfo{Width: width, Height: height})
+	}
+
+	return info, nil
+}
+
+// RenderIIIF 按 IIIF 请求参数处理图片，结果与 render 接口共用磁盘缓存
+func (s *renderService) RenderIIIF(id uuid.UUID, req IIIFRequest) (*RenderedImage, error) {
+	original, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	source := original.ContentHash
+	if source == "" {
+		source = original.ID.String()
+	}
+	sum := sha256.Sum256([]byte(source + "|iiif|" + req.cacheKey()))
+	digest := hex.EncodeToString(sum[:])
+	format := req.Format
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	cacheKey := digest + "." + format
+
+	rendered := &RenderedImage{
+		Format:      format,
+		ContentType: storage.ContentTypeByExtension(format),
+		ETag:        `"` + digest[:32] + `"`,
+	}
+
+	if data, modTime, ok := s.cache.Get(cacheKey); ok {
+		rendered.Data = data
+		rendered.ModTime = modTime
+		rendered.Cached = true
+		return rendered, nil
+	}
+
+	// 先用记录中的尺寸校验参数，避免为无效请求读取原图
+	if _, _, err := s.resolveIIIF(original.Width, original.Height, req); err != nil {
+		return nil, err
+	}
+
+	src, err := s.loadSource(original)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	region, size, err := s.resolveIIIF(bounds.Dx(), bounds.Dy(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := cropImage(src, region.Add(bounds.Min))
+	if size.X != region.Dx() || size.Y != region.Dy() {
+		out = resize.Resize(uint(size.X), uint(size.Y), out, resize.Lanczos3)
+	}
+	if req.Mirror {
+		out = mirrorImage(out)
+	}
+	if req.Rotation != 0 {
+		out = rotateImage(out, req.Rotation)
+	}
+	switch req.Quality {
+	case "gray":
+		out = grayImage(out, false)
+	case "bitonal":
+		out = grayImage(out, true)
+	}
+
+	encoded := bytes.NewBuffer(nil)
+	if err := encodeImage(encoded, out, format, defaultRenderQuality); err != nil {
+		logrus.Errorf("编码 IIIF 图片失败: %v", err)
+		return nil, err
+	}
+	rendered.Data = encoded.Bytes()
+
+	modTime, err := s.cache.Put(cacheKey, rendered.Data)
+	if err != nil {
+		logrus.Warnf("写入图片处理缓存失败: %v", err)
+		modTime = time.Now()
+	}
+	rendered.ModTime = modTime
+	return rendered, nil
+}
+
+// resolveIIIF 根据图片尺寸计算请求的像素区域和输出尺寸
+func (s *renderService) resolveIIIF(width, height int, req IIIFRequest) (image.Rectangle, image.Point, error) {
+	// 计算区域，超出图片的部分被裁掉
+	var region image.Rectangle
+	switch {
+	case req.Region.Full:
+		region = image.Rect(0, 0, width, height)
+	case req.Region.Square:
+		side := width
+		if height < side {
+			side = height
+		}
+		x, y := (width-side)/2, (height-side)/2
+		region = image.Rect(x, y, x+side, y+side)
+	case req.Region.Percent:
+		x := int(math.Round(req.Region.X * float64(width) / 100))
+		y := int(math.Round(req.Region.Y * float64(height) / 100))
+		w := int(math.Round(req.Region.W * float64(width) / 100))
+		h := int(math.Round(req.Region.H * float64(height) / 100))
+		region = image.Rect(x, y, x+w, y+h)
+	default:
+		x, y := int(req.Region.X), int(req.Region.Y)
+		region = image.Rect(x, y, x+int(req.Region.W), y+int(req.Region.H))
+	}
+	region = region.Intersect(image.Rect(0, 0, width, height))
+	if region.Empty() {
+		return region, image.Point{}, fmt.Errorf("%w: 区域超出图片范围", ErrInvalidIIIFRequest)
+	}
+
+	regionW, regionH := float64(region.Dx()), float64(region.Dy())
+	maxDimension := float64(s.renderConfig.MaxDimension)
+
+	var w, h float64
+	switch {
+	case req.Size.Max:
+		w, h = regionW, regionH
+		if maxDimension > 0 {
+			// max 返回不超过最大宽高的最大尺寸
+			scale := math.Min(maxDimension/regionW, maxDimension/regionH)
+			if scale < 1 || req.Size.Upscale {
+				w, h = regionW*scale, regionH*scale
+			}
+		}
+	case req.Size.Percent > 0:
+		w, h = regionW*req.Size.Percent/100, regionH*req.Size.Percent/100
+	case req.Size.Confined:
+		scale := math.Min(float64(req.Size.Width)/regionW, float64(req.Size.Height)/regionH)
+		if scale > 1 && !req.Size.Upscale {
+			scale = 1
+		}
+		w, h = regionW*scale, regionH*scale
+	case req.Size.Height == 0:
+		w = float64(req.Size.Width)
+		h = regionH * w / regionW
+	case req.Size.Width == 0:
+		h = float64(req.Size.Height)
+		w = regionW * h / regionH
+	default:
+		w, h = float64(req.Size.Width), float64(req.Size.Height)
+	}
+
+	size := image.Pt(int(math.Max(1, math.Round(w))), int(math.Max(1, math.Round(h))))
+	if !req.Size.Upscale && (size.X > region.Dx() || size.Y > region.Dy()) {
+		return region, size, fmt.Errorf("%w: 输出尺寸超过区域尺寸，放大需要使用 ^ 前缀", ErrInvalidIIIFRequest)
+	}
+	if maxDimension > 0 && (float64(size.X) > maxDimension || float64(size.Y) > maxDimension) {
+		return region, size, fmt.Errorf("%w: 输出尺寸 %dx%d 超过上限 %d", ErrInvalidIIIFRequest, size.X, size.Y, s.renderConfig.MaxDimension)
+	}
+	return region, size, nil
+}
+
+// getImage 获取图片记录，不存在时返回 ErrImageNotFound
+func (s *renderService) getImage(id uuid.UUID) (*model.Image, error) {
+	image, err := s.imageRepo.GetImageByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	return image, nil
+}
+
+// tileSize 返回配置的瓦片边长
+func (s *renderService) tileSize() int {
+	if s.renderConfig.IIIFTileSize > 0 {
+		return s.renderConfig.IIIFTileSize
+	}
+	return defaultIIIFTileSize
+}
+
+// cropImage 裁剪图片，区域与图片范围相同时直接返回原图
+func cropImage(src image.Image, region image.Rectangle) image.Image {
+	if region == src.Bounds() {
+		return src
+	}
+	if sub, ok := src.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(region)
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), src, region.Min, draw.Src)
+	return cropped
+}
+
+// mirrorImage 水平镜像图片
+func mirrorImage(src image.Image) image.Image {
+	bounds := src.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			out.Set(bounds.Dx()-1-x, y, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// rotateImage 顺时针旋转 90、180 或 270 度
+func rotateImage(src image.Image, degrees int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	var out *image.RGBA
+	if degrees == 180 {
+		out = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := src.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch degrees {
+			case 90:
+				out.Set(h-1-y, x, c)
+			case 180:
+				out.Set(w-1-x, h-1-y, c)
+			case 270:
+				out.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return out
+}
+
+// grayImage 转换为灰度图，bitonal 为 true 时以 50% 亮度为阈值转换为黑白图
+func grayImage(src image.Image, bitonal bool) image.Image {
+	bounds := src.Bounds()
+	out := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray := color.GrayModel.Convert(src.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			if bitonal {
+				if gray.Y >= 128 {
+					gray.Y = 255
+				} else {
+					gray.Y = 0
+				}
+			}
+			out.SetGray(x, y, gray)
+		}
+	}
+	return out
+}
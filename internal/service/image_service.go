@@ -2,59 +2,142 @@ package service
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/bytedance/ImageSearch/internal/config"
 	"github.com/bytedance/ImageSearch/internal/model"
 	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/storage"
 	"github.com/google/uuid"
-	"github.com/nfnt/resize"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // ImageService 图片服务接口
 type ImageService interface {
-	UploadImage(file multipart.File, fileHeader *multipart.FileHeader) (*model.Image, error)
+	UploadImage(file multipart.File, fileHeader *multipart.FileHeader, namespace string) (*model.Image, error)
 	GetImage(id uuid.UUID) (*model.Image, error)
-	ListImages(page, pageSize int) ([]model.Image, int64, error)
+	ListImages(page, pageSize int, filter repository.ListFilter) ([]model.Image, int64, error)
 	DeleteImage(id uuid.UUID) error
-	SearchImagesByImage(file multipart.File) ([]model.Image, []float32, error)
+	SearchImagesByImage(file multipart.File, opts SearchOptions) (*SearchOutcome, error)
+	SearchSimilarByImageID(id uuid.UUID, opts SearchOptions) (*SearchOutcome, error)
+	SearchBatch(queries []BatchQuery, opts SearchOptions) ([]BatchResult, error)
+	ListTrash(page, pageSize int) ([]TrashedImage, int64, error)
+	RestoreImage(id uuid.UUID) (*model.Image, error)
+	PurgeImage(id uuid.UUID) error
+	PurgeExpiredImages() (int, error)
+	Usage() (*UsageReport, error)
+	ApplyRetention(dryRun bool) (*RetentionReport, error)
+	Fsck(opts FsckOptions) (*FsckReport, error)
+	RecoverUploads() (*RecoveryReport, error)
+	RotateKeys(dryRun bool) (*KeyRotationReport, error)
 }
 
+// SearchOptions 搜索选项，在仓库层过滤条件的基础上增加结果重排选项
+type SearchOptions struct {
+	repository.SearchOptions
+	// Diversify 是否使用 MMR 对结果进行多样化重排
+	Diversify bool
+	// Lambda MMR 相关性权重，取值 (0, 1]，越大越偏向相关性，0 表示使用默认值
+	Lambda float64
+	// Collapse 是否折叠近似重复的结果
+	Collapse bool
+	// CollapseThreshold 近似重复判定距离
+	CollapseThreshold float32
+	// Explain 是否返回搜索过程的详细信息
+	Explain bool
+}
+
+// SearchMatch 相似图片搜索结果
+type SearchMatch struct {
+	Image    model.Image
+	Distance float32
+	// Frame 动图或多页 TIFF 中与查询最相似的帧序号
+	Frame int
+	// CollapsedIDs 被折叠到该结果下的近似重复图片ID
+	CollapsedIDs []uuid.UUID
+	// Embedding 结果图片的嵌入向量，仅在 explain 模式下返回
+	Embedding []float32
+	// Contributions 每个维度对距离平方的贡献，仅在 explain 模式下返回
+	Contributions []float32
+}
+
+// SearchOutcome 一次搜索的结果
+type SearchOutcome struct {
+	Matches []SearchMatch
+	// Explain 搜索过程的详细信息，仅在 explain 模式下返回
+	Explain *SearchExplain
+}
+
+// ErrImageNotFound 图片或其嵌入向量不存在
+var ErrImageNotFound = errors.New("图片不存在")
+
+const (
+	// DedupModeReuse 重复上传时直接返回已有的图片记录
+	DedupModeReuse = "reuse"
+	// DedupModeReference 重复上传时创建新的图片记录，与已有记录共享同一文件
+	DedupModeReference = "reference"
+)
+
+const (
+	// defaultSearchLimit 默认返回的相似图片数量
+	defaultSearchLimit = 10
+	// MaxSearchLimit 单次搜索允许返回的最大数量
+	MaxSearchLimit = 100
+)
+
 // imageService 图片服务实现
 type imageService struct {
-	imageRepo repository.ImageRepository
-	imageDir  string
+	imageRepo     repository.ImageRepository
+	blobs         storage.BlobStore
+	storageConfig config.StorageConfig
+	uploadConfig  config.UploadConfig
+	searchConfig  config.SearchConfig
+	trashConfig   config.TrashConfig
+	quotaConfig   config.QuotaConfig
+	pipeline      pipeline
+	// decode 按处理流水线解码图片的选项
+	decode decodeOptions
+	// decodes 限制上传和以图搜图同时执行的解码数量
+	decodes *decodeLimiter
+	// blobMu 串行化上传登记文件与删除文件前的引用复查
+	blobMu sync.Mutex
 }
 
 // NewImageService 创建图片服务
-func NewImageService(imageRepo repository.ImageRepository, imageDir string) ImageService {
-	// 确保图片目录存在
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		logrus.Errorf("创建图片目录失败: %v", err)
-		panic(err)
-	}
-
+func NewImageService(imageRepo repository.ImageRepository, blobs storage.BlobStore, cfg *config.Config) ImageService {
 	return &imageService{
-		imageRepo: imageRepo,
-		imageDir:  imageDir,
+		imageRepo:     imageRepo,
+		blobs:         blobs,
+		storageConfig: cfg.Storage,
+		uploadConfig:  cfg.Upload,
+		searchConfig:  cfg.Search,
+		trashConfig:   cfg.Trash,
+		quotaConfig:   cfg.Quota,
+		pipeline:      newPipeline(cfg.Pipeline),
+		decode:        newPipeline(cfg.Pipeline).decodeOptions(cfg.Storage.SVGRasterSize),
+		decodes:       newDecodeLimiter(cfg.Upload.DecodeConcurrency),
 	}
 }
 
-// UploadImage 上传图片
-func (s *imageService) UploadImage(file multipart.File, fileHeader *multipart.FileHeader) (*model.Image, error) {
-	// 读取文件内容
-	buffer := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buffer, file); err != nil {
+// UploadImage 上传图片到指定命名空间，namespace 为空时使用默认命名空间
+func (s *imageService) UploadImage(file multipart.File, fileHeader *multipart.FileHeader, namespace string) (*model.Image, error) {
+	namespace, err := NormalizeNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取文件内容，超过大小限制时不再继续读取
+	content, err := readImageData(file, s.uploadConfig.MaxBytes)
+	if err != nil {
 		logrus.Errorf("读取文件内容失败: %v", err)
 		return nil, err
 	}
@@ -65,102 +148,253 @@ func (s *imageService) UploadImage(file multipart.File, fileHeader *multipart.Fi
 		return nil, err
 	}
 
-	// 解码图片
-	img, format, err := image.Decode(buffer)
+	// 解码之前按文件头识别格式并检查尺寸，拒绝解压炸弹
+	format, err := checkImage(content, s.uploadConfig)
 	if err != nil {
-		logrus.Errorf("解码图片失败: %v", err)
+		logrus.Errorf("检查图片失败: %v", err)
 		return nil, err
 	}
 
-	// 检查图片格式
-	if format != "jpeg" && format != "png" {
-		logrus.Errorf("不支持的图片格式: %s", format)
-		return nil, errors.New("只支持 JPEG 和 PNG 格式的图片")
-	}
-
-	// 生成唯一文件名
-	extension := strings.ToLower(format)
-	fileName := fmt.Sprintf("%s.%s", uuid.New().String(), extension)
-	filePath := filepath.Join(s.imageDir, fileName)
-
-	// 保存图片
-	dst, err := os.Create(filePath)
+	// 需要转换格式的图片（如 BMP）先转换，SVG 先清理，内容哈希按保存的原图计算
+	// 转换需要解码和重新编码，与其他解码一样受并发数量和超时限制
+	var (
+		data []byte
+		img  image.Image
+	)
+	err = s.decodes.run(s.uploadConfig.DecodeTimeout, func() error {
+		var err error
+		data, format, img, err = prepareOriginal(content, format)
+		return err
+	})
 	if err != nil {
-		logrus.Errorf("创建图片文件失败: %v", err)
+		logrus.Errorf("检查图片格式失败: %v", err)
 		return nil, err
 	}
-	defer dst.Close()
+	// 元数据和处理记录按处理之前的文件提取
+	source, sourceFormat := data, format
+
+	// 流水线配置了 format、quality 或 strip 时先处理原图，内容哈希按处理后的原图计算
+	// 转换格式和调整质量需要重新编码，只删除元数据时不重新编码
+	var (
+		original   *processedOriginal
+		skipReason string
+	)
+	if s.pipeline.rewritesOriginal() {
+		skipReason = skipOriginalReason(data, format)
+		err = s.decodes.run(s.uploadConfig.DecodeTimeout, func() error {
+			if img == nil {
+				decoded, _, err := decodeImage(bytes.NewReader(data), s.decode)
+				if err != nil {
+					return err
+				}
+				img = decoded
+			}
+			var err error
+			original, err = s.pipeline.processOriginal(data, format, img, skipReason)
+			return err
+		})
+		if err != nil {
+			logrus.Errorf("处理原图失败: %v", err)
+			return nil, err
+		}
+		data, format = original.data, original.format
+	}
 
-	// 调整图片大小（可选，根据实际需求调整）
-	resizedImg := resize.Resize(800, 0, img, resize.Lanczos3)
+	// 计算内容哈希，内容重复时不再保存新文件
+	sum := sha256.Sum256(data)
+	contentHash := hex.EncodeToString(sum[:])
+	existing, err := s.imageRepo.GetImageByContentHash(contentHash, "")
+	if err == nil {
+		return s.uploadDuplicate(existing, fileHeader, namespace)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		logrus.Errorf("查询重复图片失败: %v", err)
+		return nil, err
+	}
 
-	// 保存调整后的图片
-	switch extension {
-	case "jpg", "jpeg":
-		if err := jpeg.Encode(dst, resizedImg, &jpeg.Options{Quality: 90}); err != nil {
-			logrus.Errorf("保存 JPEG 图片失败: %v", err)
-			return nil, err
+	// 解码、生成衍生图片和嵌入向量都在限定时间内完成，写入对象存储之前完成所有编码和计算，失败时不会留下任何文件
+	var (
+		renditionFiles []renditionFile
+		embedding      []float32
+		processing     []model.ProcessingStep
+		frameCount     int
+		frames         []model.ImageFrame
+	)
+	err = s.decodes.run(s.uploadConfig.DecodeTimeout, func() error {
+		// 按流水线解码图片，转换过格式或重新编码过的图片已经解码
+		if img == nil {
+			decoded, _, err := decodeImage(bytes.NewReader(data), s.decode)
+			if err != nil {
+				return err
+			}
+			img = decoded
 		}
-	case "png":
-		if err := png.Encode(dst, resizedImg); err != nil {
-			logrus.Errorf("保存 PNG 图片失败: %v", err)
-			return nil, err
+
+		var err error
+		renditionFiles, err = s.generateRenditions(img, contentHash, derivativeFormat(format))
+		if err != nil {
+			return err
 		}
-	}
 
-	// 获取图片信息
-	bounds := resizedImg.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+		// 生成图片嵌入向量（这里使用简化的实现，实际应该使用预训练模型）
+		input := s.pipeline.embeddingInput(img)
+		embedding = s.generateEmbedding(input)
+		processing = s.pipeline.record(source, sourceFormat, img, input, original, skipReason)
 
-	// 获取文件大小
-	fileInfo, err := dst.Stat()
+		// 动图和多页 TIFF 的每一帧单独生成嵌入向量，搜索时可以匹配到任意一帧
+		frameCount, frames, err = s.generateFrames(data, format)
+		return err
+	})
 	if err != nil {
-		logrus.Errorf("获取文件信息失败: %v", err)
+		logrus.Errorf("处理上传图片失败: %v", err)
+		return nil, err
+	}
+
+	// 按内容哈希生成对象键，相同内容的上传共享同一文件
+	extension := format
+	key := fmt.Sprintf("%s.%s", contentHash, extension)
+	size := int64(len(data))
+
+	// 保存按流水线处理后的原图，以及按配置生成的衍生图片
+	files := []stagedFile{{key: key, data: data, contentType: storage.ContentTypeByExtension(extension)}}
+	renditions := make([]model.Rendition, len(renditionFiles))
+	totalSize := size
+	for i, file := range renditionFiles {
+		renditions[i] = file.rendition
+		totalSize += file.rendition.Size
+		files = append(files, stagedFile{
+			key:         file.rendition.FilePath,
+			data:        file.data,
+			contentType: storage.ContentTypeByExtension(file.rendition.Format),
+		})
+	}
+
+	// 写入文件之前检查配额，新内容的所有文件都需要新占用存储空间
+	if err := s.checkQuota(namespace, totalSize, totalSize); err != nil {
+		logrus.Warnf("上传图片超出配额: %v", err)
+		return nil, err
+	}
+
+	uploadID := uuid.New()
+	if err := s.storeUpload(uploadID, files); err != nil {
+		s.abortUpload(uploadID)
 		return nil, err
 	}
-	size := fileInfo.Size()
+
+	// 获取原图尺寸
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
 
 	// 创建图片记录
 	image := &model.Image{
-		FileName:  fileHeader.Filename,
-		FilePath:  filePath,
-		Extension: extension,
-		Width:     width,
-		Height:    height,
-		Size:      size,
+		FileName:    fileHeader.Filename,
+		FilePath:    key,
+		Extension:   extension,
+		Width:       width,
+		Height:      height,
+		Size:        size,
+		ContentHash: contentHash,
+		Namespace:   namespace,
+		KeyID:       s.activeKeyID(),
+		Renditions:  renditions,
+		Metadata:    extractMetadata(source, sourceFormat),
+		Processing:  processing,
+		FrameCount:  frameCount,
+		Frames:      frames,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	imageEmbedding := &model.ImageEmbedding{
+		Embedding: embedding,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// 保存图片记录到数据库
-	if err := s.imageRepo.CreateImage(image); err != nil {
-		logrus.Errorf("保存图片记录失败: %v", err)
-		// 删除已保存的图片文件
-		os.Remove(filePath)
+	// 在同一事务中保存图片记录和嵌入向量，重新检查配额，并删除上传登记记录
+	if err := s.imageRepo.CommitUpload(uploadID, image, imageEmbedding, s.quotaCheck(namespace, totalSize, totalSize)); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			logrus.Warnf("上传图片超出配额: %v", err)
+		} else {
+			logrus.Errorf("保存图片记录失败: %v", err)
+		}
+		// 删除已保存且没有被其他图片引用的原图和衍生图片
+		s.abortUpload(uploadID)
 		return nil, err
 	}
 
-	// 生成图片嵌入向量（这里使用简化的实现，实际应该使用预训练模型）
-	embedding := s.generateEmbedding(resizedImg)
+	logrus.Infof("图片上传成功: %s", image.FileName)
+	return image, nil
+}
+
+// uploadDuplicate 处理内容与已有图片重复的上传
+// reuse 模式下只复用同一命名空间中的图片记录，其他命名空间的图片只共享文件
+func (s *imageService) uploadDuplicate(existing *model.Image, fileHeader *multipart.FileHeader, namespace string) (*model.Image, error) {
+	if s.storageConfig.DedupMode != DedupModeReference {
+		same, err := s.imageRepo.GetImageByContentHash(existing.ContentHash, namespace)
+		if err == nil {
+			logrus.Infof("图片内容重复，返回已有图片: %s", same.ID)
+			same.Duplicate = true
+			return same, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.Errorf("查询重复图片失败: %v", err)
+			return nil, err
+		}
+	}
+
+	// 共享已有文件，不占用新的存储空间，但计入命名空间的用量
+	namespaceSize := existing.Size
+	for _, rendition := range existing.Renditions {
+		namespaceSize += rendition.Size
+	}
+	if err := s.checkQuota(namespace, 0, namespaceSize); err != nil {
+		logrus.Warnf("上传图片超出配额: %v", err)
+		return nil, err
+	}
+
+	// 相同内容的嵌入向量相同，直接复用
+	embedding, err := s.imageRepo.GetImageEmbeddingByImageID(existing.ID)
+	if err != nil {
+		logrus.Errorf("获取已有图片嵌入向量失败: %v", err)
+		return nil, err
+	}
 
-	// 保存嵌入向量
+	image := &model.Image{
+		FileName:    fileHeader.Filename,
+		FilePath:    existing.FilePath,
+		Extension:   existing.Extension,
+		Width:       existing.Width,
+		Height:      existing.Height,
+		Size:        existing.Size,
+		ContentHash: existing.ContentHash,
+		Namespace:   namespace,
+		KeyID:       existing.KeyID,
+		Renditions:  copyRenditions(existing.Renditions),
+		Metadata:    copyMetadata(existing.Metadata),
+		Processing:  copyProcessing(existing.Processing),
+		FrameCount:  existing.FrameCount,
+		Frames:      copyFrames(existing.Frames),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
 	imageEmbedding := &model.ImageEmbedding{
-		ImageID:   image.ID,
-		Embedding: embedding,
+		Embedding: embedding.Embedding,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-
-	if err := s.imageRepo.CreateImageEmbedding(imageEmbedding); err != nil {
-		logrus.Errorf("保存图片嵌入向量失败: %v", err)
-		// 删除已保存的图片文件和记录
-		os.Remove(filePath)
-		s.imageRepo.DeleteImage(image.ID)
+	// 文件已被已有图片引用，只需在同一事务中创建图片记录和嵌入向量并重新检查配额
+	if err := s.imageRepo.CreateImage(image, imageEmbedding, s.quotaCheck(namespace, 0, namespaceSize)); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			logrus.Warnf("上传图片超出配额: %v", err)
+			return nil, err
+		}
+		logrus.Errorf("保存图片记录失败: %v", err)
 		return nil, err
 	}
 
-	logrus.Infof("图片上传成功: %s", image.FileName)
+	logrus.Infof("图片内容重复，已创建共享文件的新记录: %s", image.ID)
+	image.Duplicate = true
 	return image, nil
 }
 
@@ -169,8 +403,8 @@ func (s *imageService) GetImage(id uuid.UUID) (*model.Image, error) {
 	return s.imageRepo.GetImageByID(id)
 }
 
-// ListImages 列出图片
-func (s *imageService) ListImages(page, pageSize int) ([]model.Image, int64, error) {
+// ListImages 按过滤条件列出图片
+func (s *imageService) ListImages(page, pageSize int, filter repository.ListFilter) ([]model.Image, int64, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -179,7 +413,7 @@ func (s *imageService) ListImages(page, pageSize int) ([]model.Image, int64, err
 	}
 
 	// 调用仓库层方法，获取指针切片
-	imagePtrs, total, err := s.imageRepo.ListImages(page, pageSize)
+	imagePtrs, total, err := s.imageRepo.ListImages(page, pageSize, filter)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -193,101 +427,240 @@ func (s *imageService) ListImages(page, pageSize int) ([]model.Image, int64, err
 	return images, total, nil
 }
 
-// DeleteImage 删除图片
+// DeleteImage 删除图片，配置了回收站保留期时移入回收站，否则立即永久删除
 func (s *imageService) DeleteImage(id uuid.UUID) error {
 	// 获取图片信息
 	image, err := s.imageRepo.GetImageByID(id)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrImageNotFound
+		}
 		return err
 	}
 
-	// 删除图片文件
-	if err := os.Remove(image.FilePath); err != nil {
-		logrus.Errorf("删除图片文件失败: %v", err)
+	if s.trashConfig.Retention <= 0 {
+		return s.purgeImage(image)
+	}
+
+	if err := s.imageRepo.TrashImage(id); err != nil {
+		logrus.Errorf("将图片移入回收站失败: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrImageNotFound
+		}
 		return err
 	}
 
-	// 删除图片记录和嵌入向量
-	if err := s.imageRepo.DeleteImage(id); err != nil {
+	logrus.Infof("图片已移入回收站: %s", image.FileName)
+	return nil
+}
+
+// purgeImage 永久删除图片记录、衍生图片记录和嵌入向量，以及不再被任何图片引用的文件
+func (s *imageService) purgeImage(image *model.Image) error {
+	orphaned, err := s.imageRepo.PurgeImage(image.ID)
+	if err != nil {
 		logrus.Errorf("删除图片记录失败: %v", err)
 		return err
 	}
 
-	logrus.Infof("图片删除成功: %s", image.FileName)
+	// 删除不再被任何图片引用的文件
+	if _, err := s.deleteUnreferenced(orphaned); err != nil {
+		logrus.Errorf("删除图片文件失败: %v", err)
+		return err
+	}
+
+	logrus.Infof("图片已永久删除: %s", image.FileName)
 	return nil
 }
 
 // SearchImagesByImage 根据图片搜索相似图片
-func (s *imageService) SearchImagesByImage(file multipart.File) ([]model.Image, []float32, error) {
-	// 读取文件内容
-	buffer := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buffer, file); err != nil {
+func (s *imageService) SearchImagesByImage(file multipart.File, opts SearchOptions) (*SearchOutcome, error) {
+	start := time.Now()
+	var timings ExplainTimings
+
+	// 读取文件内容，查询图片与上传的图片使用相同的限制
+	data, err := readImageData(file, s.uploadConfig.MaxBytes)
+	if err != nil {
 		logrus.Errorf("读取文件内容失败: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
 
 	// 重置文件指针
 	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		logrus.Errorf("重置文件指针失败: %v", err)
-		return nil, nil, err
+		return nil, err
+	}
+
+	// 解码之前按文件头识别格式并检查尺寸
+	if _, err := checkImage(data, s.uploadConfig); err != nil {
+		logrus.Errorf("检查图片失败: %v", err)
+		return nil, err
 	}
 
 	// 解码图片
-	img, _, err := image.Decode(buffer)
+	phaseStart := time.Now()
+	var img image.Image
+	err = s.decodes.run(s.uploadConfig.DecodeTimeout, func() error {
+		decoded, _, err := decodeImage(bytes.NewReader(data), s.decode)
+		img = decoded
+		return err
+	})
 	if err != nil {
 		logrus.Errorf("解码图片失败: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
+	timings.Decode = milliseconds(time.Since(phaseStart))
 
 	// 调整图片大小
-	resizedImg := resize.Resize(800, 0, img, resize.Lanczos3)
+	phaseStart = time.Now()
+	resizedImg := s.pipeline.embeddingInput(img)
+	timings.Resize = milliseconds(time.Since(phaseStart))
 
 	// 生成嵌入向量
+	phaseStart = time.Now()
 	embedding := s.generateEmbedding(resizedImg)
+	timings.Embed = milliseconds(time.Since(phaseStart))
 
-	// 搜索相似图片
-	imagePtrs, distances, err := s.imageRepo.SearchSimilarImages(embedding, 10)
+	return s.searchByEmbedding(embedding, opts, timings, start)
+}
+
+// SearchSimilarByImageID 根据已入库图片的ID搜索相似图片，结果中不包含该图片本身
+func (s *imageService) SearchSimilarByImageID(id uuid.UUID, opts SearchOptions) (*SearchOutcome, error) {
+	start := time.Now()
+	var timings ExplainTimings
+
+	// 回收站中的图片不能作为查询
+	if _, err := s.imageRepo.GetImageByID(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+
+	// 复用已保存的嵌入向量，无需重新解码图片
+	embedding, err := s.imageRepo.GetImageEmbeddingByImageID(id)
+	if err != nil {
+		logrus.Errorf("获取图片嵌入向量失败: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	timings.LoadEmbedding = milliseconds(time.Since(start))
+
+	opts.ExcludeIDs = append(opts.ExcludeIDs, id)
+	return s.searchByEmbedding(embedding.Embedding, opts, timings, start)
+}
+
+// searchByEmbedding 根据嵌入向量搜索相似图片
+// timings 为调用方已统计的前置阶段耗时，start 为整个搜索的开始时间
+func (s *imageService) searchByEmbedding(embedding []float32, opts SearchOptions, timings ExplainTimings, start time.Time) (*SearchOutcome, error) {
+	if opts.Limit < 1 || opts.Limit > MaxSearchLimit {
+		opts.Limit = defaultSearchLimit
+	}
+	if opts.Lambda <= 0 || opts.Lambda > 1 {
+		opts.Lambda = defaultMMRLambda
+	}
+	if opts.CollapseThreshold <= 0 {
+		opts.CollapseThreshold = defaultCollapseThreshold
+	}
+
+	// 搜索相似图片，需要重排时多取一些候选
+	repoOpts := opts.SearchOptions
+	repoOpts.Limit = candidateLimit(opts)
+	hits, stats, err := s.imageRepo.SearchSimilarImages(embedding, repoOpts)
 	if err != nil {
 		logrus.Errorf("搜索相似图片失败: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
+	candidates := len(hits)
 
-	// 转换为值切片
-	images := make([]model.Image, len(imagePtrs))
-	for i, imgPtr := range imagePtrs {
-		images[i] = *imgPtr
+	rerankStart := time.Now()
+
+	// 折叠近似重复的结果
+	var collapsed map[uuid.UUID][]uuid.UUID
+	if opts.Collapse {
+		hits, collapsed = collapseDuplicates(hits, opts.CollapseThreshold)
+	}
+
+	// 多样化重排
+	if opts.Diversify {
+		hits = rerankMMR(hits, opts.Lambda, opts.Limit)
+	}
+
+	if len(hits) > opts.Limit {
+		hits = hits[:opts.Limit]
 	}
+	rerankDuration := time.Since(rerankStart)
 
-	logrus.Infof("搜索到 %d 张相似图片", len(images))
-	return images, distances, nil
+	// 转换为搜索结果
+	outcome := &SearchOutcome{
+		Matches: make([]SearchMatch, len(hits)),
+	}
+	for i, hit := range hits {
+		outcome.Matches[i] = SearchMatch{
+			Image:        *hit.Image,
+			Distance:     hit.Distance,
+			Frame:        hit.Frame,
+			CollapsedIDs: collapsed[hit.Image.ID],
+		}
+		if opts.Explain {
+			outcome.Matches[i].Embedding = hit.Embedding
+			outcome.Matches[i].Contributions = distanceContributions(embedding, hit.Embedding)
+		}
+	}
+
+	if opts.Explain {
+		explain := newSearchExplain(embedding, stats, opts)
+		explain.Timings.Decode = timings.Decode
+		explain.Timings.Resize = timings.Resize
+		explain.Timings.Embed = timings.Embed
+		explain.Timings.LoadEmbedding = timings.LoadEmbedding
+		explain.Timings.Rerank = milliseconds(rerankDuration)
+		explain.Timings.Total = milliseconds(time.Since(start))
+		explain.Candidates.Reranked = candidates
+		explain.Candidates.Returned = len(outcome.Matches)
+		outcome.Explain = explain
+	}
+
+	logrus.Infof("搜索到 %d 张相似图片", len(outcome.Matches))
+	return outcome, nil
 }
 
-// generateEmbedding 生成图片嵌入向量（简化实现）
+// generateEmbedding 生成图片嵌入向量（简化实现），透明像素按不透明度加权或合成到配置的背景色上
 // 注意：这里使用的是非常简化的实现，实际生产环境中应该使用预训练的深度学习模型
 func (s *imageService) generateEmbedding(img image.Image) []float32 {
 	// 这里使用一个简单的实现，实际应该使用预训练模型
 	// 例如：使用 Go 绑定的 TensorFlow 或 PyTorch 模型
 	
+	// 透明图片（如抠图后的商品图）配置了背景色时先合成到背景色上
+	if s.searchConfig.EmbeddingBackground != nil {
+		img = flattenImage(img, s.searchConfig.EmbeddingBackground)
+	}
+
 	// 简化实现：计算图片的平均颜色作为嵌入向量
+	// RGBA 返回预乘 alpha 的颜色，除以不透明度之和即为按不透明度加权的平均颜色，
+	// 完全透明的像素不参与计算，不透明图片的结果与直接求平均相同
 	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
 
-	var r, g, b float32
-	count := width * height
+	var r, g, b, weight float32
 
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r1, g1, b1, a1 := img.At(x, y).RGBA()
 			r += float32(r1) / 65535.0
 			g += float32(g1) / 65535.0
 			b += float32(b1) / 65535.0
+			weight += float32(a1) / 65535.0
 		}
 	}
 
-	r /= float32(count)
-	g /= float32(count)
-	b /= float32(count)
+	// 完全透明的图片没有可用的颜色信息
+	if weight == 0 {
+		return []float32{0, 0, 0}
+	}
+	r /= weight
+	g /= weight
+	b /= weight
 
 	// 返回一个简单的 3 维嵌入向量
 	// 实际应用中，嵌入向量的维度应该更高（例如 512 维或 1024 维）
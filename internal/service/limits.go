@@ -0,0 +1,308 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/tiff"
+)
+
+var (
+	// ErrImageTooLarge 图片文件、尺寸或解码耗时超过限制
+	ErrImageTooLarge = errors.New("图片过大")
+	// ErrCorruptImage 文件格式可以识别，但内容损坏无法解码
+	ErrCorruptImage = errors.New("图片已损坏，无法解码")
+	// ErrDecodeTimeout 解码图片超过限制的时间，通常是构造的解压炸弹
+	ErrDecodeTimeout = fmt.Errorf("%w: 解码超时", ErrImageTooLarge)
+	// ErrDecodeBusy 解码名额都被占用，在限定时间内没有等到空闲的名额
+	ErrDecodeBusy = errors.New("解码任务过多，请稍后重试")
+)
+
+// imageMagic 各格式文件开头的特征字节，? 匹配任意一个字节
+var imageMagic = []struct {
+	format string
+	magic  string
+}{
+	{"jpeg", "\xff\xd8\xff"},
+	{"png", "\x89PNG\r\n\x1a\n"},
+	{"gif", "GIF87a"},
+	{"gif", "GIF89a"},
+	{"webp", "RIFF????WEBP"},
+	{"bmp", "BM"},
+	{"tiff", "II*\x00"},
+	{"tiff", "MM\x00*"},
+}
+
+// readImageData 读取图片文件内容，超过 maxBytes 时返回 ErrImageTooLarge，maxBytes 不大于 0 时不限制
+func readImageData(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: 文件超过 %d 字节", ErrImageTooLarge, maxBytes)
+	}
+	return data, nil
+}
+
+// sniffFormat 根据文件开头的特征字节识别图片格式，无法识别时返回空字符串
+func sniffFormat(data []byte) string {
+	for _, m := range imageMagic {
+		if matchMagic(data, m.magic) {
+			return m.format
+		}
+	}
+	if isSVG(data) {
+		return "svg"
+	}
+	return ""
+}
+
+// matchMagic 判断 data 是否以 magic 开头，magic 中的 ? 匹配任意一个字节
+func matchMagic(data []byte, magic string) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkImage 在解码之前识别图片格式，并按文件头中的尺寸检查是否超过限制，返回识别出的格式
+// 无法识别的格式返回 ErrUnsupportedFormat，文件头损坏返回 ErrCorruptImage，超过限制返回 ErrImageTooLarge
+func checkImage(data []byte, limits config.UploadConfig) (string, error) {
+	format := sniffFormat(data)
+	if format == "" {
+		return "", ErrUnsupportedFormat
+	}
+	// SVG 栅格化的尺寸由配置决定，文件大小已经检查过
+	if format == "svg" {
+		return format, nil
+	}
+
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	if decoded != format {
+		return "", fmt.Errorf("%w: 文件头为 %s 格式，内容为 %s 格式", ErrCorruptImage, format, decoded)
+	}
+	if err := checkDimensions(cfg.Width, cfg.Height, 1, limits); err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "gif":
+		// 动图解码时所有帧都保存在内存中，按所有帧的像素数之和检查
+		frames, err := gifFrameCount(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrCorruptImage, err)
+		}
+		if err := checkDimensions(cfg.Width, cfg.Height, frames, limits); err != nil {
+			return "", err
+		}
+	case "tiff":
+		// 多页 TIFF 逐页解码，每一页都需要检查
+		if err := checkTIFFPages(data, limits); err != nil {
+			return "", err
+		}
+	}
+	return format, nil
+}
+
+// checkDimensions 检查 frames 帧宽高为 width x height 的图片是否超过限制
+func checkDimensions(width, height, frames int, limits config.UploadConfig) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%w: 无效的尺寸 %dx%d", ErrCorruptImage, width, height)
+	}
+	if limits.MaxDimension > 0 && (width > limits.MaxDimension || height > limits.MaxDimension) {
+		return fmt.Errorf("%w: 尺寸 %dx%d 超过 %d 像素", ErrImageTooLarge, width, height, limits.MaxDimension)
+	}
+	if limits.MaxPixels > 0 {
+		pixels := int64(width) * int64(height) * int64(frames)
+		if pixels > limits.MaxPixels {
+			if frames > 1 {
+				return fmt.Errorf("%w: %d 帧共 %d 像素，超过 %d 像素", ErrImageTooLarge, frames, pixels, limits.MaxPixels)
+			}
+			return fmt.Errorf("%w: %d 像素超过 %d 像素", ErrImageTooLarge, pixels, limits.MaxPixels)
+		}
+	}
+	return nil
+}
+
+// checkTIFFPages 按文件头检查多页 TIFF 每一页的尺寸
+func checkTIFFPages(data []byte, limits config.UploadConfig) error {
+	order, pages, err := tiffPages(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	for i, page := range pages[1:] {
+		reader := &tiffPageReader{data: data}
+		copy(reader.header[:], data[:8])
+		order.PutUint32(reader.header[4:], page.offset)
+
+		cfg, err := tiff.DecodeConfig(io.NewSectionReader(reader, 0, int64(len(data))))
+		if err != nil {
+			// 无法解析的页在解码时跳过
+			continue
+		}
+		if err := checkDimensions(cfg.Width, cfg.Height, 1, limits); err != nil {
+			return fmt.Errorf("第 %d 页: %w", i+2, err)
+		}
+	}
+	return nil
+}
+
+// gifFrameCount 遍历 GIF 的数据块统计帧数，不解码图像数据
+func gifFrameCount(data []byte) (int, error) {
+	const (
+		extensionIntroducer = 0x21
+		imageSeparator      = 0x2c
+		trailer             = 0x3b
+		colorTableFlag      = 0x80
+	)
+	if len(data) < 13 {
+		return 0, errors.New("GIF 文件头不完整")
+	}
+	pos := 13
+	if flags := data[10]; flags&colorTableFlag != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case extensionIntroducer:
+			if pos+2 > len(data) {
+				return 0, errors.New("GIF 扩展块不完整")
+			}
+			end, err := skipGIFSubBlocks(data, pos+2)
+			if err != nil {
+				return 0, err
+			}
+			pos = end
+		case imageSeparator:
+			if pos+10 > len(data) {
+				return 0, errors.New("GIF 图像描述符不完整")
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&colorTableFlag != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			// 跳过 LZW 最小码长
+			end, err := skipGIFSubBlocks(data, pos+1)
+			if err != nil {
+				return 0, err
+			}
+			pos = end
+			frames++
+		case trailer:
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("GIF 中存在未知的数据块 0x%02x", data[pos])
+		}
+	}
+	// 缺少结束标记的文件解码器同样可以解码
+	return frames, nil
+}
+
+// skipGIFSubBlocks 跳过从 pos 开始的数据子块序列，返回结束后的位置
+func skipGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, errors.New("GIF 数据块不完整")
+		}
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos, nil
+		}
+		pos += size
+	}
+}
+
+// decodeLimiter 限制同时执行的解码任务数量
+// 超时只限制请求的等待时间：Go 无法中止正在执行的解码，超时后任务仍在后台执行到结束，并一直占用名额，
+// 因此持续提交解压炸弹时，后台解码最多占用 concurrency 个名额，新的解码等待名额或返回 ErrDecodeBusy，而不会无限累积
+type decodeLimiter struct {
+	// slots 为 nil 时不限制并发数量
+	slots chan struct{}
+	// abandoned 已经超时但仍在后台执行的解码任务数量
+	abandoned atomic.Int64
+}
+
+// newDecodeLimiter 创建最多同时执行 concurrency 个解码任务的 decodeLimiter，concurrency 不大于 0 时不限制
+func newDecodeLimiter(concurrency int) *decodeLimiter {
+	l := &decodeLimiter{}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// run 在限定时间内执行 fn，timeout 不大于 0 时不限制，等待名额的时间同样计入 timeout
+// 等待名额超时返回 ErrDecodeBusy，执行超时返回 ErrDecodeTimeout；解码器中的 panic 按图片损坏处理
+// 超时后 fn 仍会在后台执行到结束，结束之前不会释放名额，调用方不能再使用它写入的结果
+func (l *decodeLimiter) run(timeout time.Duration, fn func() error) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-deadline:
+			return fmt.Errorf("%w: %s 内没有空闲的解码名额，超时仍在执行的解码 %d 个", ErrDecodeBusy, timeout, l.abandoned.Load())
+		}
+	}
+
+	done := make(chan error, 1)
+	// timedOut 由等待结果的一方在超时时设置，任务结束时据此减少 abandoned
+	var timedOut atomic.Bool
+	go func() {
+		defer func() {
+			if !timedOut.CompareAndSwap(false, true) {
+				l.abandoned.Add(-1)
+			}
+			if l.slots != nil {
+				<-l.slots
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("%w: %v", ErrCorruptImage, r)
+			}
+		}()
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-deadline:
+		// 任务恰好在超时的同时结束时按正常完成处理
+		if !timedOut.CompareAndSwap(false, true) {
+			return <-done
+		}
+		abandoned := l.abandoned.Add(1)
+		logrus.Warnf("解码超过 %s，任务仍在后台执行，超时仍在执行的解码 %d 个", timeout, abandoned)
+		return fmt.Errorf("%w: 超过 %s", ErrDecodeTimeout, timeout)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecodeLimiterTimeoutKeepsSlot(t *testing.T) {
+	limiter := newDecodeLimiter(1)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	// 超时后任务仍在后台执行，继续占用名额
+	err := limiter.run(20*time.Millisecond, func() error {
+		<-release
+		close(finished)
+		return nil
+	})
+	if !errors.Is(err, ErrDecodeTimeout) {
+		t.Fatalf("run 错误 = %v, want ErrDecodeTimeout", err)
+	}
+	if n := limiter.abandoned.Load(); n != 1 {
+		t.Errorf("abandoned = %d, want 1", n)
+	}
+
+	// 名额被超时的任务占用，新的任务等不到名额
+	ran := false
+	err = limiter.run(20*time.Millisecond, func() error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, ErrDecodeBusy) || ran {
+		t.Fatalf("run 错误 = %v, ran = %v, want ErrDecodeBusy", err, ran)
+	}
+
+	// 后台任务结束后释放名额
+	close(release)
+	<-finished
+	if err := limiter.run(time.Second, func() error { return nil }); err != nil {
+		t.Fatalf("释放名额后 run: %v", err)
+	}
+	if n := limiter.abandoned.Load(); n != 0 {
+		t.Errorf("abandoned = %d, want 0", n)
+	}
+}
+
+func TestDecodeLimiterPanic(t *testing.T) {
+	limiter := newDecodeLimiter(1)
+	err := limiter.run(time.Second, func() error {
+		panic("broken decoder")
+	})
+	if !errors.Is(err, ErrCorruptImage) {
+		t.Fatalf("run 错误 = %v, want ErrCorruptImage", err)
+	}
+	// panic 之后同样释放名额
+	if err := limiter.run(time.Second, func() error { return nil }); err != nil {
+		t.Fatalf("panic 之后 run: %v", err)
+	}
+}
+
+func TestDecodeLimiterUnlimited(t *testing.T) {
+	limiter := newDecodeLimiter(0)
+	want := errors.New("decode failed")
+	if err := limiter.run(0, func() error { return want }); !errors.Is(err, want) {
+		t.Errorf("run 错误 = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// PeriodicTask 按固定间隔在后台执行的任务
+type PeriodicTask struct {
+	interval time.Duration
+	run      func()
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPeriodicTask 创建后台任务，interval 必须大于 0
+func NewPeriodicTask(interval time.Duration, run func()) *PeriodicTask {
+	return &PeriodicTask{
+		interval: interval,
+		run:      run,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 启动任务，启动时立即执行一次，之后按间隔执行
+func (t *PeriodicTask) Start() {
+	go func() {
+		defer close(t.done)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			t.run()
+			select {
+			case <-ticker.C:
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止任务并等待正在执行的一次完成，只能在 Start 之后调用
+func (t *PeriodicTask) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+	<-t.done
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strconv"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/nfnt/resize"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultReencodeQuality 流水线没有配置 quality 时重新编码 JPEG 原图使用的质量
+const defaultReencodeQuality = 90
+
+// decodeOptions 解码图片的选项
+type decodeOptions struct {
+	// svgSize SVG 栅格化后的最长边
+	svgSize int
+	// orient 是否按 EXIF 方向旋转
+	orient bool
+	// srgb 是否按嵌入的 ICC 配置文件转换为 sRGB
+	srgb bool
+}
+
+// pipeline 按配置顺序执行的图片处理流水线
+// orient 和 srgb 作用于所有解码，resize 作用于生成嵌入向量的图片，format、quality 和 strip 作用于上传时保存的原图
+type pipeline struct {
+	steps []config.PipelineStep
+}
+
+// newPipeline 创建处理流水线
+func newPipeline(cfg config.PipelineConfig) pipeline {
+	return pipeline{steps: cfg.Steps}
+}
+
+// step 返回指定名称的步骤，没有配置时返回 false
+func (p pipeline) step(name string) (config.PipelineStep, bool) {
+	for _, step := range p.steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return config.PipelineStep{}, false
+}
+
+// has 判断是否配置了指定名称的步骤
+func (p pipeline) has(name string) bool {
+	_, ok := p.step(name)
+	return ok
+}
+
+// decodeOptions 返回按流水线解码图片的选项
+func (p pipeline) decodeOptions(svgSize int) decodeOptions {
+	return decodeOptions{
+		svgSize: svgSize,
+		orient:  p.has("orient"),
+		srgb:    p.has("srgb"),
+	}
+}
+
+// embeddingInput 返回用于生成嵌入向量的图片，配置了 resize 时宽度超过限制的图片等比缩小，不放大小图
+// 上传和搜索必须使用相同的处理，保证嵌入向量可比
+func (p pipeline) embeddingInput(img image.Image) image.Image {
+	step, ok := p.step("resize")
+	if !ok || img.Bounds().Dx() <= step.Width {
+		return img
+	}
+	return resize.Resize(uint(step.Width), 0, img, resize.Lanczos3)
+}
+
+// rewritesOriginal 判断流水线是否可能修改上传的原图
+func (p pipeline) rewritesOriginal() bool {
+	return p.has("format") || p.has("quality") || p.has("strip")
+}
+
+// processedOriginal 按流水线处理后的原图
+type processedOriginal struct {
+	data   []byte
+	format string
+	// details format、quality 和 strip 步骤的处理结果
+	details map[string]model.ProcessingStep
+}
+
+// processOriginal 按 format、quality 和 strip 步骤处理要保存的原图，img 为按流水线解码后的图片
+// 需要转换格式或调整 JPEG 质量时从 img 重新编码，重新编码的图片不包含任何元数据；
+// 只配置了 strip 时不重新编码，按文件结构删除元数据，像素数据保持不变
+// skipReason 不为空时原图不能重新编码，只执行 strip
+func (p pipeline) processOriginal(data []byte, format string, img image.Image, skipReason string) (*processedOriginal, error) {
+	result := &processedOriginal{data: data, format: format, details: make(map[string]model.ProcessingStep)}
+	reencoded := false
+	if skipReason == "" {
+		var err error
+		if reencoded, err = p.reencodeOriginal(result, img); err != nil {
+			return nil, err
+		}
+	}
+	if !p.has("strip") {
+		return result, nil
+	}
+
+	step := model.ProcessingStep{Step: "strip", Applied: true}
+	if !reencoded {
+		// 像素数据没有变化，保留显示需要的 EXIF 方向和 ICC 配置文件，由 orient 和 srgb 在解码时处理
+		stripped, err := StripMetadata(result.data, config.MetadataPolicyOrientation)
+		switch {
+		case err == nil:
+			result.data = stripped
+			step.Applied = !bytes.Equal(stripped, data)
+		case skipReason != "":
+			return nil, fmt.Errorf("删除原图元数据失败: %w", err)
+		default:
+			// 文件结构无法解析但可以解码的图片重新编码，重新编码的图片不包含元数据
+			logrus.Warnf("删除原图元数据失败，改为重新编码: %v", err)
+			if err := result.encode(img, format, defaultReencodeQuality); err != nil {
+				return nil, err
+			}
+			reencoded = true
+		}
+	}
+	step.Detail = fmt.Sprintf("%d → %d 字节", len(data), len(result.data))
+	if reencoded {
+		step.Detail = "重新编码时已删除，" + step.Detail
+	}
+	result.details["strip"] = step
+	return result, nil
+}
+
+// reencodeOriginal 按 format 和 quality 步骤重新编码原图，并记录两个步骤的处理结果，返回是否重新编码
+func (p pipeline) reencodeOriginal(result *processedOriginal, img image.Image) (bool, error) {
+	format := result.format
+	target := format
+	formatStep, hasFormat := p.step("format")
+	qualityStep, hasQuality := p.step("quality")
+	transparent := false
+	if hasFormat {
+		target = formatStep.Format
+		// JPEG 不支持透明度，透明图片与衍生图片一样改为 PNG
+		if target == "jpeg" && hasAlpha(img) {
+			target = "png"
+			transparent = true
+		}
+	}
+
+	reencode := target != format || (hasQuality && target == "jpeg")
+	if hasFormat {
+		step := model.ProcessingStep{Step: formatStep.String(), Applied: target != format}
+		switch {
+		case transparent && target == format:
+			step.Detail = "图片包含透明像素，保持 " + format + " 格式"
+		case transparent:
+			step.Detail = fmt.Sprintf("%s → %s（图片包含透明像素）", format, target)
+		case target != format:
+			step.Detail = fmt.Sprintf("%s → %s", format, target)
+		default:
+			step.Detail = "原图已是 " + format + " 格式"
+		}
+		result.details["format"] = step
+	}
+	if hasQuality {
+		step := model.ProcessingStep{Step: qualityStep.String(), Applied: target == "jpeg"}
+		if step.Applied {
+			step.Detail = "质量 " + strconv.Itoa(qualityStep.Quality)
+		} else {
+			step.Detail = "原图保存为 " + target + " 格式，不使用质量参数"
+		}
+		result.details["quality"] = step
+	}
+	if !reencode {
+		return false, nil
+	}
+
+	quality := defaultReencodeQuality
+	if hasQuality {
+		quality = qualityStep.Quality
+	}
+	if err := result.encode(img, target, quality); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// encode 将 img 重新编码为 target 格式的原图
+func (o *processedOriginal) encode(img image.Image, target string, quality int) error {
+	encoded := bytes.NewBuffer(nil)
+	if err := encodeImage(encoded, img, target, quality); err != nil {
+		return fmt.Errorf("重新编码原图失败: %w", err)
+	}
+	o.data = encoded.Bytes()
+	o.format = target
+	return nil
+}
+
+// skipOriginalReason 返回原图不能重新编码的原因，可以重新编码时返回空字符串
+// SVG 是矢量图，动图和多页 TIFF 重新编码会丢失其他帧，都不转换格式和调整质量，只按 strip 删除元数据
+func skipOriginalReason(data []byte, format string) string {
+	switch format {
+	case "svg":
+		return "SVG 原图不重新编码"
+	case "gif":
+		if frames, err := gifFrameCount(data); err == nil && frames > 1 {
+			return "动图不重新编码"
+		}
+	case "tiff":
+		if _, pages, err := tiffPages(data); err == nil && len(pages) > 1 {
+			return "多页 TIFF 不重新编码"
+		}
+	}
+	return ""
+}
+
+// record 生成图片的处理记录，按配置顺序列出每一步及其是否生效
+// source 和 sourceFormat 为上传的原始文件，original 为原图的处理结果，没有处理时为 nil
+func (p pipeline) record(source []byte, sourceFormat string, img, embeddingImg image.Image, original *processedOriginal, skipReason string) []model.ProcessingStep {
+	if len(p.steps) == 0 {
+		return nil
+	}
+	records := make([]model.ProcessingStep, 0, len(p.steps))
+	for _, step := range p.steps {
+		record := model.ProcessingStep{Step: step.String()}
+		switch step.Name {
+		case "orient":
+			orientation := exifOrientation(source, sourceFormat)
+			record.Applied = orientation != 1
+			record.Detail = "方向 " + strconv.Itoa(orientation)
+		case "srgb":
+			profile := readICCProfile(source, sourceFormat)
+			if profile == nil {
+				record.Detail = "没有 ICC 配置文件"
+			} else {
+				record.Applied = profile.convertible && !profile.isSRGB()
+				record.Detail = profile.name
+			}
+		case "resize":
+			from, to := img.Bounds(), embeddingImg.Bounds()
+			record.Applied = from.Dx() != to.Dx()
+			record.Detail = fmt.Sprintf("%dx%d → %dx%d", from.Dx(), from.Dy(), to.Dx(), to.Dy())
+		default:
+			var (
+				detail model.ProcessingStep
+				ok     bool
+			)
+			if original != nil {
+				detail, ok = original.details[step.Name]
+			}
+			switch {
+			case ok:
+				record = detail
+			case skipReason != "":
+				record.Detail = skipReason
+			case original != nil:
+				record.Detail = "原图不需要重新编码"
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// copyProcessing 复制处理记录，用于与已有图片共享文件的新记录
+func copyProcessing(steps []model.ProcessingStep) []model.ProcessingStep {
+	if len(steps) == 0 {
+		return nil
+	}
+	return append([]model.ProcessingStep(nil), steps...)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+)
+
+// testPipeline 按配置字符串创建处理流水线
+func testPipeline(t *testing.T, value string) pipeline {
+	t.Helper()
+	steps, err := config.ParsePipeline(value)
+	if err != nil {
+		t.Fatalf("ParsePipeline(%q): %v", value, err)
+	}
+	return newPipeline(config.PipelineConfig{Steps: steps})
+}
+
+// pngWithText 生成带有 tEXt 块的 PNG，返回文件内容和解码后的图片
+func pngWithText(t *testing.T) ([]byte, image.Image) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR 块之后插入 tEXt 块
+	var text bytes.Buffer
+	writePNGChunk(&text, "tEXt", []byte("Comment\x00secret location"))
+	const ihdrEnd = 8 + 12 + 13
+	out := append(append(append([]byte(nil), data[:ihdrEnd]...), text.Bytes()...), data[ihdrEnd:]...)
+	return out, img
+}
+
+func TestProcessOriginalStripWithoutReencode(t *testing.T) {
+	data, img := pngWithText(t)
+	original, err := testPipeline(t, "orient,strip").processOriginal(data, "png", img, "")
+	if err != nil {
+		t.Fatalf("processOriginal: %v", err)
+	}
+	if original.format != "png" || bytes.Contains(original.data, []byte("secret location")) {
+		t.Fatalf("strip 之后 format = %s, 仍包含文本块 = %v", original.format, bytes.Contains(original.data, []byte("secret location")))
+	}
+	// 不重新编码，图像数据块原样保留
+	for _, chunk := range mustPNGChunks(t, data) {
+		if chunk.typ == "IDAT" && !bytes.Contains(original.data, chunk.raw) {
+			t.Error("IDAT 块发生了变化")
+		}
+	}
+	if step := original.details["strip"]; !step.Applied {
+		t.Errorf("strip 记录 = %+v", step)
+	}
+}
+
+func TestProcessOriginalReencode(t *testing.T) {
+	data, img := pngWithText(t)
+	tests := []struct {
+		name      string
+		steps     string
+		format    string
+		reencoded bool
+	}{
+		{"转换格式", "format:jpeg,strip", "jpeg", true},
+		{"格式相同", "format:png,strip", "png", false},
+		{"PNG 不使用质量", "quality:80,strip", "png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, err := testPipeline(t, tt.steps).processOriginal(data, "png", img, "")
+			if err != nil {
+				t.Fatalf("processOriginal: %v", err)
+			}
+			if original.format != tt.format {
+				t.Errorf("format = %s, want %s", original.format, tt.format)
+			}
+			if bytes.Contains(original.data, []byte("secret location")) {
+				t.Error("处理后的原图仍包含文本块")
+			}
+			decoded, format, err := image.Decode(bytes.NewReader(original.data))
+			if err != nil || format != tt.format {
+				t.Fatalf("解码处理后的原图: %s, %v", format, err)
+			}
+			if !tt.reencoded {
+				if c := color.RGBAModel.Convert(decoded.At(3, 5)); c != img.At(3, 5) {
+					t.Errorf("像素 = %v, want %v", c, img.At(3, 5))
+				}
+			}
+		})
+	}
+}
+
+func TestProcessOriginalSkipped(t *testing.T) {
+	data, img := pngWithText(t)
+	original, err := testPipeline(t, "format:jpeg,strip").processOriginal(data, "png", img, "动图不重新编码")
+	if err != nil {
+		t.Fatalf("processOriginal: %v", err)
+	}
+	// 不能重新编码的原图不转换格式，但仍删除元数据
+	if original.format != "png" || bytes.Contains(original.data, []byte("secret location")) {
+		t.Errorf("format = %s, 仍包含文本块 = %v", original.format, bytes.Contains(original.data, []byte("secret location")))
+	}
+	if _, ok := original.details["format"]; ok {
+		t.Error("跳过重新编码时不应记录 format 的处理结果")
+	}
+}
+
+// mustPNGChunks 解析 PNG 中的块
+func mustPNGChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	chunks, err := pngChunks(data)
+	if err != nil {
+		t.Fatalf("pngChunks: %v", err)
+	}
+	return chunks
+}
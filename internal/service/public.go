@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// PublicImage 返回可以公开访问的原图文件，按 PRIVACY_METADATA_POLICY 删除元数据，结果按对象键和策略缓存在磁盘上
+// 每次访问都会确认原图仍然存在，已删除的图片不会从缓存中返回
+// 无法按文件结构删除元数据时重新编码，与上传的解码一样受并发数量和超时限制
+func (s *renderService) PublicImage(key string) (*RenderedImage, error) {
+	info, err := s.blobs.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 对象被重新写入时大小或修改时间会变化，不会返回旧的结果
+	sum := sha256.Sum256([]byte(fmt.Sprintf("public|%s|%d|%d|%s", key, info.Size, info.ModTime.UnixNano(), s.metadataPolicy)))
+	cacheKey := "public-" + hex.EncodeToString(sum[:])
+	if data, _, ok := s.cache.Get(cacheKey); ok {
+		return s.publicImage(data, info.ModTime, true), nil
+	}
+
+	reader, _, err := s.blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := StripMetadata(data, s.metadataPolicy)
+	if err != nil {
+		stripErr := err
+		err = s.decodes.run(s.decodeTimeout, func() error {
+			var err error
+			public, _, err = reencodePublic(data, stripErr)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.cache.Put(cacheKey, public); err != nil {
+		// 缓存失败不影响本次请求
+		logrus.Warnf("写入公开图片缓存失败: %v", err)
+	}
+	return s.publicImage(public, info.ModTime, false), nil
+}
+
+// publicImage 按文件内容识别格式，重新编码后的格式可能与原图不同
+func (s *renderService) publicImage(data []byte, modTime time.Time, cached bool) *RenderedImage {
+	format := sniffFormat(data)
+	return &RenderedImage{
+		Data:        data,
+		Format:      format,
+		ContentType: storage.ContentTypeByExtension(format),
+		ModTime:     modTime,
+		Cached:      cached,
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/cache"
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/storage"
+)
+
+func newTestRenderService(t *testing.T, concurrency int) (*renderService, storage.BlobStore) {
+	t.Helper()
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskCache, err := cache.NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Upload:  config.UploadConfig{DecodeConcurrency: concurrency, DecodeTimeout: 50 * time.Millisecond},
+		Privacy: config.PrivacyConfig{MetadataPolicy: config.MetadataPolicyOrientation},
+	}
+	return NewRenderService(nil, blobs, diskCache, cfg).(*renderService), blobs
+}
+
+func putTestBlob(t *testing.T, blobs storage.BlobStore, key string, data []byte) {
+	t.Helper()
+	if err := blobs.Put(key, bytes.NewReader(data), int64(len(data)), storage.ContentTypeByExtension(key)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicImageCached(t *testing.T) {
+	s, blobs := newTestRenderService(t, 1)
+	const key = "photo.jpeg"
+	putTestBlob(t, blobs, key, testJPEG(t))
+
+	first, err := s.PublicImage(key)
+	if err != nil {
+		t.Fatalf("PublicImage: %v", err)
+	}
+	if first.Cached || first.ContentType != "image/jpeg" {
+		t.Errorf("Cached = %v, ContentType = %s", first.Cached, first.ContentType)
+	}
+	if bytes.Contains(first.Data, []byte(testSecret)) {
+		t.Error("返回的文件仍包含元数据")
+	}
+
+	second, err := s.PublicImage(key)
+	if err != nil {
+		t.Fatalf("PublicImage: %v", err)
+	}
+	if !second.Cached || !bytes.Equal(first.Data, second.Data) {
+		t.Error("第二次访问应该返回缓存的结果")
+	}
+
+	// 删除原图之后不再从缓存返回
+	if err := blobs.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PublicImage(key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("删除后 err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPublicImageReencodeLimited(t *testing.T) {
+	s, blobs := newTestRenderService(t, 1)
+	// APP 段之间夹杂的多余字节无法按文件结构解析，需要重新编码
+	data := testJPEG(t)
+	com := bytes.Index(data, []byte{0xff, 0xfe})
+	data = append(append(append([]byte(nil), data[:com]...), 0x00, 0x00), data[com:]...)
+	const key = "broken.jpeg"
+	putTestBlob(t, blobs, key, data)
+
+	// 名额被占用时重新编码等待超时
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go s.decodes.run(0, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	if _, err := s.PublicImage(key); !errors.Is(err, ErrDecodeBusy) {
+		t.Errorf("名额被占用时 err = %v, want ErrDecodeBusy", err)
+	}
+	close(release)
+
+	public, err := s.PublicImage(key)
+	if err != nil {
+		t.Fatalf("PublicImage: %v", err)
+	}
+	if public.Cached || public.Format != "jpeg" {
+		t.Errorf("Cached = %v, Format = %s", public.Cached, public.Format)
+	}
+	if bytes.Contains(public.Data, []byte(testSecret)) {
+		t.Error("重新编码的文件仍包含元数据")
+	}
+	if cached, err := s.PublicImage(key); err != nil || !cached.Cached {
+		t.Errorf("重新编码的结果应该被缓存: %v", err)
+	}
+}
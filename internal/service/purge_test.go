@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/storage"
+)
+
+// hookedStore 在删除对象之前调用 beforeDelete
+type hookedStore struct {
+	storage.BlobStore
+	beforeDelete func(key string)
+}
+
+func (s *hookedStore) Delete(key string) error {
+	if s.beforeDelete != nil {
+		s.beforeDelete(key)
+	}
+	return s.BlobStore.Delete(key)
+}
+
+// testFile 用于模拟上传的文件
+type testFile struct {
+	*bytes.Reader
+}
+
+func (testFile) Close() error { return nil }
+
+func newTestService(t *testing.T, blobs storage.BlobStore) ImageService {
+	t.Helper()
+	db, err := repository.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := config.ParsePipeline(config.DefaultPipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Storage: config.StorageConfig{
+			Backend:       "local",
+			DedupMode:     DedupModeReuse,
+			SVGRasterSize: 1024,
+		},
+		Pipeline: config.PipelineConfig{Steps: steps},
+		Search: config.SearchConfig{
+			BatchConcurrency: 4,
+			BatchMaxQueries:  100,
+			MaxFrames:        16,
+		},
+	}
+	return NewImageService(repository.NewImageRepository(db), blobs, cfg)
+}
+
+func uploadTestImage(s ImageService, data []byte) (*model.Image, error) {
+	file := testFile{bytes.NewReader(data)}
+	header := &multipart.FileHeader{Filename: "photo.png", Size: int64(len(data))}
+	return s.UploadImage(file, header, "")
+}
+
+func TestPurgeKeepsFileOfConcurrentUpload(t *testing.T) {
+	local, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := &hookedStore{BlobStore: local}
+	s := newTestService(t, blobs)
+
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	img.Set(0, 0, color.RGBA{R: 1, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	first, err := uploadTestImage(s, data)
+	if err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+
+	// 永久删除计算出待删除的文件之后、真正删除之前，内容相同的图片再次上传
+	var (
+		once      sync.Once
+		wg        sync.WaitGroup
+		second    *model.Image
+		uploadErr error
+	)
+	blobs.beforeDelete = func(string) {
+		once.Do(func() {
+			done := make(chan struct{})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				second, uploadErr = uploadTestImage(s, data)
+			}()
+			select {
+			case <-done:
+			case <-time.After(300 * time.Millisecond):
+			}
+		})
+	}
+
+	// 未配置回收站保留期，删除图片立即永久删除
+	if err := s.DeleteImage(first.ID); err != nil {
+		t.Fatalf("永久删除失败: %v", err)
+	}
+	wg.Wait()
+	if uploadErr != nil {
+		t.Fatalf("并发上传失败: %v", uploadErr)
+	}
+	if second == nil {
+		t.Fatal("删除期间没有触发上传")
+	}
+
+	keys := []string{second.StorageKey()}
+	for _, rendition := range second.Renditions {
+		keys = append(keys, rendition.FilePath)
+	}
+	for _, key := range keys {
+		if _, err := local.Stat(key); err != nil {
+			t.Errorf("新上传图片的文件 %s 被删除: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+)
+
+// ErrQuotaExceeded 上传会超出存储配额
+var ErrQuotaExceeded = errors.New("超出存储配额")
+
+// ErrInvalidNamespace 命名空间格式不正确
+var ErrInvalidNamespace = errors.New("命名空间只能包含字母、数字、下划线、点和连字符，且以字母或数字开头，长度不超过64")
+
+// namespacePattern 合法的命名空间
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+const (
+	// QuotaScopeGlobal 整个实例的配额
+	QuotaScopeGlobal = "global"
+	// QuotaScopeNamespace 命名空间的配额
+	QuotaScopeNamespace = "namespace"
+
+	// QuotaResourceBytes 字节数配额
+	QuotaResourceBytes = "bytes"
+	// QuotaResourceImages 图片数量配额
+	QuotaResourceImages = "images"
+)
+
+// QuotaError 超出配额的详细信息
+type QuotaError struct {
+	Scope     string
+	Namespace string
+	Resource  string
+	Limit     int64
+	Used      int64
+	Requested int64
+}
+
+// Error 实现 error 接口
+func (e *QuotaError) Error() string {
+	scope := "实例"
+	if e.Scope == QuotaScopeNamespace {
+		scope = fmt.Sprintf("命名空间 %s ", e.Namespace)
+	}
+	if e.Resource == QuotaResourceImages {
+		return fmt.Sprintf("%s的图片数量已达到上限 %d", scope, e.Limit)
+	}
+	return fmt.Sprintf("%s的存储空间不足: 上限 %d 字节，已使用 %d 字节，本次上传需要 %d 字节", scope, e.Limit, e.Used, e.Requested)
+}
+
+// Unwrap 使 errors.Is(err, ErrQuotaExceeded) 成立
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// TooLarge 本次上传本身就超过上限，删除已有图片也无法上传
+func (e *QuotaError) TooLarge() bool {
+	return e.Requested > e.Limit
+}
+
+// UsageEntry 一个范围内的用量和上限，上限为 0 表示不限制
+type UsageEntry struct {
+	Namespace string `json:"namespace,omitempty"`
+	Images    int64  `json:"images"`
+	Bytes     int64  `json:"bytes"`
+	MaxImages int64  `json:"max_images"`
+	MaxBytes  int64  `json:"max_bytes"`
+}
+
+// UsageReport 存储用量报告
+type UsageReport struct {
+	Total      UsageEntry   `json:"total"`
+	Namespaces []UsageEntry `json:"namespaces"`
+}
+
+// NormalizeNamespace 校验命名空间，为空时返回默认命名空间
+func NormalizeNamespace(namespace string) (string, error) {
+	if namespace == "" {
+		return model.DefaultNamespace, nil
+	}
+	if !namespacePattern.MatchString(namespace) {
+		return "", ErrInvalidNamespace
+	}
+	return namespace, nil
+}
+
+// checkQuota 检查上传是否会超出配额
+// storedBytes 为需要新写入对象存储的字节数，namespaceBytes 为计入命名空间用量的字节数
+func (s *imageService) checkQuota(namespace string, storedBytes, namespaceBytes int64) error {
+	return s.checkUsage(s.imageRepo, namespace, storedBytes, namespaceBytes)
+}
+
+// quotaCheck 返回在创建图片记录的事务中重新检查配额的函数
+// 写入文件之前的检查与提交之间，并发的上传可能已经占用了配额，事务中的检查保证提交后不超出上限
+func (s *imageService) quotaCheck(namespace string, storedBytes, namespaceBytes int64) repository.UsageCheck {
+	return func(usage repository.UsageReader) error {
+		pending := pendingUsage{UsageReader: usage, storedBytes: storedBytes, namespaceBytes: namespaceBytes}
+		return s.checkUsage(pending, namespace, storedBytes, namespaceBytes)
+	}
+}
+
+// pendingUsage 从已包含本次上传的用量中扣除本次上传，事务中的检查与写入文件之前的检查使用相同的规则
+type pendingUsage struct {
+	repository.UsageReader
+	storedBytes    int64
+	namespaceBytes int64
+}
+
+// TotalUsage 返回本次上传之外的实例用量
+func (u pendingUsage) TotalUsage() (repository.Usage, error) {
+	usage, err := u.UsageReader.TotalUsage()
+	usage.Images--
+	usage.Bytes -= u.storedBytes
+	return usage, err
+}
+
+// NamespaceUsage 返回本次上传之外的命名空间用量
+func (u pendingUsage) NamespaceUsage(namespace string) (repository.Usage, error) {
+	usage, err := u.UsageReader.NamespaceUsage(namespace)
+	usage.Images--
+	usage.Bytes -= u.namespaceBytes
+	return usage, err
+}
+
+// checkUsage 按 usage 统计的用量检查新增一张图片是否会超出配额
+func (s *imageService) checkUsage(usage repository.UsageReader, namespace string, storedBytes, namespaceBytes int64) error {
+	if limit := s.quotaConfig.Global; limit.MaxBytes > 0 || limit.MaxImages > 0 {
+		usage, err := usage.TotalUsage()
+		if err != nil {
+			return fmt.Errorf("统计存储用量失败: %w", err)
+		}
+		if err := checkLimit(QuotaScopeGlobal, "", limit, usage, storedBytes); err != nil {
+			return err
+		}
+	}
+
+	if limit := s.quotaConfig.LimitFor(namespace); limit.MaxBytes > 0 || limit.MaxImages > 0 {
+		usage, err := usage.NamespaceUsage(namespace)
+		if err != nil {
+			return fmt.Errorf("统计命名空间用量失败: %w", err)
+		}
+		if err := checkLimit(QuotaScopeNamespace, namespace, limit, usage, namespaceBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLimit 检查新增一张图片和 bytes 字节后是否超出上限
+func checkLimit(scope, namespace string, limit config.QuotaLimit, usage repository.Usage, bytes int64) error {
+	if limit.MaxImages > 0 && usage.Images+1 > limit.MaxImages {
+		return &QuotaError{
+			Scope:     scope,
+			Namespace: namespace,
+			Resource:  QuotaResourceImages,
+			Limit:     limit.MaxImages,
+			Used:      usage.Images,
+			Requested: 1,
+		}
+	}
+	if limit.MaxBytes > 0 && usage.Bytes+bytes > limit.MaxBytes {
+		return &QuotaError{
+			Scope:     scope,
+			Namespace: namespace,
+			Resource:  QuotaResourceBytes,
+			Limit:     limit.MaxBytes,
+			Used:      usage.Bytes,
+			Requested: bytes,
+		}
+	}
+	return nil
+}
+
+// Usage 统计整个实例和每个命名空间的用量，已单独配置配额的命名空间即使没有图片也会列出
+func (s *imageService) Usage() (*UsageReport, error) {
+	total, err := s.imageRepo.TotalUsage()
+	if err != nil {
+		return nil, err
+	}
+	usages, err := s.imageRepo.ListNamespaceUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	for namespace := range s.quotaConfig.Namespaces {
+		if _, ok := usages[namespace]; !ok {
+			usages[namespace] = repository.Usage{}
+		}
+	}
+
+	report := &UsageReport{
+		Total: UsageEntry{
+			Images:    total.Images,
+			Bytes:     total.Bytes,
+			MaxImages: s.quotaConfig.Global.MaxImages,
+			MaxBytes:  s.quotaConfig.Global.MaxBytes,
+		},
+		Namespaces: make([]UsageEntry, 0, len(usages)),
+	}
+	for namespace, usage := range usages {
+		limit := s.quotaConfig.LimitFor(namespace)
+		report.Namespaces = append(report.Namespaces, UsageEntry{
+			Namespace: namespace,
+			Images:    usage.Images,
+			Bytes:     usage.Bytes,
+			MaxImages: limit.MaxImages,
+			MaxBytes:  limit.MaxBytes,
+		})
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+	return report, nil
+}
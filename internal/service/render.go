@@ -0,0 +1,289 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/cache"
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/google/uuid"
+	"github.com/nfnt/resize"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FitContain 等比缩放到完整放入目标尺寸内
+	FitContain = "contain"
+	// FitCover 等比缩放到覆盖目标尺寸，超出部分居中裁剪
+	FitCover = "cover"
+	// FitFill 拉伸到目标尺寸，不保持宽高比
+	FitFill = "fill"
+)
+
+// defaultRenderQuality 默认的 JPEG 输出质量
+const defaultRenderQuality = 85
+
+// ErrInvalidRenderOptions 图片处理参数无效
+var ErrInvalidRenderOptions = errors.New("无效的图片处理参数")
+
+// RenderOptions 图片处理参数
+type RenderOptions struct {
+	// Width、Height 目标宽高，为 0 时按另一边等比计算，都为 0 时保持原尺寸
+	Width  int
+	Height int
+	// Fit 同时指定宽高时的缩放方式，为空时使用 contain
+	Fit string
+	// Format 输出格式，jpeg 或 png，为空时与原图相同；原图为 GIF、WebP、TIFF 时使用 png
+	Format string
+	// Quality JPEG 输出质量，为 0 时使用默认值
+	Quality int
+}
+
+// RenderedImage 处理后的图片
+type RenderedImage struct {
+	Data        []byte
+	Format      string
+	ContentType string
+	// ETag 由原图内容和处理参数决定，相同参数的结果不变
+	ETag    string
+	ModTime time.Time
+	// Cached 是否命中磁盘缓存
+	Cached bool
+}
+
+// RenderService 图片实时处理服务
+type RenderService interface {
+	RenderImage(id uuid.UUID, opts RenderOptions) (*RenderedImage, error)
+	IIIFInfo(id uuid.UUID, baseURL string) (*IIIFInfo, error)
+	RenderIIIF(id uuid.UUID, req IIIFRequest) (*RenderedImage, error)
+	PublicImage(key string) (*RenderedImage, error)
+}
+
+// renderService 图片实时处理服务实现
+type renderService struct {
+	imageRepo    repository.ImageRepository
+	blobs        storage.BlobStore
+	cache        *cache.DiskCache
+	renderConfig config.RenderConfig
+	sources      *sourceCache
+	// decode 按处理流水线解码原图的选项
+	decode decodeOptions
+	// metadataPolicy 公开访问原图时删除元数据的策略
+	metadataPolicy string
+	// decodes 限制公开访问原图时同时执行的重新编码数量，与上传和搜索的名额分开计算
+	decodes       *decodeLimiter
+	decodeTimeout time.Duration
+}
+
+// NewRenderService 创建图片实时处理服务
+func NewRenderService(imageRepo repository.ImageRepository, blobs storage.BlobStore, diskCache *cache.DiskCache, cfg *config.Config) RenderService {
+	return &renderService{
+		imageRepo:      imageRepo,
+		blobs:          blobs,
+		cache:          diskCache,
+		renderConfig:   cfg.Render,
+		sources:        newSourceCache(decodedSourceCacheSize),
+		decode:         newPipeline(cfg.Pipeline).decodeOptions(cfg.Storage.SVGRasterSize),
+		metadataPolicy: cfg.Privacy.MetadataPolicy,
+		decodes:        newDecodeLimiter(cfg.Upload.DecodeConcurrency),
+		decodeTimeout:  cfg.Upload.DecodeTimeout,
+	}
+}
+
+// RenderImage 从原图按参数缩放、裁剪并转换格式，结果缓存在磁盘上
+func (s *renderService) RenderImage(id uuid.UUID, opts RenderOptions) (*RenderedImage, error) {
+	image, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.normalizeRenderOptions(&opts, image); err != nil {
+		return nil, err
+	}
+
+	// 相同内容的图片共享缓存，早期没有内容哈希的图片使用图片ID
+	source := image.ContentHash
+	if source == "" {
+		source = image.ID.String()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|w=%d|h=%d|fit=%s|format=%s|q=%d",
+		source, opts.Width, opts.Height, opts.Fit, opts.Format, opts.Quality)))
+	digest := hex.EncodeToString(sum[:])
+	cacheKey := digest + "." + opts.Format
+
+	rendered := &RenderedImage{
+		Format:      opts.Format,
+		ContentType: storage.ContentTypeByExtension(opts.Format),
+		ETag:        `"` + digest[:32] + `"`,
+	}
+
+	if data, modTime, ok := s.cache.Get(cacheKey); ok {
+		rendered.Data = data
+		rendered.ModTime = modTime
+		rendered.Cached = true
+		return rendered, nil
+	}
+
+	data, err := s.render(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	rendered.Data = data
+
+	modTime, err := s.cache.Put(cacheKey, data)
+	if err != nil {
+		// 缓存失败不影响本次请求
+		logrus.Warnf("写入图片处理缓存失败: %v", err)
+		modTime = time.Now()
+	}
+	rendered.ModTime = modTime
+	return rendered, nil
+}
+
+// normalizeRenderOptions 校验处理参数并填充默认值
+func (s *renderService) normalizeRenderOptions(opts *RenderOptions, image *model.Image) error {
+	maxDimension := s.renderConfig.MaxDimension
+	if opts.Width < 0 || opts.Height < 0 || (maxDimension > 0 && (opts.Width > maxDimension || opts.Height > maxDimension)) {
+		return fmt.Errorf("%w: 宽高必须在 0 到 %d 之间", ErrInvalidRenderOptions, maxDimension)
+	}
+
+	switch opts.Fit {
+	case "":
+		opts.Fit = FitContain
+	case FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("%w: 不支持的缩放方式 %s", ErrInvalidRenderOptions, opts.Fit)
+	}
+	// 只指定一边时总是等比缩放，缩放方式不影响结果
+	if opts.Width == 0 || opts.Height == 0 {
+		opts.Fit = FitContain
+	}
+
+	switch opts.Format {
+	case "":
+		opts.Format = derivativeFormat(image.Extension)
+	case "jpg":
+		opts.Format = "jpeg"
+	}
+	if opts.Format != "jpeg" && opts.Format != "png" {
+		return fmt.Errorf("%w: 不支持的输出格式 %s", ErrInvalidRenderOptions, opts.Format)
+	}
+
+	if opts.Quality == 0 {
+		opts.Quality = defaultRenderQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return fmt.Errorf("%w: 质量必须在 1 到 100 之间", ErrInvalidRenderOptions)
+	}
+	// PNG 为无损格式，质量参数不影响结果
+	if opts.Format == "png" {
+		opts.Quality = 0
+	}
+
+	// 只指定一边时另一边按比例计算，结果同样不能超过最大宽高
+	if maxDimension > 0 && image.Width > 0 && image.Height > 0 {
+		width, height := scaledSize(image.Width, image.Height, *opts)
+		if width > maxDimension || height > maxDimension {
+			return fmt.Errorf("%w: 输出尺寸 %dx%d 超过上限 %d", ErrInvalidRenderOptions, width, height, maxDimension)
+		}
+	}
+	return nil
+}
+
+// render 读取原图并按参数处理
+func (s *renderService) render(original *model.Image, opts RenderOptions) ([]byte, error) {
+	src, err := s.loadSource(original)
+	if err != nil {
+		return nil, err
+	}
+
+	out := transformImage(src, opts)
+
+	encoded := bytes.NewBuffer(nil)
+	if err := encodeImage(encoded, out, opts.Format, opts.Quality); err != nil {
+		logrus.Errorf("编码处理后的图片失败: %v", err)
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// loadSource 读取并解码原图，最近解码的原图保存在内存中，IIIF 瓦片请求不必每次重新解码
+func (s *renderService) loadSource(original *model.Image) (image.Image, error) {
+	return s.sources.get(original.StorageKey(), func() (image.Image, error) {
+		reader, _, err := s.blobs.Get(original.StorageKey())
+		if err != nil {
+			logrus.Errorf("读取原图失败: %v", err)
+			return nil, err
+		}
+		defer reader.Close()
+
+		src, _, err := decodeImage(reader, s.decode)
+		if err != nil {
+			logrus.Errorf("解码原图失败: %v", err)
+			return nil, err
+		}
+		return src, nil
+	})
+}
+
+// scaledSize 计算缩放后的尺寸，cover 返回裁剪后的尺寸
+func scaledSize(srcWidth, srcHeight int, opts RenderOptions) (int, int) {
+	width, height := opts.Width, opts.Height
+	switch {
+	case width == 0 && height == 0:
+		return srcWidth, srcHeight
+	case height == 0:
+		return width, maxInt(1, int(math.Round(float64(srcHeight)*float64(width)/float64(srcWidth))))
+	case width == 0:
+		return maxInt(1, int(math.Round(float64(srcWidth)*float64(height)/float64(srcHeight)))), height
+	}
+
+	if opts.Fit == FitContain {
+		scale := math.Min(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+		return maxInt(1, int(math.Round(float64(srcWidth)*scale))), maxInt(1, int(math.Round(float64(srcHeight)*scale)))
+	}
+	return width, height
+}
+
+// transformImage 按参数缩放和裁剪图片
+func transformImage(src image.Image, opts RenderOptions) image.Image {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := scaledSize(srcWidth, srcHeight, opts)
+	if width == srcWidth && height == srcHeight {
+		return src
+	}
+
+	if opts.Fit != FitCover {
+		return resize.Resize(uint(width), uint(height), src, resize.Lanczos3)
+	}
+
+	// 等比缩放到覆盖目标尺寸，再居中裁剪
+	scale := math.Max(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	scaledWidth := maxInt(width, int(math.Round(float64(srcWidth)*scale)))
+	scaledHeight := maxInt(height, int(math.Round(float64(srcHeight)*scale)))
+	scaled := resize.Resize(uint(scaledWidth), uint(scaledHeight), src, resize.Lanczos3)
+
+	offset := image.Pt((scaledWidth-width)/2, (scaledHeight-height)/2).Add(scaled.Bounds().Min)
+	cropped := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(cropped, cropped.Bounds(), scaled, offset, draw.Src)
+	return cropped
+}
+
+// maxInt 返回两个整数中较大的一个
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
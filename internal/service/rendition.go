@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/nfnt/resize"
+	"github.com/sirupsen/logrus"
+)
+
+// encodeImage 按指定格式编码图片，JPEG 不支持透明度，透明图片合成到白色背景上，避免透明区域变成黑色
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "jpg", "jpeg":
+		return jpeg.Encode(w, flattenImage(img, color.White), &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("不支持的输出格式: %s", format)
+	}
+}
+
+// IsRenditionKey 判断对象键是否为按 renditions 配置生成的衍生图片
+// 衍生图片由编码器从解码后的像素重新生成，不包含任何元数据
+func IsRenditionKey(key string, renditions []config.RenditionConfig) bool {
+	hash, rest, ok := strings.Cut(key, "_")
+	if !ok || len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return false
+	}
+	for _, rendition := range renditions {
+		if rest == rendition.Name+".jpeg" || rest == rendition.Name+".png" {
+			return true
+		}
+	}
+	return false
+}
+
+// renditionFile 已编码、尚未写入对象存储的衍生图片
+type renditionFile struct {
+	rendition model.Rendition
+	data      []byte
+}
+
+// generateRenditions 按配置生成衍生图片，只在内存中编码，由调用方登记后写入对象存储
+// 配置中未指定格式的衍生图片使用 defaultFormat；原图包含透明像素时 JPEG 改为 PNG，保留透明度
+func (s *imageService) generateRenditions(img image.Image, contentHash, defaultFormat string) ([]renditionFile, error) {
+	var files []renditionFile
+	transparent := hasAlpha(img)
+
+	for _, cfg := range s.storageConfig.Renditions {
+		format := cfg.Format
+		if format == "" {
+			format = defaultFormat
+		}
+		if format == "jpeg" && transparent {
+			format = "png"
+		}
+
+		// 等比缩小到不超过最大宽高，小图保持原尺寸
+		scaled := resize.Thumbnail(uint(cfg.MaxWidth), uint(cfg.MaxHeight), img, resize.Lanczos3)
+
+		encoded := bytes.NewBuffer(nil)
+		if err := encodeImage(encoded, scaled, format, cfg.Quality); err != nil {
+			logrus.Errorf("生成衍生图片 %s 失败: %v", cfg.Name, err)
+			return nil, err
+		}
+
+		bounds := scaled.Bounds()
+		files = append(files, renditionFile{
+			rendition: model.Rendition{
+				Name:      cfg.Name,
+				FilePath:  fmt.Sprintf("%s_%s.%s", contentHash, cfg.Name, format),
+				Format:    format,
+				Width:     bounds.Dx(),
+				Height:    bounds.Dy(),
+				Size:      int64(encoded.Len()),
+				CreatedAt: time.Now(),
+			},
+			data: encoded.Bytes(),
+		})
+	}
+
+	return files, nil
+}
+
+// copyRenditions 复制衍生图片记录，用于与已有图片共享文件的新记录
+func copyRenditions(renditions []model.Rendition) []model.Rendition {
+	copies := make([]model.Rendition, len(renditions))
+	for i, rendition := range renditions {
+		copies[i] = model.Rendition{
+			Name:      rendition.Name,
+			FilePath:  rendition.FilePath,
+			Format:    rendition.Format,
+			Width:     rendition.Width,
+			Height:    rendition.Height,
+			Size:      rendition.Size,
+			CreatedAt: time.Now(),
+		}
+	}
+	return copies
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+)
+
+func TestIsRenditionKey(t *testing.T) {
+	renditions := []config.RenditionConfig{{Name: "thumbnail"}, {Name: "large"}}
+	hash := strings.Repeat("0123456789abcdef", 4)
+
+	tests := map[string]bool{
+		hash + "_thumbnail.jpeg":                  true,
+		hash + "_large.png":                       true,
+		hash + ".jpeg":                            false,
+		hash + "_medium.jpeg":                     false,
+		hash + "_thumbnail.tiff":                  false,
+		strings.ToUpper(hash) + "_thumbnail.jpeg": false,
+		hash[:60] + "_thumbnail.jpeg":             false,
+		"images/" + hash[7:] + "_thumbnail.jpeg":  false,
+	}
+	for key, want := range tests {
+		if got := IsRenditionKey(key, renditions); got != want {
+			t.Errorf("IsRenditionKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultMMRLambda 默认的 MMR 相关性权重
+	defaultMMRLambda = 0.7
+	// defaultCollapseThreshold 默认的近似重复判定距离
+	defaultCollapseThreshold = 0.02
+	// rerankCandidateFactor 需要重排时，候选集相对于 limit 的放大倍数
+	rerankCandidateFactor = 5
+	// maxRerankCandidates 重排候选集的最大数量
+	maxRerankCandidates = 1000
+)
+
+// candidateLimit 计算重排前需要从仓库层取回的候选数量
+func candidateLimit(opts SearchOptions) int {
+	if !opts.Diversify && !opts.Collapse {
+		return opts.Limit
+	}
+	limit := opts.Limit * rerankCandidateFactor
+	if limit > maxRerankCandidates {
+		limit = maxRerankCandidates
+	}
+	return limit
+}
+
+// collapseDuplicates 将距离在阈值内的近似重复结果归为一组，每组只保留距离查询最近的代表
+// hits 需要按距离升序排列，返回的代表结果保持原有顺序
+func collapseDuplicates(hits []repository.SearchHit, threshold float32) ([]repository.SearchHit, map[uuid.UUID][]uuid.UUID) {
+	var representatives []repository.SearchHit
+	members := make(map[uuid.UUID][]uuid.UUID)
+
+	for _, hit := range hits {
+		merged := false
+		for _, rep := range representatives {
+			if repository.EuclideanDistance(hit.Embedding, rep.Embedding) <= threshold {
+				members[rep.Image.ID] = append(members[rep.Image.ID], hit.Image.ID)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			representatives = append(representatives, hit)
+		}
+	}
+
+	return representatives, members
+}
+
+// rerankMMR 使用最大边际相关性（Maximal Marginal Relevance）对结果重排
+// 每一步选择 lambda*相关性 - (1-lambda)*与已选结果的最大相似度 最高的候选
+func rerankMMR(hits []repository.SearchHit, lambda float64, limit int) []repository.SearchHit {
+	remaining := make([]repository.SearchHit, len(hits))
+	copy(remaining, hits)
+
+	selected := make([]repository.SearchHit, 0, limit)
+	for len(selected) < limit && len(remaining) > 0 {
+		bestIndex := 0
+		bestScore := 0.0
+		for i, candidate := range remaining {
+			relevance := similarity(candidate.Distance)
+
+			var redundancy float64
+			for _, chosen := range selected {
+				sim := similarity(repository.EuclideanDistance(candidate.Embedding, chosen.Embedding))
+				if sim > redundancy {
+					redundancy = sim
+				}
+			}
+
+			score := lambda*relevance - (1-lambda)*redundancy
+			if i == 0 || score > bestScore {
+				bestIndex = i
+				bestScore = score
+			}
+		}
+
+		selected = append(selected, remaining[bestIndex])
+		remaining = append(remaining[:bestIndex], remaining[bestIndex+1:]...)
+	}
+
+	return selected
+}
+
+// similarity 将距离转换为 (0, 1] 区间的相似度
+func similarity(distance float32) float64 {
+	return 1 / (1 + float64(distance))
+}
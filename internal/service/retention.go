@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/bytedance/ImageSearch/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetentionReportImages 保留策略报告中最多列出的图片数量，超出的只计数
+const maxRetentionReportImages = 1000
+
+// RetentionRuleResult 一条保留策略的执行结果
+type RetentionRuleResult struct {
+	Namespace string    `json:"namespace"`
+	MaxAge    string    `json:"max_age"`
+	Cutoff    time.Time `json:"cutoff"`
+	Matched   int       `json:"matched"`
+	Expired   int       `json:"expired"`
+}
+
+// RetentionCandidate 超过保留期的图片
+type RetentionCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	Namespace string    `json:"namespace"`
+	FileName  string    `json:"file_name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// Rule 匹配的保留策略的命名空间
+	Rule  string `json:"rule"`
+	Error string `json:"error,omitempty"`
+}
+
+// RetentionReport 保留策略执行报告
+type RetentionReport struct {
+	// DryRun 为 true 时只列出超过保留期的图片，不删除
+	DryRun  bool                  `json:"dry_run"`
+	Rules   []RetentionRuleResult `json:"rules"`
+	Matched int                   `json:"matched"`
+	Expired int                   `json:"expired"`
+	Failed  int                   `json:"failed"`
+	Images  []RetentionCandidate  `json:"images"`
+	// Truncated 超过保留期的图片太多，Images 只包含其中一部分
+	Truncated  bool      `json:"truncated"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// ApplyRetention 按保留策略删除上传时间超过保留期的图片，dryRun 为 true 时只生成报告
+// 删除与 DeleteImage 相同：配置了回收站保留期时移入回收站，否则立即永久删除
+func (s *imageService) ApplyRetention(dryRun bool) (*RetentionReport, error) {
+	report := &RetentionReport{
+		DryRun:    dryRun,
+		Rules:     []RetentionRuleResult{},
+		Images:    []RetentionCandidate{},
+		StartedAt: time.Now(),
+	}
+
+	// 单独配置的命名空间不适用通配规则
+	var specific []string
+	for _, rule := range s.quotaConfig.Retention {
+		if rule.Namespace != "*" {
+			specific = append(specific, rule.Namespace)
+		}
+	}
+
+	for _, rule := range s.quotaConfig.Retention {
+		filter := repository.NamespaceFilter{Include: []string{rule.Namespace}}
+		if rule.Namespace == "*" {
+			filter = repository.NamespaceFilter{Exclude: specific}
+		}
+
+		result := RetentionRuleResult{
+			Namespace: rule.Namespace,
+			MaxAge:    rule.MaxAge.String(),
+			Cutoff:    report.StartedAt.Add(-rule.MaxAge),
+		}
+		err := s.imageRepo.EachImageCreatedBefore(result.Cutoff, filter, func(img *model.Image) error {
+			result.Matched++
+			candidate := RetentionCandidate{
+				ID:        img.ID,
+				Namespace: img.Namespace,
+				FileName:  img.FileName,
+				Size:      img.Size,
+				CreatedAt: img.CreatedAt,
+				Rule:      rule.Namespace,
+			}
+
+			if !dryRun {
+				if err := s.DeleteImage(img.ID); err != nil {
+					candidate.Error = err.Error()
+					report.Failed++
+					logrus.Errorf("按保留策略删除图片 %s 失败: %v", img.ID, err)
+				} else {
+					result.Expired++
+				}
+			}
+
+			if len(report.Images) < maxRetentionReportImages {
+				report.Images = append(report.Images, candidate)
+			} else {
+				report.Truncated = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("执行保留策略 %s 失败: %w", rule.Namespace, err)
+		}
+
+		report.Matched += result.Matched
+		report.Expired += result.Expired
+		report.Rules = append(report.Rules, result)
+	}
+
+	report.DurationMs = milliseconds(time.Since(report.StartedAt))
+	return report, nil
+}
+
+// NewRetentionEnforcer 创建定期执行保留策略的后台任务
+func NewRetentionEnforcer(imageService ImageService, interval time.Duration) *PeriodicTask {
+	return NewPeriodicTask(interval, func() {
+		report, err := imageService.ApplyRetention(false)
+		if err != nil {
+			logrus.Errorf("执行保留策略失败: %v", err)
+			return
+		}
+		if report.Expired > 0 || report.Failed > 0 {
+			logrus.Infof("保留策略已删除 %d 张过期图片，失败 %d 张", report.Expired, report.Failed)
+		}
+	})
+}
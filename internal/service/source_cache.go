@@ -0,0 +1,80 @@
+package service
+
+import (
+	"image"
+	"sync"
+)
+
+// decodedSourceCacheSize 内存中保留的已解码原图数量，大图解码后占用内存较多，只保留少量
+const decodedSourceCacheSize = 2
+
+// sourceLoad 正在进行的原图解码，同一原图的并发请求等待同一次解码
+type sourceLoad struct {
+	done chan struct{}
+	img  image.Image
+	err  error
+}
+
+// sourceCache 已解码原图的 LRU 缓存
+type sourceCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	keys    []string
+	images  map[string]image.Image
+	loading map[string]*sourceLoad
+}
+
+// newSourceCache 创建已解码原图缓存
+func newSourceCache(capacity int) *sourceCache {
+	return &sourceCache{
+		capacity: capacity,
+		images:   make(map[string]image.Image),
+		loading:  make(map[string]*sourceLoad),
+	}
+}
+
+// get 返回缓存的原图，未命中时调用 load 解码，同一键的并发调用只解码一次
+func (c *sourceCache) get(key string, load func() (image.Image, error)) (image.Image, error) {
+	c.mu.Lock()
+	if img, ok := c.images[key]; ok {
+		c.touch(key)
+		c.mu.Unlock()
+		return img, nil
+	}
+	if pending, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		<-pending.done
+		return pending.img, pending.err
+	}
+	pending := &sourceLoad{done: make(chan struct{})}
+	c.loading[key] = pending
+	c.mu.Unlock()
+
+	pending.img, pending.err = load()
+
+	c.mu.Lock()
+	delete(c.loading, key)
+	if pending.err == nil && c.capacity > 0 {
+		c.images[key] = pending.img
+		c.touch(key)
+		for len(c.keys) > c.capacity {
+			delete(c.images, c.keys[0])
+			c.keys = c.keys[1:]
+		}
+	}
+	c.mu.Unlock()
+	close(pending.done)
+	return pending.img, pending.err
+}
+
+// touch 将键移动到最近使用的位置，调用方需持有锁
+func (c *sourceCache) touch(key string) {
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			break
+		}
+	}
+	c.keys = append(c.keys, key)
+}
@@ -0,0 +1,783 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/sirupsen/logrus"
+)
+
+// tiffTypeSizes TIFF 各数据类型一个值占用的字节数，下标为类型编号
+var tiffTypeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8, 4}
+
+// tiffStructureTags 解码 TIFF 图像数据需要的标签，删除元数据时总是保留
+var tiffStructureTags = map[uint16]bool{
+	254: true, 255: true, 256: true, 257: true, 258: true, 259: true, 262: true, 263: true,
+	264: true, 265: true, 266: true, 273: true, 277: true, 278: true, 279: true, 280: true,
+	281: true, 282: true, 283: true, 284: true, 286: true, 287: true, 290: true, 291: true,
+	292: true, 293: true, 296: true, 317: true, 318: true, 319: true, 320: true, 322: true,
+	323: true, 324: true, 325: true, 332: true, 338: true, 339: true, 340: true, 341: true,
+	347: true, 512: true, 513: true, 514: true, 515: true, 517: true, 518: true, 519: true,
+	520: true, 521: true, 529: true, 530: true, 531: true, 532: true,
+}
+
+// tiffIFDPointerTags 指向子 IFD 的标签：EXIF、GPS、互操作性和 SubIFDs
+var tiffIFDPointerTags = map[uint16]bool{0x8769: true, 0x8825: true, 0xa005: true, 330: true}
+
+const (
+	// tiffArtistTag 作者标签
+	tiffArtistTag = 0x013b
+	// tiffCopyrightTag 版权标签
+	tiffCopyrightTag = 0x8298
+	// tiffICCProfileTag ICC 配置文件标签
+	tiffICCProfileTag = 0x8773
+	// maxIFDDepth 清除子 IFD 时允许的最大嵌套层数
+	maxIFDDepth = 4
+)
+
+// pngRenderingChunks 除关键块外删除元数据时保留的 PNG 块，都是显示图片需要的信息
+var pngRenderingChunks = map[string]bool{
+	"tRNS": true, "cHRM": true, "gAMA": true, "sBIT": true, "sRGB": true, "bKGD": true,
+	"pHYs": true, "acTL": true, "fcTL": true, "fdAT": true, "cICP": true, "mDCv": true, "cLLi": true,
+}
+
+// keptMetadata 按策略保留的 EXIF 字段
+type keptMetadata struct {
+	orientation int
+	artist      string
+	copyright   string
+}
+
+// StripMetadata 按策略删除图片文件中的 EXIF、XMP、IPTC、注释等元数据，返回新的文件内容
+// orientation 策略保留 EXIF 方向和 ICC 配置文件，copyright 策略再保留版权和作者，strip 策略全部删除
+// ICC 配置文件按原样保留，其中的描述（desc）和版权（cprt）文本是色彩空间的名称和配置文件的版权声明，ICC 规范要求包含，
+// 不属于照片的元数据；需要删除任何嵌入文本时使用 strip 策略
+// SVG 和无法识别的文件原样返回；原图按原样保存，只在返回给客户端时删除
+func StripMetadata(data []byte, policy string) ([]byte, error) {
+	var (
+		stripped []byte
+		err      error
+	)
+	switch sniffFormat(data) {
+	case "jpeg":
+		stripped, err = stripJPEG(data, policy)
+	case "png":
+		stripped, err = stripPNG(data, policy)
+	case "webp":
+		stripped, err = stripWebP(data, policy)
+	case "gif":
+		stripped, err = stripGIF(data, policy)
+	case "tiff":
+		stripped, err = stripTIFF(data, policy)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	return stripped, nil
+}
+
+// PublicImageData 返回可以公开访问的图片文件内容，按策略删除元数据
+// 文件结构无法解析但可以解码的图片按 EXIF 方向旋转、转换为 sRGB 后重新编码为 JPEG 或 PNG，不包含任何元数据；
+// format 为重新编码后的格式，没有重新编码时为空字符串。动图和多页 TIFF 重新编码后只保留第一帧
+func PublicImageData(data []byte, policy string) ([]byte, string, error) {
+	stripped, err := StripMetadata(data, policy)
+	if err == nil {
+		return stripped, "", nil
+	}
+	return reencodePublic(data, err)
+}
+
+// reencodePublic 将无法删除元数据的图片解码后重新编码，stripErr 为删除元数据时的错误，无法解码时返回该错误
+func reencodePublic(data []byte, stripErr error) ([]byte, string, error) {
+	img, format, err := decodeImage(bytes.NewReader(data), decodeOptions{orient: true, srgb: true})
+	if err != nil {
+		return nil, "", stripErr
+	}
+	target := derivativeFormat(format)
+	encoded := bytes.NewBuffer(nil)
+	if err := encodeImage(encoded, img, target, defaultReencodeQuality); err != nil {
+		return nil, "", err
+	}
+	logrus.Warnf("删除图片元数据失败，已重新编码为 %s: %v", target, stripErr)
+	return encoded.Bytes(), target, nil
+}
+
+// readKeptMetadata 从 EXIF 中读取策略保留的字段，data 可以是 JPEG 文件或 TIFF 格式的 EXIF 数据
+func readKeptMetadata(data []byte, policy string) keptMetadata {
+	var kept keptMetadata
+	if policy == config.MetadataPolicyStrip || len(data) == 0 {
+		return kept
+	}
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return kept
+	}
+	if orientation, ok := exifInt(x, exif.Orientation); ok && orientation >= 2 && orientation <= 8 {
+		kept.orientation = orientation
+	}
+	if policy == config.MetadataPolicyCopyright {
+		kept.artist = exifString(x, exif.Artist)
+		kept.copyright = exifString(x, exif.Copyright)
+	}
+	return kept
+}
+
+// encodeEXIF 将保留的字段编码为 TIFF 格式的 EXIF 数据，没有需要保留的字段时返回 nil
+func encodeEXIF(kept keptMetadata) []byte {
+	type entry struct {
+		tag   uint16
+		typ   uint16
+		count uint32
+		value []byte
+	}
+	var entries []entry
+	if kept.orientation != 0 {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint16(value, uint16(kept.orientation))
+		entries = append(entries, entry{tiffOrientationTag, tiffShortType, 1, value})
+	}
+	// 标签必须按编号升序排列
+	for _, field := range []struct {
+		tag   uint16
+		value string
+	}{{tiffArtistTag, kept.artist}, {tiffCopyrightTag, kept.copyright}} {
+		if field.value != "" {
+			value := append([]byte(field.value), 0)
+			entries = append(entries, entry{field.tag, 2, uint32(len(value)), value})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	buf := bytes.NewBuffer([]byte("MM\x00*\x00\x00\x00\x08"))
+	ifdSize := 2 + 12*len(entries) + 4
+	extra := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(buf, binary.BigEndian, e.tag)
+		binary.Write(buf, binary.BigEndian, e.typ)
+		binary.Write(buf, binary.BigEndian, e.count)
+		if len(e.value) <= 4 {
+			value := make([]byte, 4)
+			copy(value, e.value)
+			buf.Write(value)
+			continue
+		}
+		binary.Write(buf, binary.BigEndian, uint32(8+ifdSize+extra.Len()))
+		extra.Write(e.value)
+		if extra.Len()%2 == 1 {
+			extra.WriteByte(0)
+		}
+	}
+	binary.Write(buf, binary.BigEndian, uint32(0))
+	buf.Write(extra.Bytes())
+	return buf.Bytes()
+}
+
+// stripJPEG 删除 JPEG 中的 APP1（EXIF、XMP）、APP13（IPTC）、注释等段以及结束标记之后的数据
+// 保留 JFIF、Adobe 段和按策略保留的 ICC 配置文件，需要保留的 EXIF 字段写入新的 APP1 段
+func stripJPEG(data []byte, policy string) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("JPEG 文件不完整")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	app1 := encodeEXIF(readKeptMetadata(data, policy))
+	writeApp1 := func() {
+		if app1 == nil {
+			return
+		}
+		payload := append([]byte("Exif\x00\x00"), app1...)
+		if len(payload)+2 > 0xffff {
+			// 超长的版权或作者无法写入一个段，不再保留
+			app1 = nil
+			return
+		}
+		out.Write([]byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+		out.Write(payload)
+		app1 = nil
+	}
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("JPEG 在 %d 字节处缺少标记", pos)
+		}
+		// 跳过填充字节
+		for pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+1 >= len(data) {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xd9 {
+			writeApp1()
+			out.Write([]byte{0xff, 0xd9})
+			// 结束标记之后可能附加了其他图片（如 MPF 中的预览图）及其元数据，全部丢弃
+			return out.Bytes(), nil
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			return nil, errors.New("JPEG 段不完整")
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			return nil, errors.New("JPEG 段长度无效")
+		}
+		segment, payload := data[pos:end], data[pos+4:end]
+
+		// JFIF 要求 APP0 为第一个段，新的 APP1 写在其后
+		if marker != 0xe0 {
+			writeApp1()
+		}
+		if keepJPEGSegment(marker, payload, policy) {
+			out.Write(segment)
+		}
+		pos = end
+
+		if marker == 0xda {
+			// 复制熵编码数据，直到遇到下一个不是填充（FF00）或重启标记的标记
+			start := pos
+			for pos+1 < len(data) && !(data[pos] == 0xff && data[pos+1] != 0 && (data[pos+1] < 0xd0 || data[pos+1] > 0xd7)) {
+				pos++
+			}
+			if pos+1 >= len(data) {
+				pos = len(data)
+			}
+			out.Write(data[start:pos])
+		}
+	}
+	// 缺少结束标记的文件补上结束标记
+	writeApp1()
+	out.Write([]byte{0xff, 0xd9})
+	return out.Bytes(), nil
+}
+
+// keepJPEGSegment 判断是否保留 JPEG 的段
+func keepJPEGSegment(marker byte, payload []byte, policy string) bool {
+	switch {
+	case marker == 0xe0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == 0xe2:
+		return policy != config.MetadataPolicyStrip && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		// Adobe 段记录颜色变换方式，解码需要
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xe1 && marker <= 0xef, marker == 0xfe:
+		return false
+	default:
+		return true
+	}
+}
+
+// stripPNG 只保留 PNG 的关键块和显示需要的辅助块，按策略保留 ICC 配置文件、版权和作者文本
+// 需要保留的 EXIF 字段写入新的 eXIf 块
+func stripPNG(data []byte, policy string) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+	var exifChunk []byte
+
+	// 先找到原来的 eXIf 块，新的 eXIf 块写在第一个 IDAT 之前
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if chunk.typ == "eXIf" {
+			exifChunk = encodeEXIF(readKeptMetadata(chunk.data, policy))
+			break
+		}
+	}
+
+	for _, chunk := range chunks {
+		if chunk.typ == "IDAT" && exifChunk != nil {
+			writePNGChunk(out, "eXIf", exifChunk)
+			exifChunk = nil
+		}
+		if keepPNGChunk(chunk.typ, chunk.data, policy) {
+			out.Write(chunk.raw)
+		}
+		if chunk.typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// pngChunk PNG 中的一个块
+type pngChunk struct {
+	typ  string
+	data []byte
+	// raw 包含长度、类型和 CRC 的完整块
+	raw []byte
+}
+
+// pngChunks 解析 PNG 中的块，到 IEND 为止
+func pngChunks(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+	pos := 8
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) || end < pos {
+			return nil, errors.New("PNG 块长度无效")
+		}
+		chunk := pngChunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : pos+8+length], raw: data[pos:end]}
+		chunks = append(chunks, chunk)
+		pos = end
+		if chunk.typ == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, errors.New("PNG 缺少 IEND 块")
+}
+
+// keepPNGChunk 判断是否保留 PNG 块，首字母大写的关键块总是保留
+func keepPNGChunk(typ string, data []byte, policy string) bool {
+	if typ[0] >= 'A' && typ[0] <= 'Z' {
+		return true
+	}
+	switch typ {
+	case "iCCP":
+		return policy != config.MetadataPolicyStrip
+	case "tEXt", "zTXt", "iTXt":
+		if policy != config.MetadataPolicyCopyright {
+			return false
+		}
+		keyword, _, _ := bytes.Cut(data, []byte{0})
+		return string(keyword) == "Copyright" || string(keyword) == "Author"
+	default:
+		return pngRenderingChunks[typ]
+	}
+}
+
+// writePNGChunk 写入一个 PNG 块
+func writePNGChunk(out *bytes.Buffer, typ string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(typ)
+	out.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// stripWebP 删除 WebP 的 EXIF、XMP 和其他未知块，按策略保留 ICC 配置文件，并更新 VP8X 中的标志位
+func stripWebP(data []byte, policy string) ([]byte, error) {
+	const (
+		iccFlag  = 0x20
+		exifFlag = 0x08
+		xmpFlag  = 0x04
+	)
+	if len(data) < 12 {
+		return nil, errors.New("WebP 文件不完整")
+	}
+	body := bytes.NewBuffer(nil)
+	var (
+		exifData []byte
+		vp8x     = -1
+	)
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || pos+8+size > len(data) || end < pos {
+			return nil, errors.New("WebP 块长度无效")
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		switch fourCC {
+		case "VP8X":
+			vp8x = body.Len()
+			body.Write(data[pos:end])
+		case "VP8 ", "VP8L", "ALPH", "ANIM", "ANMF":
+			body.Write(data[pos:end])
+		case "ICCP":
+			if policy != config.MetadataPolicyStrip {
+				body.Write(data[pos:end])
+			}
+		case "EXIF":
+			exifData = encodeEXIF(readKeptMetadata(data[pos+8:pos+8+size], policy))
+		}
+		pos = end
+	}
+
+	stripped := body.Bytes()
+	// 只有扩展格式（VP8X）可以包含元数据
+	if vp8x >= 0 && vp8x+9 <= len(stripped) {
+		flags := stripped[vp8x+8] &^ (exifFlag | xmpFlag)
+		if policy == config.MetadataPolicyStrip {
+			flags &^= iccFlag
+		}
+		if exifData != nil {
+			flags |= exifFlag
+		}
+		stripped[vp8x+8] = flags
+		if exifData != nil {
+			chunk := bytes.NewBuffer(stripped)
+			chunk.WriteString("EXIF")
+			binary.Write(chunk, binary.LittleEndian, uint32(len(exifData)))
+			chunk.Write(exifData)
+			if len(exifData)%2 == 1 {
+				chunk.WriteByte(0)
+			}
+			stripped = chunk.Bytes()
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(stripped)+12))
+	out.WriteString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(len(stripped)+4))
+	out.WriteString("WEBP")
+	out.Write(stripped)
+	return out.Bytes(), nil
+}
+
+// stripGIF 删除 GIF 中的注释扩展和 XMP 等应用扩展，保留循环次数等动画需要的扩展
+func stripGIF(data []byte, policy string) ([]byte, error) {
+	const colorTableFlag = 0x80
+	if len(data) < 13 {
+		return nil, errors.New("GIF 文件头不完整")
+	}
+	pos := 13
+	if flags := data[10]; flags&colorTableFlag != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	if pos > len(data) {
+		return nil, errors.New("GIF 颜色表不完整")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:pos])
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21:
+			if pos+2 > len(data) {
+				return nil, errors.New("GIF 扩展块不完整")
+			}
+			end, err := skipGIFSubBlocks(data, pos+2)
+			if err != nil {
+				return nil, err
+			}
+			if keepGIFExtension(data[pos+1], data[pos+2:end], policy) {
+				out.Write(data[pos:end])
+			}
+			pos = end
+		case 0x2c:
+			if pos+10 > len(data) {
+				return nil, errors.New("GIF 图像描述符不完整")
+			}
+			start := pos
+			flags := data[pos+9]
+			pos += 10
+			if flags&colorTableFlag != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			end, err := skipGIFSubBlocks(data, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(data[start:end])
+			pos = end
+		case 0x3b:
+			out.WriteByte(0x3b)
+			return out.Bytes(), nil
+		default:
+			return nil, fmt.Errorf("GIF 中存在未知的数据块 0x%02x", data[pos])
+		}
+	}
+	out.WriteByte(0x3b)
+	return out.Bytes(), nil
+}
+
+// keepGIFExtension 判断是否保留 GIF 扩展块，blocks 为扩展的数据子块
+func keepGIFExtension(label byte, blocks []byte, policy string) bool {
+	switch label {
+	case 0xfe:
+		// 注释扩展
+		return false
+	case 0xff:
+		if len(blocks) < 12 {
+			return false
+		}
+		switch string(blocks[1:12]) {
+		case "NETSCAPE2.0", "ANIMEXTS1.0":
+			return true
+		case "ICCRGBG1012":
+			return policy != config.MetadataPolicyStrip
+		default:
+			// XMP 等其他应用扩展
+			return false
+		}
+	case 0xf9:
+		// 图形控制扩展，决定动画的帧间隔和透明色
+		return true
+	default:
+		// 纯文本扩展和其他未知扩展，浏览器不显示，但可以携带任意文本
+		return false
+	}
+}
+
+// stripTIFF 只保留 TIFF 每一页中解码需要的标签，按策略保留方向、ICC 配置文件、版权和作者
+// 删除的标签的值以及 EXIF、GPS 等子 IFD 的内容清零，文件中不会残留这些数据
+// 删除的值与保留的标签、图像数据或各页的 IFD 共用同一段数据时，这部分不清零，避免破坏图像
+func stripTIFF(data []byte, policy string) ([]byte, error) {
+	order, pages, err := tiffPages(data)
+	if err != nil {
+		return nil, err
+	}
+	ifds := make([]tiffIFD, len(pages))
+	var referenced tiffRanges
+	for i, page := range pages {
+		ifd, err := readTIFFIFD(data, order, int(page.offset), policy)
+		if err != nil {
+			return nil, err
+		}
+		ifds[i] = ifd
+		referenced = append(referenced, ifd.references(data, order)...)
+	}
+
+	out := append([]byte(nil), data...)
+	// 先清零删除的标签的值，各页的 IFD 此时还没有修改，需要完整保留
+	protected := append(tiffRanges(nil), referenced...)
+	for _, ifd := range ifds {
+		protected = append(protected, tiffRange{uint64(ifd.offset), uint64(ifd.end())})
+	}
+	protected.normalize()
+	for _, ifd := range ifds {
+		for _, entry := range ifd.removed {
+			clearTIFFEntry(out, order, entry, protected, 0)
+		}
+	}
+
+	// 再把保留的标签前移，IFD 末尾多出的位置清零，只保留前移之后的 IFD
+	protected = referenced
+	for _, ifd := range ifds {
+		protected = append(protected, tiffRange{uint64(ifd.offset), uint64(ifd.offset + 2 + 12*len(ifd.kept) + 4)})
+	}
+	protected.normalize()
+	for _, ifd := range ifds {
+		ifd.write(out, order, protected)
+	}
+	return out, nil
+}
+
+// tiffIFD 删除元数据之前的一个 IFD，条目都是副本，清零其他数据时不会改变
+type tiffIFD struct {
+	offset  int
+	kept    [][]byte
+	removed [][]byte
+	next    []byte
+}
+
+// readTIFFIFD 读取 offset 处的 IFD，并按策略区分保留和删除的标签
+func readTIFFIFD(data []byte, order binary.ByteOrder, offset int, policy string) (tiffIFD, error) {
+	if offset+2 > len(data) {
+		return tiffIFD{}, errors.New("TIFF IFD 偏移无效")
+	}
+	count := int(order.Uint16(data[offset:]))
+	end := offset + 2 + 12*count
+	if end+4 > len(data) {
+		return tiffIFD{}, errors.New("TIFF IFD 不完整")
+	}
+	ifd := tiffIFD{offset: offset, next: append([]byte(nil), data[end:end+4]...)}
+	for i := 0; i < count; i++ {
+		entry := append([]byte(nil), data[offset+2+12*i:offset+14+12*i]...)
+		if keepTIFFTag(order.Uint16(entry), policy) {
+			ifd.kept = append(ifd.kept, entry)
+		} else {
+			ifd.removed = append(ifd.removed, entry)
+		}
+	}
+	return ifd, nil
+}
+
+// end 返回 IFD（包括下一个 IFD 的偏移）结束的位置
+func (ifd tiffIFD) end() int {
+	return ifd.offset + 2 + 12*(len(ifd.kept)+len(ifd.removed)) + 4
+}
+
+// references 返回保留的标签引用的数据：IFD 之外的值，以及条带、瓦片和 JPEG 缩略图的图像数据
+func (ifd tiffIFD) references(data []byte, order binary.ByteOrder) tiffRanges {
+	var ranges tiffRanges
+	values := make(map[uint16][]uint64)
+	for _, entry := range ifd.kept {
+		if start, size, ok := tiffEntryValueRange(order, entry); ok && size > 4 {
+			ranges.add(start, start+size, len(data))
+		}
+		values[order.Uint16(entry)] = tiffEntryValues(data, order, entry)
+	}
+	// 条带偏移和字节数、瓦片偏移和字节数、JPEG 缩略图偏移和长度
+	for _, pair := range [][2]uint16{{273, 279}, {324, 325}, {513, 514}} {
+		offsets, counts := values[pair[0]], values[pair[1]]
+		for i := 0; i < len(offsets) && i < len(counts); i++ {
+			ranges.add(offsets[i], offsets[i]+counts[i], len(data))
+		}
+	}
+	return ranges
+}
+
+// write 将保留的标签前移写回 IFD，IFD 末尾多出的位置中不属于 protected 的部分清零
+func (ifd tiffIFD) write(data []byte, order binary.ByteOrder, protected tiffRanges) {
+	order.PutUint16(data[ifd.offset:], uint16(len(ifd.kept)))
+	pos := ifd.offset + 2
+	for _, entry := range ifd.kept {
+		copy(data[pos:], entry)
+		pos += 12
+	}
+	copy(data[pos:], ifd.next)
+	protected.zero(data, uint64(pos+4), uint64(ifd.end()))
+}
+
+// keepTIFFTag 判断是否保留 TIFF 标签
+func keepTIFFTag(tag uint16, policy string) bool {
+	switch tag {
+	case tiffOrientationTag, tiffICCProfileTag:
+		return policy != config.MetadataPolicyStrip
+	case tiffArtistTag, tiffCopyrightTag:
+		return policy == config.MetadataPolicyCopyright
+	default:
+		return tiffStructureTags[tag]
+	}
+}
+
+// tiffEntryValueRange 返回 IFD 条目的值在文件中的位置和长度，类型未知时返回 false
+// 长度不超过 4 字节的值直接保存在条目中，返回的位置没有意义
+func tiffEntryValueRange(order binary.ByteOrder, entry []byte) (uint64, uint64, bool) {
+	typ, count := order.Uint16(entry[2:]), order.Uint32(entry[4:])
+	if int(typ) >= len(tiffTypeSizes) || tiffTypeSizes[typ] == 0 {
+		return 0, 0, false
+	}
+	return uint64(order.Uint32(entry[8:])), uint64(count) * uint64(tiffTypeSizes[typ]), true
+}
+
+// tiffEntryValues 读取 SHORT 或 LONG 类型条目的所有值，其他类型或值超出文件范围时返回 nil
+func tiffEntryValues(data []byte, order binary.ByteOrder, entry []byte) []uint64 {
+	typ := order.Uint16(entry[2:])
+	if typ != tiffShortType && typ != tiffLongType {
+		return nil
+	}
+	start, size, _ := tiffEntryValueRange(order, entry)
+	raw := entry[8:12]
+	if size > 4 {
+		if start+size > uint64(len(data)) {
+			return nil
+		}
+		raw = data[start : start+size]
+	}
+	width := uint64(tiffTypeSizes[typ])
+	values := make([]uint64, 0, size/width)
+	for i := uint64(0); i+width <= size; i += width {
+		if typ == tiffShortType {
+			values = append(values, uint64(order.Uint16(raw[i:])))
+		} else {
+			values = append(values, uint64(order.Uint32(raw[i:])))
+		}
+	}
+	return values
+}
+
+// clearTIFFEntry 清零 IFD 条目在 IFD 之外的值，指向子 IFD 的条目同时清零子 IFD，属于 protected 的部分不清零
+func clearTIFFEntry(data []byte, order binary.ByteOrder, entry []byte, protected tiffRanges, depth int) {
+	start, size, ok := tiffEntryValueRange(order, entry)
+	if !ok {
+		return
+	}
+	if size > 4 && start+size <= uint64(len(data)) {
+		protected.zero(data, start, start+size)
+	}
+	if tiffIFDPointerTags[order.Uint16(entry)] && size == 4 && depth < maxIFDDepth {
+		clearTIFFIFD(data, order, int(order.Uint32(entry[8:])), protected, depth+1)
+	}
+}
+
+// clearTIFFIFD 清零子 IFD 及其所有值，属于 protected 的部分不清零
+func clearTIFFIFD(data []byte, order binary.ByteOrder, offset int, protected tiffRanges, depth int) {
+	if offset < 8 || offset+2 > len(data) {
+		return
+	}
+	count := int(order.Uint16(data[offset:]))
+	end := offset + 2 + 12*count + 4
+	if end > len(data) {
+		return
+	}
+	// 先复制所有条目，清零前面条目的值时可能覆盖后面的条目
+	entries := append([]byte(nil), data[offset+2:end-4]...)
+	for i := 0; i < count; i++ {
+		clearTIFFEntry(data, order, entries[12*i:12*i+12], protected, depth)
+	}
+	protected.zero(data, uint64(offset), uint64(end))
+}
+
+// tiffRange 文件中 [start, end) 的一段数据
+type tiffRange struct {
+	start, end uint64
+}
+
+// tiffRanges 删除元数据后仍被引用的数据，normalize 之后按起始位置排序且互不重叠
+type tiffRanges []tiffRange
+
+// add 添加一段数据，超出文件长度 size 的部分忽略
+func (r *tiffRanges) add(start, end uint64, size int) {
+	if end > uint64(size) {
+		end = uint64(size)
+	}
+	if start < end {
+		*r = append(*r, tiffRange{start, end})
+	}
+}
+
+// normalize 按起始位置排序并合并重叠的区间
+func (r *tiffRanges) normalize() {
+	ranges := *r
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:0]
+	for _, rg := range ranges {
+		if n := len(merged); n > 0 && rg.start <= merged[n-1].end {
+			if rg.end > merged[n-1].end {
+				merged[n-1].end = rg.end
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	*r = merged
+}
+
+// zero 清零 data 中 [start, end) 不属于任何区间的部分
+func (r tiffRanges) zero(data []byte, start, end uint64) {
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+	i := sort.Search(len(r), func(i int) bool { return r[i].end > start })
+	for pos := start; pos < end; {
+		if i < len(r) && r[i].start <= pos {
+			pos = r[i].end
+			i++
+			continue
+		}
+		stop := end
+		if i < len(r) && r[i].start < stop {
+			stop = r[i].start
+		}
+		for ; pos < stop; pos++ {
+			data[pos] = 0
+		}
+	}
+}
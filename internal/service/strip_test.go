@@ -0,0 +1,486 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	testArtist    = "Jane Doe"
+	testCopyright = "(c) 2026 Jane Doe"
+	// testSecret 出现在 XMP 和注释中的内容，删除元数据后不能再出现
+	testSecret = "secret-location"
+)
+
+// testTIFFEntry 测试用的 TIFF 标签，sub 不为 nil 时为指向子 IFD 的标签
+type testTIFFEntry struct {
+	tag   uint16
+	typ   uint16
+	value []byte
+	sub   []testTIFFEntry
+	// offset 不为 0 时值不另外写入，直接指向该位置，用于构造共用数据的标签
+	offset uint32
+	count  uint32
+}
+
+// appendTestIFD 在 data 末尾按大端字节序写入一个 IFD 及其值，返回写入后的内容和 IFD 的偏移
+func appendTestIFD(data []byte, entries []testTIFFEntry) ([]byte, uint32) {
+	order := binary.BigEndian
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	offset := len(data)
+	data = append(data, make([]byte, 2+12*len(entries)+4)...)
+	order.PutUint16(data[offset:], uint16(len(entries)))
+	for i, e := range entries {
+		pos := offset + 2 + 12*i
+		value, typ := e.value, e.typ
+		if e.sub != nil {
+			var sub uint32
+			data, sub = appendTestIFD(data, e.sub)
+			value, typ = order.AppendUint32(nil, sub), tiffLongType
+		}
+		order.PutUint16(data[pos:], e.tag)
+		order.PutUint16(data[pos+2:], typ)
+		switch {
+		case e.offset != 0:
+			order.PutUint32(data[pos+4:], e.count)
+			order.PutUint32(data[pos+8:], e.offset)
+		case len(value) <= 4:
+			order.PutUint32(data[pos+4:], uint32(len(value)/tiffTypeSizes[typ]))
+			copy(data[pos+8:pos+12], value)
+		default:
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+			order.PutUint32(data[pos+4:], uint32(len(value)/tiffTypeSizes[typ]))
+			order.PutUint32(data[pos+8:], uint32(len(data)))
+			data = append(data, value...)
+		}
+	}
+	return data, uint32(offset)
+}
+
+// testShort 编码 SHORT 类型的值
+func testShort(values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+// testRationals 编码 RATIONAL 类型的值，分母均为 1
+func testRationals(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+		b = binary.BigEndian.AppendUint32(b, 1)
+	}
+	return b
+}
+
+// testMetadataEntries 方向、作者、版权和 GPS 坐标标签，按标签编号升序排列
+func testMetadataEntries() []testTIFFEntry {
+	return []testTIFFEntry{
+		{tag: tiffOrientationTag, typ: tiffShortType, value: testShort(6)},
+		{tag: tiffArtistTag, typ: 2, value: []byte(testArtist + "\x00")},
+		{tag: tiffCopyrightTag, typ: 2, value: []byte(testCopyright + "\x00")},
+		{tag: 0x8825, sub: []testTIFFEntry{
+			{tag: 1, typ: 2, value: []byte("N\x00")},
+			{tag: 2, typ: 5, value: testRationals(39, 54, 0)},
+			{tag: 3, typ: 2, value: []byte("E\x00")},
+			{tag: 4, typ: 5, value: testRationals(116, 24, 0)},
+		}},
+	}
+}
+
+// testEXIF 生成包含方向、作者、版权和 GPS 坐标的 EXIF 数据
+func testEXIF() []byte {
+	data, _ := appendTestIFD([]byte("MM\x00*\x00\x00\x00\x08"), testMetadataEntries())
+	return data
+}
+
+// testXMP 包含 testSecret 的 XMP 数据
+var testXMP = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description exif:GPSLatitude="` + testSecret + `"/></x:xmpmeta>`)
+
+// testImage 生成 8x4 的测试图片，每个像素颜色不同
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 60), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegSegment 生成一个 JPEG 段
+func jpegSegment(marker byte, payload []byte) []byte {
+	return append([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+}
+
+// testJPEG 生成带有 EXIF、XMP 和注释的 JPEG
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	data := append([]byte(nil), encoded[:2]...)
+	data = append(data, jpegSegment(0xe1, append([]byte("Exif\x00\x00"), testEXIF()...))...)
+	data = append(data, jpegSegment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), testXMP...))...)
+	data = append(data, jpegSegment(0xfe, []byte(testSecret))...)
+	return append(data, encoded[2:]...)
+}
+
+// testPNG 生成带有 eXIf、XMP 和文本块的 PNG
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	const ihdrEnd = 8 + 12 + 13
+	var chunks bytes.Buffer
+	writePNGChunk(&chunks, "eXIf", testEXIF())
+	writePNGChunk(&chunks, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP...))
+	writePNGChunk(&chunks, "tEXt", []byte("Comment\x00"+testSecret))
+	writePNGChunk(&chunks, "tEXt", []byte("Copyright\x00"+testCopyright))
+	data := append([]byte(nil), encoded[:ihdrEnd]...)
+	data = append(data, chunks.Bytes()...)
+	return append(data, encoded[ihdrEnd:]...)
+}
+
+// gifSubBlocks 将数据编码为 GIF 数据子块序列
+func gifSubBlocks(data []byte) []byte {
+	var out []byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > 255 {
+			n = 255
+		}
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return append(out, 0)
+}
+
+// testGIF 生成带有注释扩展、XMP 应用扩展和纯文本扩展的 GIF
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	pos := 13
+	if flags := encoded[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	data := append([]byte(nil), encoded[:pos]...)
+	data = append(data, 0x21, 0xfe)
+	data = append(data, gifSubBlocks([]byte(testSecret))...)
+	data = append(data, 0x21, 0xff, 11)
+	data = append(data, "XMP DataXMP"...)
+	data = append(data, gifSubBlocks(testXMP)...)
+	// 纯文本扩展：12 字节的文本网格参数，然后是文本
+	data = append(data, 0x21, 0x01, 12)
+	data = append(data, make([]byte, 12)...)
+	data = append(data, gifSubBlocks([]byte(testSecret))...)
+	return append(data, encoded[pos:]...)
+}
+
+// testWebP 生成带有 EXIF 和 XMP 块的 1x1 无损 WebP
+func testWebP() []byte {
+	vp8l := []byte("VP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.WriteString("VP8X")
+	binary.Write(&body, binary.LittleEndian, uint32(10))
+	// EXIF 和 XMP 标志，画布宽高均为 1
+	body.Write([]byte{0x08 | 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	body.Write(vp8l)
+	for _, chunk := range []struct {
+		fourCC string
+		data   []byte
+	}{{"EXIF", testEXIF()}, {"XMP ", testXMP}} {
+		body.WriteString(chunk.fourCC)
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	out := bytes.NewBufferString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// testTIFF 生成 8x4 未压缩 RGB 的 TIFF，第一页中包含方向、作者、版权、XMP 和 GPS 坐标，extra 为追加到第一页的标签
+func testTIFF(extra ...testTIFFEntry) []byte {
+	order := binary.BigEndian
+	src := testImage()
+	data := []byte("MM\x00*\x00\x00\x00\x00")
+	pixels := uint32(len(data))
+	for i := 0; i < len(src.Pix); i += 4 {
+		data = append(data, src.Pix[i:i+3]...)
+	}
+
+	metadata := testMetadataEntries()
+	entries := []testTIFFEntry{
+		{tag: 256, typ: tiffShortType, value: testShort(8)},
+		{tag: 257, typ: tiffShortType, value: testShort(4)},
+		{tag: 258, typ: tiffShortType, value: testShort(8, 8, 8)},
+		{tag: 259, typ: tiffShortType, value: testShort(1)},
+		{tag: 262, typ: tiffShortType, value: testShort(2)},
+	}
+	entries = append(entries, extra...)
+	entries = append(entries,
+		testTIFFEntry{tag: 273, typ: tiffLongType, value: order.AppendUint32(nil, pixels)},
+		metadata[0],
+		testTIFFEntry{tag: 277, typ: tiffShortType, value: testShort(3)},
+		testTIFFEntry{tag: 278, typ: tiffShortType, value: testShort(4)},
+		testTIFFEntry{tag: 279, typ: tiffLongType, value: order.AppendUint32(nil, uint32(len(data))-pixels)},
+		metadata[1],
+		testTIFFEntry{tag: 700, typ: 1, value: testXMP},
+		metadata[2],
+		metadata[3],
+	)
+	data, ifd := appendTestIFD(data, entries)
+	order.PutUint32(data[4:], ifd)
+	return data
+}
+
+// readTestEXIF 读取图片中的 EXIF，没有 EXIF 时返回 nil
+func readTestEXIF(t *testing.T, format string, data []byte) *exif.Exif {
+	t.Helper()
+	var payload []byte
+	switch format {
+	case "jpeg", "tiff":
+		payload = data
+	case "png":
+		chunks, err := pngChunks(data)
+		if err != nil {
+			t.Fatalf("pngChunks: %v", err)
+		}
+		for _, chunk := range chunks {
+			if chunk.typ == "eXIf" {
+				payload = chunk.data
+			}
+		}
+	case "webp":
+		for pos := 12; pos+8 <= len(data); {
+			size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+			if string(data[pos:pos+4]) == "EXIF" {
+				payload = data[pos+8 : pos+8+size]
+			}
+			pos += 8 + size + size%2
+		}
+	}
+	if payload == nil {
+		return nil
+	}
+	x, err := exif.Decode(bytes.NewReader(payload))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return nil
+	}
+	return x
+}
+
+// assertSamePixels 比较两张图片的像素
+func assertSamePixels(t *testing.T, got, want image.Image) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("尺寸 = %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := color.NRGBA64Model.Convert(got.At(x, y)), color.NRGBA64Model.Convert(want.At(x, y)); g != w {
+				t.Fatalf("像素 (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	files := []struct {
+		format string
+		data   []byte
+		// exif 格式是否可以保留 EXIF 字段
+		exif bool
+	}{
+		{"jpeg", testJPEG(t), true},
+		{"png", testPNG(t), true},
+		{"gif", testGIF(t), false},
+		{"webp", testWebP(), true},
+		{"tiff", testTIFF(), true},
+	}
+	policies := []string{config.MetadataPolicyStrip, config.MetadataPolicyOrientation, config.MetadataPolicyCopyright}
+
+	for _, file := range files {
+		// 测试数据本身包含需要删除的元数据
+		if !bytes.Contains(file.data, []byte(testSecret)) {
+			t.Fatalf("%s 测试数据不包含 XMP", file.format)
+		}
+		if x := readTestEXIF(t, file.format, file.data); file.exif {
+			if _, _, err := x.LatLong(); x == nil || err != nil {
+				t.Fatalf("%s 测试数据不包含 GPS 坐标: %v", file.format, err)
+			}
+		}
+		original, format, err := image.Decode(bytes.NewReader(file.data))
+		if err != nil || format != file.format {
+			t.Fatalf("解码 %s 测试数据: %s, %v", file.format, format, err)
+		}
+
+		for _, policy := range policies {
+			t.Run(file.format+"/"+policy, func(t *testing.T) {
+				stripped, err := StripMetadata(file.data, policy)
+				if err != nil {
+					t.Fatalf("StripMetadata: %v", err)
+				}
+				decoded, format, err := image.Decode(bytes.NewReader(stripped))
+				if err != nil || format != file.format {
+					t.Fatalf("解码删除元数据后的文件: %s, %v", format, err)
+				}
+				// 不重新编码，像素保持不变
+				assertSamePixels(t, decoded, original)
+
+				if bytes.Contains(stripped, []byte(testSecret)) {
+					t.Error("XMP 或注释没有删除")
+				}
+				x := readTestEXIF(t, file.format, stripped)
+				if x != nil {
+					if _, _, err := x.LatLong(); err == nil {
+						t.Error("GPS 坐标没有删除")
+					}
+				}
+				if !file.exif {
+					return
+				}
+
+				var (
+					orientation       int
+					copyright, artist string
+				)
+				if x != nil {
+					orientation, _ = exifInt(x, exif.Orientation)
+					copyright, artist = exifString(x, exif.Copyright), exifString(x, exif.Artist)
+				}
+				wantOrientation := 6
+				if policy == config.MetadataPolicyStrip {
+					wantOrientation = 0
+				}
+				if orientation != wantOrientation {
+					t.Errorf("方向 = %d, want %d", orientation, wantOrientation)
+				}
+
+				if policy == config.MetadataPolicyCopyright {
+					if copyright != testCopyright || artist != testArtist {
+						t.Errorf("版权 = %q, 作者 = %q", copyright, artist)
+					}
+				} else if copyright != "" || artist != "" || bytes.Contains(stripped, []byte(testCopyright)) {
+					t.Errorf("版权和作者没有删除: %q, %q", copyright, artist)
+				}
+			})
+		}
+	}
+}
+
+// TestStripTIFFSharedData 删除的标签与图像数据或保留的标签共用数据时，共用的部分不能清零
+func TestStripTIFFSharedData(t *testing.T) {
+	base := testTIFF()
+	order, pages, err := tiffPages(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pixels, copyright, copyrightCount uint32
+	offset := int(pages[0].offset)
+	for i := 0; i < int(order.Uint16(base[offset:])); i++ {
+		entry := base[offset+2+12*i:]
+		switch order.Uint16(entry) {
+		case 273:
+			pixels = order.Uint32(entry[8:])
+		case tiffCopyrightTag:
+			copyrightCount, copyright = order.Uint32(entry[4:]), order.Uint32(entry[8:])
+		}
+	}
+
+	// ImageDescription 指向图像数据，DocumentName 与版权共用同一段数据
+	shared := []testTIFFEntry{
+		{tag: 269, typ: 2, offset: copyright, count: copyrightCount},
+		{tag: 270, typ: 2, offset: pixels, count: 8 * 4 * 3},
+	}
+	data := testTIFF(shared...)
+	original, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("解码测试数据: %v", err)
+	}
+
+	stripped, err := StripMetadata(data, config.MetadataPolicyCopyright)
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("解码删除元数据后的文件: %v", err)
+	}
+	assertSamePixels(t, decoded, original)
+	if got := exifString(readTestEXIF(t, "tiff", stripped), exif.Copyright); got != testCopyright {
+		t.Errorf("版权 = %q, want %q", got, testCopyright)
+	}
+	if bytes.Contains(stripped, []byte(testSecret)) {
+		t.Error("XMP 没有删除")
+	}
+	// 原始数据不能被修改
+	if !bytes.Equal(data, testTIFF(shared...)) {
+		t.Error("StripMetadata 修改了传入的数据")
+	}
+}
+
+func TestPublicImageDataReencode(t *testing.T) {
+	// APP 段之间夹杂的多余字节无法按文件结构解析，但解码器可以跳过
+	data := testJPEG(t)
+	com := bytes.Index(data, []byte{0xff, 0xfe})
+	data = append(append(append([]byte(nil), data[:com]...), 0x00, 0x00), data[com:]...)
+	if _, err := StripMetadata(data, config.MetadataPolicyOrientation); err == nil {
+		t.Fatal("StripMetadata 应该无法解析测试数据")
+	}
+
+	public, format, err := PublicImageData(data, config.MetadataPolicyOrientation)
+	if err != nil {
+		t.Fatalf("PublicImageData: %v", err)
+	}
+	if format != "jpeg" {
+		t.Errorf("format = %q, want jpeg", format)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(public))
+	if err != nil {
+		t.Fatalf("解码重新编码的文件: %v", err)
+	}
+	// 重新编码时按 EXIF 方向旋转
+	if b := decoded.Bounds(); b.Dx() != 4 || b.Dy() != 8 {
+		t.Errorf("尺寸 = %v, want 4x8", b)
+	}
+	if bytes.Contains(public, []byte(testSecret)) || readTestEXIF(t, "jpeg", public) != nil {
+		t.Error("重新编码的文件仍包含元数据")
+	}
+
+	if _, _, err := PublicImageData([]byte("\xff\xd8\xff\xe0garbage"), config.MetadataPolicyOrientation); err == nil {
+		t.Error("无法解码的文件应该返回错误")
+	}
+}
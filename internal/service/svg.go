@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// defaultSVGRasterSize 没有配置栅格化尺寸时 SVG 栅格化后的最长边
+	defaultSVGRasterSize = 1024
+	// maxSVGDepth SVG 元素允许的最大嵌套层数，避免恶意文件耗尽资源
+	maxSVGDepth = 256
+)
+
+// svgUnsafeElements 清理 SVG 时连同子元素一起删除的元素，这些元素可以执行脚本或嵌入外部内容
+var svgUnsafeElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"object":        true,
+	"embed":         true,
+	"audio":         true,
+	"video":         true,
+	"handler":       true,
+	"listener":      true,
+}
+
+var (
+	// cssImportPattern CSS 中引入外部样式表的 @import 规则
+	cssImportPattern = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	// cssURLPattern CSS 和属性值中的 url() 引用
+	cssURLPattern = regexp.MustCompile(`(?i)url\(\s*(['"]?)\s*([^'")]*?)\s*['"]?\s*\)`)
+	// safeDataURIPattern 允许内嵌的 data URI，只允许位图
+	safeDataURIPattern = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp);`)
+	// svgTextEscaper 转义元素文本，保留换行和缩进
+	svgTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// isSVG 判断文件内容是否为 SVG，即根元素为 svg 的 XML 文档
+func isSVG(data []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return false
+	}
+	decoder := newSVGDecoder(data)
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// newSVGDecoder 创建解析 SVG 的 XML 解码器，支持声明了非 UTF-8 编码的文件
+func newSVGDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder
+}
+
+// sanitizeSVG 清理 SVG 中可以执行脚本或引用外部资源的内容，返回 UTF-8 编码的 SVG
+// 删除脚本等元素、事件处理属性、指向文档外部的链接和 CSS 引用，以及 DOCTYPE、注释和处理指令
+func sanitizeSVG(data []byte) ([]byte, error) {
+	decoder := newSVGDecoder(data)
+	out := bytes.NewBuffer(nil)
+	depth := 0
+	// skipDepth 正在跳过的不安全元素的层级，0 表示没有跳过
+	skipDepth := 0
+	inStyle := false
+	seenRoot := false
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 SVG 失败: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > maxSVGDepth {
+				return nil, errors.New("SVG 元素嵌套层数过多")
+			}
+			if depth == 1 {
+				if seenRoot || t.Name.Local != "svg" {
+					return nil, errors.New("根元素不是 svg")
+				}
+				seenRoot = true
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if svgUnsafeElements[strings.ToLower(t.Name.Local)] || isUnsafeAnimation(t) {
+				skipDepth = depth
+				continue
+			}
+			inStyle = t.Name.Local == "style"
+			out.WriteString("<")
+			out.WriteString(svgName(t.Name))
+			for _, attr := range t.Attr {
+				value, ok := sanitizeSVGAttr(attr)
+				if !ok {
+					continue
+				}
+				out.WriteString(" ")
+				out.WriteString(svgName(attr.Name))
+				out.WriteString(`="`)
+				xml.EscapeText(out, []byte(value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if depth == 0 {
+				return nil, errors.New("解析 SVG 失败: 结束标签不匹配")
+			}
+			if skipDepth > 0 {
+				if depth == skipDepth {
+					skipDepth = 0
+				}
+				depth--
+				continue
+			}
+			depth--
+			inStyle = false
+			out.WriteString("</")
+			out.WriteString(svgName(t.Name))
+			out.WriteString(">")
+		case xml.CharData:
+			// 根元素之外只允许空白
+			if depth == 0 || skipDepth > 0 {
+				continue
+			}
+			text := string(t)
+			if inStyle {
+				text = sanitizeCSS(text)
+			}
+			svgTextEscaper.WriteString(out, text)
+		}
+		// 注释、处理指令和 DOCTYPE（可能声明外部实体）全部丢弃
+	}
+
+	if !seenRoot || depth != 0 {
+		return nil, errors.New("解析 SVG 失败: 文档不完整")
+	}
+	return out.Bytes(), nil
+}
+
+// svgName 按原始前缀输出元素或属性名
+func svgName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// isUnsafeAnimation 判断是否为修改链接地址的动画元素，这类动画可以将链接改为 javascript: 地址
+func isUnsafeAnimation(start xml.StartElement) bool {
+	switch strings.ToLower(start.Name.Local) {
+	case "set", "animate":
+	default:
+		return false
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "attributeName" && strings.HasSuffix(strings.ToLower(attr.Value), "href") {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeSVGAttr 清理属性值，返回 false 表示删除该属性
+func sanitizeSVGAttr(attr xml.Attr) (string, bool) {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") {
+		return "", false
+	}
+	compact := strings.ToLower(strings.Join(strings.Fields(attr.Value), ""))
+	if strings.Contains(compact, "javascript:") || strings.Contains(compact, "vbscript:") {
+		return "", false
+	}
+	if name == "href" || name == "src" {
+		return attr.Value, isLocalReference(strings.TrimSpace(attr.Value))
+	}
+	if name == "style" || strings.Contains(compact, "url(") {
+		return sanitizeCSS(attr.Value), true
+	}
+	return attr.Value, true
+}
+
+// sanitizeCSS 删除 CSS 中的 @import 规则，并将指向文档外部的 url() 替换为 none
+func sanitizeCSS(css string) string {
+	css = cssImportPattern.ReplaceAllString(css, "")
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		target := cssURLPattern.FindStringSubmatch(match)[2]
+		if isLocalReference(target) {
+			return match
+		}
+		return "none"
+	})
+}
+
+// isLocalReference 判断链接是否指向文档内部的元素或内嵌的位图
+func isLocalReference(target string) bool {
+	return strings.HasPrefix(target, "#") || safeDataURIPattern.MatchString(target)
+}
+
+// rasterizeSVG 将 SVG 栅格化为最长边为 size 的图片，保持 viewBox 的宽高比
+func rasterizeSVG(data []byte, size int) (image.Image, error) {
+	if size <= 0 {
+		size = defaultSVGRasterSize
+	}
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("解析 SVG 失败: %w", err)
+	}
+	viewW, viewH := icon.ViewBox.W, icon.ViewBox.H
+	if !(viewW > 0) || !(viewH > 0) || math.IsInf(viewW, 0) || math.IsInf(viewH, 0) {
+		return nil, errors.New("SVG 缺少有效的 viewBox 或宽高")
+	}
+
+	width, height := size, size
+	if viewW > viewH {
+		height = maxInt(1, int(math.Round(float64(size)*viewH/viewW)))
+	} else {
+		width = maxInt(1, int(math.Round(float64(size)*viewW/viewH)))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize 每次从数据库读取的过期图片数量
+const purgeBatchSize = 100
+
+// TrashedImage 回收站中的图片
+type TrashedImage struct {
+	model.Image
+	// DeletedAt 移入回收站的时间
+	DeletedAt time.Time `json:"deleted_at"`
+	// PurgeAt 预计被永久删除的时间
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// ListTrash 列出回收站中的图片，最近删除的在前
+func (s *imageService) ListTrash(page, pageSize int) ([]TrashedImage, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	imagePtrs, total, err := s.imageRepo.ListDeletedImages(page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	images := make([]TrashedImage, len(imagePtrs))
+	for i, imgPtr := range imagePtrs {
+		deletedAt := imgPtr.DeletedAt.Time
+		images[i] = TrashedImage{
+			Image:     *imgPtr,
+			DeletedAt: deletedAt,
+			PurgeAt:   deletedAt.Add(s.trashConfig.Retention),
+		}
+	}
+
+	return images, total, nil
+}
+
+// RestoreImage 将回收站中的图片恢复
+func (s *imageService) RestoreImage(id uuid.UUID) (*model.Image, error) {
+	if err := s.imageRepo.RestoreImage(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		logrus.Errorf("恢复图片失败: %v", err)
+		return nil, err
+	}
+
+	image, err := s.imageRepo.GetImageByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("图片已从回收站恢复: %s", image.FileName)
+	return image, nil
+}
+
+// PurgeImage 永久删除回收站中的图片
+func (s *imageService) PurgeImage(id uuid.UUID) error {
+	image, err := s.imageRepo.GetDeletedImage(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrImageNotFound
+		}
+		return err
+	}
+	return s.purgeImage(image)
+}
+
+// PurgeExpiredImages 永久删除在回收站中超过保留期的图片，返回删除的数量
+func (s *imageService) PurgeExpiredImages() (int, error) {
+	if s.trashConfig.Retention <= 0 {
+		return 0, nil
+	}
+
+	before := time.Now().Add(-s.trashConfig.Retention)
+	purged := 0
+	for {
+		ids, err := s.imageRepo.ListExpiredImages(before, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+
+		for _, id := range ids {
+			image, err := s.imageRepo.GetDeletedImage(id)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// 已被恢复或手动永久删除
+				continue
+			}
+			if err != nil {
+				return purged, err
+			}
+			// 删除失败时停止本轮清理，图片仍在回收站中，下一次清理时重试
+			if err := s.purgeImage(image); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+}
+
+// NewTrashPurger 创建定期永久删除回收站中超过保留期的图片的后台任务
+func NewTrashPurger(imageService ImageService, interval time.Duration) *PeriodicTask {
+	return NewPeriodicTask(interval, func() {
+		purged, err := imageService.PurgeExpiredImages()
+		if err != nil {
+			logrus.Errorf("清理回收站失败: %v", err)
+		}
+		if purged > 0 {
+			logrus.Infof("已永久删除回收站中 %d 张过期图片", purged)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// stagedFile 一次上传需要写入对象存储的文件
+type stagedFile struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+// RecoveryReport 启动恢复的结果
+type RecoveryReport struct {
+	// Uploads 清理的未提交上传数量
+	Uploads int
+	// Blobs 删除的未被引用的文件数量
+	Blobs int
+	// TempFiles 删除的临时文件数量
+	TempFiles int
+}
+
+// storeUpload 先登记再写入一次上传的所有文件
+// 登记记录保证进程在写入过程中或提交数据库前崩溃时，已写入的文件可以在启动恢复时找到并删除
+func (s *imageService) storeUpload(uploadID uuid.UUID, files []stagedFile) error {
+	keys := make([]string, len(files))
+	for i, file := range files {
+		keys[i] = file.key
+	}
+	// 与删除文件前的复查互斥，登记之后其他请求不会再删除这些文件
+	s.blobMu.Lock()
+	err := s.imageRepo.StageBlobs(uploadID, keys)
+	s.blobMu.Unlock()
+	if err != nil {
+		logrus.Errorf("登记上传文件失败: %v", err)
+		return err
+	}
+
+	for _, file := range files {
+		if err := s.blobs.Put(file.key, bytes.NewReader(file.data), int64(len(file.data)), file.contentType); err != nil {
+			logrus.Errorf("保存文件 %s 失败: %v", file.key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// discardUpload 删除一次未提交的上传已写入且没有被其他图片引用的文件，再删除登记记录
+// 文件删除失败时保留登记记录，下次启动恢复时重试
+func (s *imageService) discardUpload(uploadID uuid.UUID) (int, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	keys, err := s.imageRepo.UncommittedBlobs(uploadID)
+	if err != nil {
+		return 0, fmt.Errorf("查询未提交的文件失败: %w", err)
+	}
+
+	for _, key := range keys {
+		if err := s.blobs.Delete(key); err != nil {
+			return 0, fmt.Errorf("删除文件 %s 失败: %w", key, err)
+		}
+	}
+
+	if err := s.imageRepo.DeleteStagedUpload(uploadID); err != nil {
+		return 0, fmt.Errorf("删除上传登记记录失败: %w", err)
+	}
+	return len(keys), nil
+}
+
+// deleteUnreferenced 删除 keys 中没有被任何图片引用、也没有被未提交的上传登记的文件，返回实际删除的文件
+// 文件按内容哈希命名，引用计数归零之后，内容相同的上传可能已经重新登记并写入同一个文件，因此删除之前在 blobMu 下复查；
+// storeUpload 登记文件时同样持有 blobMu，复查之后才登记的上传会在删除完成之后写入文件
+func (s *imageService) deleteUnreferenced(keys []string) ([]string, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	unreferenced, err := s.imageRepo.UnreferencedBlobs(keys)
+	if err != nil {
+		return nil, fmt.Errorf("复查文件引用失败: %w", err)
+	}
+	for i, key := range unreferenced {
+		if err := s.blobs.Delete(key); err != nil {
+			return unreferenced[:i], fmt.Errorf("删除文件 %s 失败: %w", key, err)
+		}
+	}
+	if skipped := len(keys) - len(unreferenced); skipped > 0 {
+		logrus.Infof("%d 个文件已被新的上传重新引用，不删除", skipped)
+	}
+	return unreferenced, nil
+}
+
+// abortUpload 上传失败时清理已写入的文件，清理失败只记录日志
+func (s *imageService) abortUpload(uploadID uuid.UUID) {
+	if _, err := s.discardUpload(uploadID); err != nil {
+		logrus.Errorf("清理未完成的上传 %s 失败，将在下次启动时重试: %v", uploadID, err)
+	}
+}
+
+// RecoverUploads 清理上次运行中崩溃时残留的未提交上传和临时文件，应在开始接受请求之前调用
+func (s *imageService) RecoverUploads() (*RecoveryReport, error) {
+	report := &RecoveryReport{}
+	startedAt := time.Now()
+
+	uploadIDs, err := s.imageRepo.ListStagedUploads(startedAt)
+	if err != nil {
+		return nil, fmt.Errorf("查询未提交的上传失败: %w", err)
+	}
+	for _, uploadID := range uploadIDs {
+		removed, err := s.discardUpload(uploadID)
+		if err != nil {
+			return report, fmt.Errorf("清理未提交的上传 %s 失败: %w", uploadID, err)
+		}
+		report.Uploads++
+		report.Blobs += removed
+	}
+
+	if cleaner, ok := s.blobs.(storage.TempCleaner); ok {
+		removed, err := cleaner.CleanTemp(startedAt)
+		if err != nil {
+			return report, fmt.Errorf("清理临时文件失败: %w", err)
+		}
+		report.TempFiles = removed
+	}
+
+	return report, nil
+}
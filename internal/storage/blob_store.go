@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("对象不存在")
+
+// ErrInvalidKey 对象键不合法
+var ErrInvalidKey = errors.New("无效的对象键")
+
+// BlobInfo 对象元信息
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore 对象存储接口，图片文件的读写都通过该接口完成，以便多副本部署时共享存储
+type BlobStore interface {
+	// Put 写入对象，size 为内容长度，未知时传 -1
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭返回的 ReadCloser
+	Get(key string) (io.ReadCloser, BlobInfo, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(key string) error
+	// Stat 获取对象元信息
+	Stat(key string) (BlobInfo, error)
+	// List 遍历指定前缀下的所有对象，fn 返回错误时停止遍历
+	List(prefix string, fn func(BlobInfo) error) error
+}
+
+// RangeReader 支持按范围读取的对象存储实现该接口，只需要对象的一部分时不必传输整个对象
+type RangeReader interface {
+	// GetRange 从 offset 开始读取对象，length 小于 0 时读到对象末尾，对象比请求的范围短时只返回实际存在的部分
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// TempCleaner 写入时使用临时文件的对象存储实现该接口，用于清理进程崩溃后残留的临时文件
+type TempCleaner interface {
+	// CleanTemp 删除修改时间早于 before 的临时文件，返回删除的数量
+	CleanTemp(before time.Time) (int, error)
+}
+
+// New 根据配置创建对象存储，配置了密钥文件时对写入的对象加密
+func New(cfg config.StorageConfig) (BlobStore, error) {
+	var (
+		store BlobStore
+		err   error
+	)
+	switch cfg.Backend {
+	case "", "local":
+		store, err = NewLocalStore(cfg.ImageDir)
+	case "s3":
+		store, err = NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s", cfg.Backend)
+	}
+	if err != nil || cfg.Encryption.KeyFile == "" {
+		return store, err
+	}
+
+	keyring, err := LoadKeyring(cfg.Encryption.KeyFile, cfg.Encryption.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedStore(store, keyring), nil
+}
+
+// cleanKey 校验并规范化对象键，禁止绝对路径和跳出存储根目录
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
+
+// ContentTypeByExtension 根据扩展名推断图片的 Content-Type
+func ContentTypeByExtension(ext string) string {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	case "webp":
+		return "image/webp"
+	case "bmp":
+		return "image/bmp"
+	case "tif", "tiff":
+		return "image/tiff"
+	case "svg":
+		return "image/svg+xml"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// KeyRotator 加密存储实现该接口，用于记录图片使用的密钥和轮换密钥
+type KeyRotator interface {
+	// ActiveKeyID 返回加密新对象使用的密钥ID
+	ActiveKeyID() string
+	// KeyIDOf 返回对象使用的密钥ID，只读取加密头，明文对象返回空字符串
+	KeyIDOf(key string) (string, error)
+	// Reencrypt 使用当前密钥重新加密对象，返回对象原来使用的密钥ID，启用加密前写入的明文对象返回空字符串
+	// 对象已经使用当前密钥加密时不重写
+	Reencrypt(key string) (string, error)
+}
+
+// encryptedStore 在写入前使用 AES-GCM 分段加密、读取时逐段解密的对象存储，对象键作为附加数据参与认证
+// 启用加密前写入的明文对象按原样读取，可以通过 Reencrypt 加密
+type encryptedStore struct {
+	inner   BlobStore
+	keyring *Keyring
+}
+
+// NewEncryptedStore 创建加密对象存储
+func NewEncryptedStore(inner BlobStore, keyring *Keyring) BlobStore {
+	return &encryptedStore{inner: inner, keyring: keyring}
+}
+
+// Put 逐段加密后写入对象，size 为明文长度
+func (s *encryptedStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	sealer, err := s.keyring.newSegmentSealer([]byte(key))
+	if err != nil {
+		return err
+	}
+	sealedLen := int64(-1)
+	if size >= 0 {
+		sealedLen = sealedSize(len(sealer.header), size)
+	}
+	return s.inner.Put(key, newSealingReader(r, sealer), sealedLen, contentType)
+}
+
+// Get 读取对象并逐段解密，BlobInfo.Size 为明文长度
+// 底层对象可以随机读取或底层存储支持按范围读取时，返回的 ReadCloser 实现了 io.Seeker
+func (s *encryptedStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	reader, info, err := s.inner.Get(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	header, err := readEncryptionHeader(reader)
+	switch {
+	case errors.Is(err, ErrNotEncrypted):
+		// 明文对象按原样返回已读取的开头和剩余部分
+		plain, err := rewind(reader, header)
+		if err != nil {
+			reader.Close()
+			return nil, BlobInfo{}, err
+		}
+		return plain, info, nil
+	case err != nil:
+		reader.Close()
+		return nil, BlobInfo{}, fmt.Errorf("读取对象 %s 失败: %w", key, err)
+	case bytes.HasPrefix(header, encryptionMagic):
+		return s.openWhole(key, reader, header, info)
+	}
+
+	opener, err := s.keyring.segmentOpener(header, []byte(key))
+	if err != nil {
+		reader.Close()
+		return nil, BlobInfo{}, fmt.Errorf("读取对象 %s 失败: %w", key, err)
+	}
+	opened, err := newOpeningReader(reader, opener, info.Size)
+	if err != nil {
+		reader.Close()
+		return nil, BlobInfo{}, fmt.Errorf("读取对象 %s 失败: %w", key, err)
+	}
+	info.Size = opened.size
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		opened.reopen = func(offset int64) (io.ReadCloser, error) {
+			_, err := seeker.Seek(offset, io.SeekStart)
+			return reader, err
+		}
+	} else if ranger, ok := s.inner.(RangeReader); ok {
+		opened.reopen = func(offset int64) (io.ReadCloser, error) {
+			return ranger.GetRange(key, offset, -1)
+		}
+	}
+	if opened.reopen == nil {
+		return opened, info, nil
+	}
+	return seekableOpeningReader{opened}, info, nil
+}
+
+// openWhole 读取并解密启用分段加密之前整体加密的对象，这种对象需要完整读入内存后解密
+func (s *encryptedStore) openWhole(key string, reader io.ReadCloser, header []byte, info BlobInfo) (io.ReadCloser, BlobInfo, error) {
+	defer reader.Close()
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	plaintext, err := s.keyring.Open(append(header, rest...), []byte(key))
+	if err != nil {
+		return nil, BlobInfo{}, fmt.Errorf("读取对象 %s 失败: %w", key, err)
+	}
+	info.Size = int64(len(plaintext))
+	return readSeekNopCloser{bytes.NewReader(plaintext)}, info, nil
+}
+
+// rewind 返回从头读取明文对象的 ReadCloser，prefix 为已经读取的开头
+func rewind(reader io.ReadCloser, prefix []byte) (io.ReadCloser, error) {
+	if seeker, ok := reader.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+	return limitedReadCloser{Reader: io.MultiReader(bytes.NewReader(prefix), reader), Closer: reader}, nil
+}
+
+// Delete 删除对象
+func (s *encryptedStore) Delete(key string) error {
+	return s.inner.Delete(key)
+}
+
+// Stat 获取对象元信息，需要读取加密头来计算明文长度
+func (s *encryptedStore) Stat(key string) (BlobInfo, error) {
+	info, err := s.inner.Stat(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return s.plainInfo(info)
+}
+
+// List 遍历指定前缀下的所有对象，BlobInfo.Size 为明文长度
+func (s *encryptedStore) List(prefix string, fn func(BlobInfo) error) error {
+	return s.inner.List(prefix, func(info BlobInfo) error {
+		plain, err := s.plainInfo(info)
+		if err != nil {
+			return err
+		}
+		return fn(plain)
+	})
+}
+
+// CleanTemp 清理底层存储残留的临时文件
+func (s *encryptedStore) CleanTemp(before time.Time) (int, error) {
+	if cleaner, ok := s.inner.(TempCleaner); ok {
+		return cleaner.CleanTemp(before)
+	}
+	return 0, nil
+}
+
+// ActiveKeyID 返回加密新对象使用的密钥ID
+func (s *encryptedStore) ActiveKeyID() string {
+	return s.keyring.ActiveKeyID()
+}
+
+// KeyIDOf 返回对象使用的密钥ID
+func (s *encryptedStore) KeyIDOf(key string) (string, error) {
+	header, err := s.readHeader(key)
+	if err != nil {
+		return "", err
+	}
+	keyID, err := KeyID(header)
+	if errors.Is(err, ErrNotEncrypted) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("读取对象 %s 失败: %w", key, err)
+	}
+	return keyID, nil
+}
+
+// Seal 使用当前密钥加密数据，供需要加密保存的其他数据（如处理结果缓存）使用
+func (s *encryptedStore) Seal(plaintext, aad []byte) ([]byte, error) {
+	return s.keyring.Seal(plaintext, aad)
+}
+
+// Open 解密 Seal 生成的数据
+func (s *encryptedStore) Open(data, aad []byte) ([]byte, error) {
+	return s.keyring.Open(data, aad)
+}
+
+// Reencrypt 使用当前密钥重新加密对象，逐段解密后重新加密写入，不把整个对象读入内存
+func (s *encryptedStore) Reencrypt(key string) (string, error) {
+	previous, err := s.KeyIDOf(key)
+	if err != nil || previous == s.keyring.ActiveKeyID() {
+		return previous, err
+	}
+
+	reader, info, err := s.Get(key)
+	if err != nil {
+		return previous, err
+	}
+	defer reader.Close()
+	if err := s.Put(key, reader, info.Size, info.ContentType); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
+// plainInfo 读取对象的加密头，将元信息中的长度换算为明文长度，明文对象的长度不变
+func (s *encryptedStore) plainInfo(info BlobInfo) (BlobInfo, error) {
+	header, err := s.readHeader(info.Key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	// 加密头损坏时保留原始长度，读取对象时会返回解密失败
+	_, headerLen, err := parseHeader(header)
+	switch {
+	case err != nil:
+	case bytes.HasPrefix(header, segmentedMagic):
+		if size, _, err := openedSize(headerLen, info.Size); err == nil {
+			info.Size = size
+		}
+	default:
+		info.Size -= int64(headerLen + tagSize)
+	}
+	return info, nil
+}
+
+// readHeader 读取对象开头可能包含加密头的部分，对象较短时返回全部内容
+// 底层存储支持按范围读取时只请求这一部分
+func (s *encryptedStore) readHeader(key string) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	if ranger, ok := s.inner.(RangeReader); ok {
+		reader, err = ranger.GetRange(key, 0, maxHeaderSize+tagSize)
+	} else {
+		reader, _, err = s.inner.Get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	header := make([]byte, maxHeaderSize+tagSize)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// readEncryptionHeader 从 r 读取完整的加密头，没有加密头时返回 ErrNotEncrypted 和已读取的数据
+func readEncryptionHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, len(encryptionMagic)+1, maxHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header[:n], encryptionMagic) && !bytes.HasPrefix(header[:n], segmentedMagic) {
+		return header[:n], ErrNotEncrypted
+	}
+	if n < len(header) {
+		return nil, ErrDecrypt
+	}
+
+	idLen := int(header[n-1])
+	randomSize := nonceSize
+	if bytes.HasPrefix(header, segmentedMagic) {
+		randomSize = noncePrefixSize
+	}
+	if idLen == 0 || n+idLen+randomSize > maxHeaderSize {
+		return nil, ErrDecrypt
+	}
+	header = header[:n+idLen+randomSize]
+	if _, err := io.ReadFull(r, header[n:]); err != nil {
+		return nil, ErrDecrypt
+	}
+	return header, nil
+}
+
+// readSeekNopCloser 为内存中的数据提供空的 Close 方法
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+// Close 实现 io.Closer
+func (readSeekNopCloser) Close() error {
+	return nil
+}
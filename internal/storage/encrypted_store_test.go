@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestKeyring 创建包含 old 和 new 两个密钥的密钥文件，activeID 为加密新数据使用的密钥
+func newTestKeyring(t *testing.T, activeID string) *Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	var lines []string
+	for _, id := range []string{"old", "new"} {
+		key := make([]byte, 32)
+		// 使用固定的密钥，多次加载同一个测试密钥文件得到相同的密钥
+		copy(key, id)
+		lines = append(lines, id+" "+base64.StdEncoding.EncodeToString(key))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := LoadKeyring(path, activeID)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	return keyring
+}
+
+// newTestEncryptedStore 创建以本地目录为底层存储的加密存储
+func newTestEncryptedStore(t *testing.T, activeID string) (*encryptedStore, BlobStore) {
+	t.Helper()
+	inner, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEncryptedStore(inner, newTestKeyring(t, activeID)).(*encryptedStore), inner
+}
+
+// randomBytes 返回 n 字节随机数据
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readAllBlob 读取对象的全部内容
+func readAllBlob(store BlobStore, key string) ([]byte, BlobInfo, error) {
+	r, info, err := store.Get(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return data, info, err
+}
+
+// onlyReader 隐藏底层 Reader 的其他方法，模拟不能随机读取的底层存储
+type onlyReader struct {
+	io.ReadCloser
+}
+
+// sequentialStore 返回的对象不实现 io.Seeker，也不支持按范围读取
+type sequentialStore struct {
+	BlobStore
+}
+
+func (s sequentialStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	r, info, err := s.BlobStore.Get(key)
+	return onlyReader{r}, info, err
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	store, inner := newTestEncryptedStore(t, "")
+	sizes := []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 100}
+
+	for _, size := range sizes {
+		data := randomBytes(t, size)
+		for _, known := range []bool{true, false} {
+			length := int64(size)
+			if !known {
+				length = -1
+			}
+			if err := store.Put("images/a.jpg", bytes.NewReader(data), length, "image/jpeg"); err != nil {
+				t.Fatalf("Put(%d): %v", size, err)
+			}
+
+			raw, _, err := readAllBlob(inner, "images/a.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(raw, segmentedMagic) || size >= 16 && bytes.Contains(raw, data) {
+				t.Fatalf("大小 %d 的对象没有分段加密", size)
+			}
+			_, headerLen, _ := parseHeader(raw)
+			if want := sealedSize(headerLen, int64(size)); int64(len(raw)) != want {
+				t.Errorf("大小 %d 加密后长度 = %d, want %d", size, len(raw), want)
+			}
+
+			got, info, err := readAllBlob(store, "images/a.jpg")
+			if err != nil {
+				t.Fatalf("Get(%d): %v", size, err)
+			}
+			if !bytes.Equal(got, data) || info.Size != int64(size) {
+				t.Errorf("大小 %d 解密结果不一致，长度 %d，BlobInfo.Size %d", size, len(got), info.Size)
+			}
+
+			stat, err := store.Stat("images/a.jpg")
+			if err != nil || stat.Size != int64(size) {
+				t.Errorf("Stat(%d) = %+v, %v", size, stat, err)
+			}
+		}
+	}
+}
+
+func TestEncryptedStoreSeek(t *testing.T) {
+	store, inner := newTestEncryptedStore(t, "")
+	data := randomBytes(t, 3*segmentSize+100)
+	if err := store.Put("images/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := []struct{ offset, length int64 }{
+		{0, 10},
+		{segmentSize - 5, 10},
+		{2*segmentSize + 7, segmentSize},
+		{int64(len(data)) - 3, 3},
+		{10, 5},
+	}
+	for name, backend := range map[string]BlobStore{
+		"本地文件":  store,
+		"按范围读取": NewEncryptedStore(rangeOnlyStore{inner}, store.keyring),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, _, err := backend.Get("images/a.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			seeker, ok := r.(io.ReadSeeker)
+			if !ok {
+				t.Fatal("返回的对象没有实现 io.Seeker")
+			}
+			if end, err := seeker.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+				t.Fatalf("Seek(0, SeekEnd) = %d, %v", end, err)
+			}
+			for _, rg := range ranges {
+				if _, err := seeker.Seek(rg.offset, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				got := make([]byte, rg.length)
+				if _, err := io.ReadFull(seeker, got); err != nil {
+					t.Fatalf("读取 %d+%d: %v", rg.offset, rg.length, err)
+				}
+				if !bytes.Equal(got, data[rg.offset:rg.offset+rg.length]) {
+					t.Errorf("读取 %d+%d 的内容不一致", rg.offset, rg.length)
+				}
+			}
+		})
+	}
+
+	// 底层存储不能随机读取时只能顺序读取
+	sequential := NewEncryptedStore(sequentialStore{inner}, store.keyring)
+	r, _, err := sequential.Get("images/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, ok := r.(io.Seeker); ok {
+		t.Error("不能随机读取的底层存储返回了 io.Seeker")
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("顺序读取结果不一致: %v", err)
+	}
+}
+
+// rangeOnlyStore 返回的对象不实现 io.Seeker，但底层存储支持按范围读取，模拟 S3
+type rangeOnlyStore struct {
+	BlobStore
+}
+
+func (s rangeOnlyStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	r, info, err := s.BlobStore.Get(key)
+	return onlyReader{r}, info, err
+}
+
+func (s rangeOnlyStore) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	r, err := s.BlobStore.(RangeReader).GetRange(key, offset, length)
+	return onlyReader{r}, err
+}
+
+func TestEncryptedStoreTampering(t *testing.T) {
+	store, inner := newTestEncryptedStore(t, "")
+	data := randomBytes(t, 2*segmentSize+10)
+	if err := store.Put("images/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := readAllBlob(inner, "images/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, headerLen, _ := parseHeader(raw)
+
+	// swap 交换第一段和第二段密文
+	swapped := append([]byte(nil), raw...)
+	first := swapped[headerLen : headerLen+sealedSegmentSize]
+	second := append([]byte(nil), swapped[headerLen+sealedSegmentSize:headerLen+2*sealedSegmentSize]...)
+	copy(swapped[headerLen+sealedSegmentSize:], first)
+	copy(swapped[headerLen:], second)
+
+	flipped := append([]byte(nil), raw...)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := map[string][]byte{
+		"修改最后一段": flipped,
+		"交换两段":   swapped,
+		"截掉最后一段": raw[:headerLen+2*sealedSegmentSize],
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := inner.Put("images/a.jpg", bytes.NewReader(tampered), int64(len(tampered)), "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := readAllBlob(store, "images/a.jpg"); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("读取被篡改的对象错误 = %v, want ErrDecrypt", err)
+			}
+		})
+	}
+
+	// 复制到其他对象键的密文无法解密
+	if err := inner.Put("images/b.jpg", bytes.NewReader(raw), int64(len(raw)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readAllBlob(store, "images/b.jpg"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("读取复制的对象错误 = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestEncryptedStoreLegacyObjects(t *testing.T) {
+	store, inner := newTestEncryptedStore(t, "old")
+	plain := []byte("plain object written before encryption")
+	whole := randomBytes(t, 1000)
+
+	if err := inner.Put("images/plain.jpg", bytes.NewReader(plain), int64(len(plain)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := store.keyring.Seal(whole, []byte("images/whole.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Put("images/whole.jpg", bytes.NewReader(sealed), int64(len(sealed)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string][]byte{"images/plain.jpg": plain, "images/whole.jpg": whole} {
+		got, info, err := readAllBlob(store, key)
+		if err != nil || !bytes.Equal(got, want) || info.Size != int64(len(want)) {
+			t.Errorf("读取 %s: %v, 长度 %d，BlobInfo.Size %d", key, err, len(got), info.Size)
+		}
+		stat, err := store.Stat(key)
+		if err != nil || stat.Size != int64(len(want)) {
+			t.Errorf("Stat(%s) = %+v, %v", key, stat, err)
+		}
+	}
+
+	// 轮换密钥后重新加密为分段格式
+	rotated := NewEncryptedStore(inner, newTestKeyring(t, "new")).(*encryptedStore)
+	for key, want := range map[string]string{"images/plain.jpg": "", "images/whole.jpg": "old"} {
+		previous, err := rotated.Reencrypt(key)
+		if err != nil || previous != want {
+			t.Errorf("Reencrypt(%s) = %q, %v, want %q", key, previous, err, want)
+		}
+		if keyID, err := rotated.KeyIDOf(key); err != nil || keyID != "new" {
+			t.Errorf("KeyIDOf(%s) = %q, %v", key, keyID, err)
+		}
+		raw, _, _ := readAllBlob(inner, key)
+		if !bytes.HasPrefix(raw, segmentedMagic) {
+			t.Errorf("%s 没有重新加密为分段格式", key)
+		}
+	}
+	if got, _, err := readAllBlob(rotated, "images/whole.jpg"); err != nil || !bytes.Equal(got, whole) {
+		t.Errorf("重新加密后读取结果不一致: %v", err)
+	}
+}
+
+func TestEncryptedStoreList(t *testing.T) {
+	store, _ := newTestEncryptedStore(t, "")
+	sizes := map[string]int{"images/a.jpg": 0, "images/b.jpg": 10, "images/c.jpg": segmentSize + 1}
+	for key, size := range sizes {
+		if err := store.Put(key, bytes.NewReader(randomBytes(t, size)), int64(size), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := store.List("images/", func(info BlobInfo) error {
+		if want := int64(sizes[info.Key]); info.Size != want {
+			t.Errorf("List %s 长度 = %d, want %d", info.Key, info.Size, want)
+		}
+		delete(sizes, info.Key)
+		return nil
+	})
+	if err != nil || len(sizes) != 0 {
+		t.Errorf("List: %v, 未列出 %v", err, sizes)
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrNotEncrypted 数据没有加密头，是启用加密之前写入的明文
+var ErrNotEncrypted = errors.New("数据未加密")
+
+// ErrUnknownKey 数据使用的密钥不在密钥文件中
+var ErrUnknownKey = errors.New("未知的加密密钥")
+
+// ErrDecrypt 解密失败，密钥不正确或数据被篡改
+var ErrDecrypt = errors.New("解密失败")
+
+// encryptionMagic 整体加密数据的前缀，后面依次是密钥ID长度（1字节）、密钥ID和 12 字节随机数
+// Seal 使用这种格式，对象存储中的文件使用 segmentedMagic 开头的分段加密格式，启用分段加密之前写入的文件仍可读取
+var encryptionMagic = []byte{'I', 'S', 'E', 1}
+
+// keyIDPattern 合法的密钥ID
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	// maxHeaderSize 加密头的最大长度
+	maxHeaderSize = 4 + 1 + 64 + nonceSize
+)
+
+// Keyring 从密钥文件加载的 AES-GCM 密钥，新数据使用当前密钥加密，解密时按数据中记录的密钥ID选择密钥
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// LoadKeyring 加载密钥文件，每行一个密钥，格式为 `密钥ID base64编码的密钥`，密钥长度为 16、24 或 32 字节，# 开头的行为注释
+// activeID 为空时使用文件中最后一个密钥加密新数据，轮换密钥时将新密钥追加到文件末尾即可
+func LoadKeyring(path, activeID string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+	defer file.Close()
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !keyIDPattern.MatchString(fields[0]) {
+			return nil, fmt.Errorf("密钥文件第 %d 行格式错误", lineNo)
+		}
+		if _, ok := k.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("密钥文件第 %d 行密钥ID重复: %s", lineNo, fields[0])
+		}
+		raw, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("密钥文件第 %d 行密钥不是有效的 base64: %w", lineNo, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("密钥文件第 %d 行密钥长度错误: %w", lineNo, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[fields[0]] = aead
+		k.active = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("密钥文件中没有密钥: %s", path)
+	}
+	if activeID != "" {
+		if _, ok := k.keys[activeID]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, activeID)
+		}
+		k.active = activeID
+	}
+	return k, nil
+}
+
+// ActiveKeyID 返回加密新数据使用的密钥ID
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Seal 使用当前密钥加密数据，aad 为需要一并认证的附加数据（如对象键），解密时必须提供相同的值
+func (k *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+	header := make([]byte, 0, maxHeaderSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, byte(len(k.active)))
+	header = append(header, k.active...)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %w", err)
+	}
+	header = append(header, nonce...)
+
+	out := make([]byte, len(header), len(header)+len(plaintext)+tagSize)
+	copy(out, header)
+	// 加密头同时作为附加数据，防止替换数据中记录的密钥ID
+	return k.keys[k.active].Seal(out, nonce, plaintext, append(header, aad...)), nil
+}
+
+// Open 解密 Seal 生成的数据，数据没有加密头时返回 ErrNotEncrypted
+func (k *Keyring) Open(data, aad []byte) ([]byte, error) {
+	keyID, headerLen, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	// 分段加密的数据需要逐段解密
+	if bytes.HasPrefix(data, segmentedMagic) {
+		return nil, ErrDecrypt
+	}
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	header := data[:headerLen]
+	nonce := header[headerLen-nonceSize:]
+	plaintext, err := aead.Open(nil, nonce, data[headerLen:], append(header[:headerLen:headerLen], aad...))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// KeyID 返回数据使用的密钥ID，数据没有加密头时返回 ErrNotEncrypted
+func KeyID(data []byte) (string, error) {
+	keyID, _, err := parseHeader(data)
+	return keyID, err
+}
+
+// parseHeader 解析加密头，返回密钥ID和加密头长度，数据不足以包含加密头和一个认证标签时返回 ErrDecrypt
+func parseHeader(data []byte) (string, int, error) {
+	keyID, headerLen, err := splitHeader(data)
+	if err == nil && len(data) < headerLen+tagSize {
+		return "", 0, ErrDecrypt
+	}
+	return keyID, headerLen, err
+}
+
+// splitHeader 解析 data 开头的加密头，data 可以只包含加密头
+// 整体加密和分段加密的加密头只有最后的随机数长度不同
+func splitHeader(data []byte) (string, int, error) {
+	var randomSize int
+	switch {
+	case bytes.HasPrefix(data, encryptionMagic):
+		randomSize = nonceSize
+	case bytes.HasPrefix(data, segmentedMagic):
+		randomSize = noncePrefixSize
+	default:
+		return "", 0, ErrNotEncrypted
+	}
+	if len(data) < len(encryptionMagic)+1 {
+		return "", 0, ErrDecrypt
+	}
+	idLen := int(data[len(encryptionMagic)])
+	headerLen := len(encryptionMagic) + 1 + idLen + randomSize
+	if idLen == 0 || len(data) < headerLen {
+		return "", 0, ErrDecrypt
+	}
+	return string(data[len(encryptionMagic)+1 : len(encryptionMagic)+1+idLen]), headerLen, nil
+}
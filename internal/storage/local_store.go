@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tempFilePrefix 写入过程中临时文件的文件名前缀
+const tempFilePrefix = ".tmp-"
+
+// localStore 基于本地文件系统的对象存储，对象键即相对于根目录的路径
+type localStore struct {
+	root string
+}
+
+// NewLocalStore 创建本地文件系统对象存储
+func NewLocalStore(root string) (BlobStore, error) {
+	// 确保存储目录存在
+	if err := os.MkdirAll(root, 0755); err != nil {
+		logrus.Errorf("创建存储目录失败: %v", err)
+		return nil, err
+	}
+
+	return &localStore{root: root}, nil
+}
+
+// Put 写入对象，先写入同目录下的临时文件并同步到磁盘，再重命名为目标文件
+// 读取方不会看到写了一半的文件，进程崩溃时只会残留临时文件
+func (s *localStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// CreateTemp 创建的文件权限为 0600，与直接创建的文件保持一致
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Get 读取对象，返回的 *os.File 同时实现了 io.Seeker
+func (s *localStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, BlobInfo{}, mapNotExist(err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, BlobInfo{}, err
+	}
+
+	return file, s.blobInfo(key, info), nil
+}
+
+// GetRange 从 offset 开始读取对象，length 小于 0 时读到文件末尾
+func (s *localStore) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	file, _, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	f := file.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return limitedReadCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// limitedReadCloser 只读取底层数据的一部分，关闭时关闭底层数据
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Delete 删除对象
+func (s *localStore) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stat 获取对象元信息
+func (s *localStore) Stat(key string) (BlobInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return BlobInfo{}, mapNotExist(err)
+	}
+	return s.blobInfo(key, info), nil
+}
+
+// List 遍历指定前缀下的所有对象
+func (s *localStore) List(prefix string, fn func(BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isTempFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(s.blobInfo(key, info))
+	})
+}
+
+// CleanTemp 删除修改时间早于 before 的临时文件，这些文件是写入过程中进程崩溃留下的
+func (s *localStore) CleanTemp(before time.Time) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTempFile(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// path 将对象键转换为本地文件路径
+func (s *localStore) path(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+// blobInfo 根据文件信息构造对象元信息
+func (s *localStore) blobInfo(key string, info fs.FileInfo) BlobInfo {
+	return BlobInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: ContentTypeByExtension(path.Ext(key)),
+		ModTime:     info.ModTime(),
+	}
+}
+
+// isTempFile 判断文件是否为写入过程中的临时文件
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix)
+}
+
+// mapNotExist 将文件不存在的错误转换为 ErrNotFound
+func mapNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+)
+
+// s3RequestTimeout 单个 S3 请求的超时时间
+const s3RequestTimeout = 5 * time.Minute
+
+// s3Store S3 兼容对象存储，使用 Signature Version 4 签名的 REST 请求访问
+type s3Store struct {
+	cfg      config.S3Config
+	endpoint *url.URL
+	signer   V4Signer
+	client   *http.Client
+}
+
+// NewS3Store 创建 S3 兼容对象存储
+func NewS3Store(cfg config.S3Config) (BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 存储需要配置 endpoint 和 bucket")
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 S3 endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("无效的 S3 endpoint: %s", cfg.Endpoint)
+	}
+
+	return &s3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		signer: V4Signer{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Region:          cfg.Region,
+			Service:         "s3",
+		},
+		client: &http.Client{Timeout: s3RequestTimeout},
+	}, nil
+}
+
+// Put 写入对象，已知长度时直接流式上传
+func (s *s3Store) Put(key string, r io.Reader, size int64, contentType string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	// S3 要求 PUT 请求带 Content-Length，长度未知时先读入内存
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+		size = int64(len(data))
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, UnsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get 读取对象
+func (s *s3Store) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return resp.Body, blobInfoFromHeader(key, resp), nil
+}
+
+// GetRange 使用 Range 请求头读取对象的一部分
+func (s *s3Store) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	// offset 超出对象长度时没有可读取的内容
+	if errors.Is(err, errInvalidRange) {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 不支持 Range 的服务返回整个对象，跳过 offset 之前的部分
+	if resp.StatusCode != http.StatusPartialContent && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length >= 0 && resp.StatusCode != http.StatusPartialContent {
+		return limitedReadCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+// Delete 删除对象
+func (s *s3Store) Delete(key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stat 获取对象元信息
+func (s *s3Store) Stat(key string) (BlobInfo, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+	return blobInfoFromHeader(key, resp), nil
+}
+
+// listBucketResult ListObjectsV2 响应
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List 使用 ListObjectsV2 分页遍历指定前缀下的所有对象
+func (s *s3Store) List(prefix string, fn func(BlobInfo) error) error {
+	token := ""
+	for {
+		u := s.bucketURL()
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.fullKey(prefix))
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("解析 S3 列表响应失败: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, s.keyPrefix())
+			if err := fn(BlobInfo{
+				Key:         key,
+				Size:        object.Size,
+				ContentType: ContentTypeByExtension(path.Ext(key)),
+				ModTime:     object.LastModified,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// errInvalidRange Range 请求的起始位置超出对象长度
+var errInvalidRange = errors.New("请求的范围超出对象长度")
+
+// s3Error S3 错误响应
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do 签名并发送请求，非 2xx 响应转换为错误
+func (s *s3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.signer.Sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return nil, errInvalidRange
+	}
+
+	var apiErr s3Error
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		return nil, fmt.Errorf("S3 请求失败 %s %s: %d %s: %s", req.Method, req.URL.Path, resp.StatusCode, apiErr.Code, apiErr.Message)
+	}
+	return nil, fmt.Errorf("S3 请求失败 %s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// bucketURL 返回存储桶的访问地址
+func (s *s3Store) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.cfg.UsePathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/"
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+	return &u
+}
+
+// objectURL 返回对象的访问地址
+func (s *s3Store) objectURL(key string) (*url.URL, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u := s.bucketURL()
+	u.Path += s.fullKey(cleaned)
+	return u, nil
+}
+
+// keyPrefix 返回配置的对象键前缀，非空时以 / 结尾
+func (s *s3Store) keyPrefix() string {
+	prefix := strings.Trim(s.cfg.Prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// fullKey 返回带前缀的完整对象键
+func (s *s3Store) fullKey(key string) string {
+	return s.keyPrefix() + key
+}
+
+// blobInfoFromHeader 根据响应头构造对象元信息
+func blobInfoFromHeader(key string, resp *http.Response) BlobInfo {
+	info := BlobInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if info.Size < 0 {
+		info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	}
+	if info.ContentType == "" {
+		info.ContentType = ContentTypeByExtension(path.Ext(key))
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
@@ -0,0 +1,280 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/ImageSearch/internal/storage"
+	"github.com/bytedance/ImageSearch/internal/storage/s3test"
+)
+
+// newTestS3Store 启动模拟 S3 服务并创建连接它的存储
+func newTestS3Store(t *testing.T, prefix string) (storage.BlobStore, *s3test.Server) {
+	t.Helper()
+	server := s3test.NewServer("images")
+	t.Cleanup(server.Close)
+
+	cfg := server.Config()
+	cfg.Prefix = prefix
+	store, err := storage.NewS3Store(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return store, server
+}
+
+// readBlob 读取对象内容
+func readBlob(t *testing.T, store storage.BlobStore, key string) ([]byte, storage.BlobInfo) {
+	t.Helper()
+	r, info, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("读取 %q: %v", key, err)
+	}
+	return data, info
+}
+
+func TestS3StorePutGetStatDelete(t *testing.T) {
+	store, server := newTestS3Store(t, "prefix")
+
+	tests := []struct {
+		name        string
+		key         string
+		data        string
+		size        int64
+		contentType string
+		wantType    string
+	}{
+		{name: "已知长度", key: "images/a.jpg", data: "jpeg data", size: 9, contentType: "image/jpeg", wantType: "image/jpeg"},
+		{name: "未知长度", key: "images/b.png", data: "png data", size: -1, contentType: "image/png", wantType: "image/png"},
+		{name: "需要编码的键", key: "images/a b+c$.jpg", data: "escaped", size: 7, contentType: "image/jpeg", wantType: "image/jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Put(tt.key, strings.NewReader(tt.data), tt.size, tt.contentType); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			data, info := readBlob(t, store, tt.key)
+			if string(data) != tt.data {
+				t.Errorf("Get 内容 = %q, want %q", data, tt.data)
+			}
+			if info.Key != tt.key || info.Size != int64(len(tt.data)) || info.ContentType != tt.wantType {
+				t.Errorf("Get 元信息 = %+v", info)
+			}
+
+			stat, err := store.Stat(tt.key)
+			if err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if stat.Size != int64(len(tt.data)) || stat.ContentType != tt.wantType || stat.ModTime.IsZero() {
+				t.Errorf("Stat = %+v", stat)
+			}
+
+			if err := store.Delete(tt.key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := store.Stat(tt.key); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("删除后 Stat 错误 = %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("删除后仍有对象: %v", keys)
+	}
+}
+
+func TestS3StoreKeyPrefix(t *testing.T) {
+	store, server := newTestS3Store(t, "/tenant/")
+
+	if err := store.Put("images/a.jpg", strings.NewReader("a"), 1, "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if keys := server.Keys(); !reflect.DeepEqual(keys, []string{"tenant/images/a.jpg"}) {
+		t.Errorf("服务端对象键 = %v", keys)
+	}
+}
+
+func TestS3StoreNotFound(t *testing.T) {
+	store, _ := newTestS3Store(t, "")
+
+	if _, _, err := store.Get("images/missing.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get 错误 = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stat("images/missing.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Stat 错误 = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("images/missing.jpg"); err != nil {
+		t.Errorf("删除不存在的对象: %v", err)
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	store, _ := newTestS3Store(t, "")
+
+	for _, key := range []string{"", "../a.jpg", "images/../../a.jpg"} {
+		if err := store.Put(key, strings.NewReader("a"), 1, ""); err == nil {
+			t.Errorf("Put(%q) 应该失败", key)
+		}
+	}
+}
+
+func TestS3StoreList(t *testing.T) {
+	store, server := newTestS3Store(t, "prefix")
+	// 调小单页数量，覆盖 continuation-token 分页
+	server.MaxKeys = 3
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("images/%02d.jpg", i)
+		want = append(want, key)
+		if err := store.Put(key, strings.NewReader(key), int64(len(key)), "image/jpeg"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := store.Put("renditions/00.webp", strings.NewReader("x"), 1, "image/webp"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var got []string
+	err := store.List("images/", func(info storage.BlobInfo) error {
+		if info.Size != int64(len(info.Key)) || info.ContentType != "image/jpeg" || info.ModTime.IsZero() {
+			t.Errorf("List 元信息 = %+v", info)
+		}
+		got = append(got, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+
+	// 回调返回错误时停止遍历并返回该错误
+	stop := errors.New("stop")
+	count := 0
+	err = store.List("", func(storage.BlobInfo) error {
+		count++
+		if count == 4 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 4 {
+		t.Errorf("List 错误 = %v, 回调次数 = %d", err, count)
+	}
+}
+
+func TestS3StoreSignatureMismatch(t *testing.T) {
+	server := s3test.NewServer("images")
+	defer server.Close()
+
+	cfg := server.Config()
+	cfg.SecretAccessKey = "wrong-secret"
+	store, err := storage.NewS3Store(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+
+	err = store.Put("images/a.jpg", strings.NewReader("a"), 1, "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put 错误 = %v, want SignatureDoesNotMatch", err)
+	}
+}
+
+func TestS3StoreGetRange(t *testing.T) {
+	store, _ := newTestS3Store(t, "")
+	if err := store.Put("images/a.jpg", strings.NewReader("0123456789"), 10, "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	ranger, ok := store.(storage.RangeReader)
+	if !ok {
+		t.Fatal("S3 存储没有实现 RangeReader")
+	}
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "0123"},
+		{3, -1, "3456789"},
+		{8, 10, "89"},
+		{10, 5, ""},
+		{2, 0, ""},
+	}
+	for _, tt := range tests {
+		r, err := ranger.GetRange("images/a.jpg", tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("GetRange(%d, %d): %v", tt.offset, tt.length, err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != tt.want {
+			t.Errorf("GetRange(%d, %d) = %q, %v, want %q", tt.offset, tt.length, data, err, tt.want)
+		}
+	}
+
+	if _, err := ranger.GetRange("images/missing.jpg", 0, 4); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetRange 不存在的对象错误 = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3StoreEncrypted(t *testing.T) {
+	inner, _ := newTestS3Store(t, "")
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("k1 "+base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := storage.LoadKeyring(keyFile, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	store := storage.NewEncryptedStore(inner, keyring)
+
+	data := bytes.Repeat([]byte("encrypted s3 object "), 10000)
+	if err := store.Put("images/a.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	stat, err := store.Stat("images/a.jpg")
+	if err != nil || stat.Size != int64(len(data)) {
+		t.Errorf("Stat = %+v, %v", stat, err)
+	}
+
+	r, info, err := store.Get("images/a.jpg")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Close()
+	if info.Size != int64(len(data)) {
+		t.Errorf("Get 长度 = %d", info.Size)
+	}
+	// 通过 Range 请求从中间开始解密
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("加密的 S3 对象没有实现 io.Seeker")
+	}
+	offset := int64(len(data) - 1000)
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(seeker)
+	if err != nil || !bytes.Equal(rest, data[offset:]) {
+		t.Errorf("从 %d 开始读取的内容不一致: %v", offset, err)
+	}
+}
@@ -0,0 +1,318 @@
+// Package s3test 提供进程内的 S3 兼容服务，用于在没有真实对象存储的环境中测试 S3 存储后端
+package s3test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/ImageSearch/internal/config"
+	"github.com/bytedance/ImageSearch/internal/storage"
+)
+
+const (
+	// defaultRegion 模拟服务使用的区域
+	defaultRegion = "us-east-1"
+	// maxKeys ListObjectsV2 单页返回的最大数量
+	maxKeys = 1000
+	// amzDateFormat X-Amz-Date 时间格式
+	amzDateFormat = "20060102T150405Z"
+)
+
+// object 存储在内存中的对象
+type object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// Server 进程内的 S3 兼容服务，只支持路径风格访问，会校验请求的 Signature Version 4 签名
+type Server struct {
+	*httptest.Server
+
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	// MaxKeys ListObjectsV2 单页返回的最大数量，测试分页时可以调小
+	MaxKeys int
+
+	mu      sync.RWMutex
+	objects map[string]object
+}
+
+// NewServer 启动一个模拟 S3 服务，调用方负责调用 Close
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:          bucket,
+		AccessKeyID:     "s3test-access-key",
+		SecretAccessKey: "s3test-secret-key",
+		Region:          defaultRegion,
+		MaxKeys:         maxKeys,
+		objects:         make(map[string]object),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Config 返回连接该模拟服务的 S3 配置
+func (s *Server) Config() config.S3Config {
+	return config.S3Config{
+		Endpoint:        s.URL,
+		Region:          s.Region,
+		Bucket:          s.Bucket,
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+		UsePathStyle:    true,
+	}
+}
+
+// Keys 返回当前存储的所有对象键，按字典序排列
+func (s *Server) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handle 处理 S3 REST 请求
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(r) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", "签名校验失败")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "存储桶不存在")
+		return
+	}
+
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, r)
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "不支持的存储桶操作")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r, key)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, key)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "不支持的请求方法")
+	}
+}
+
+// put 写入对象，要求请求带 Content-Length
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	if r.ContentLength < 0 {
+		writeError(w, http.StatusLengthRequired, "MissingContentLength", "缺少 Content-Length")
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.objects[key] = object{
+		data:        data,
+		contentType: r.Header.Get("Content-Type"),
+		modTime:     time.Now().UTC().Truncate(time.Second),
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// get 读取对象或对象元信息
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "对象不存在")
+		return
+	}
+
+	// 与 S3 一致，写入时没有指定类型的对象返回 binary/octet-stream
+	contentType := obj.contentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+
+	data, status := obj.data, http.StatusOK
+	if header := r.Header.Get("Range"); header != "" && r.Method == http.MethodGet {
+		start, end, ok := parseRange(header, len(obj.data))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "请求的范围无效")
+			return
+		}
+		data, status = obj.data[start:end], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(obj.data)))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parseRange 解析 bytes=start-end 或 bytes=start- 形式的 Range 请求头，返回 [start, end) 范围
+// 与 S3 一致，end 超出对象长度时截断到对象末尾，start 超出对象长度时返回 false
+func parseRange(header string, size int) (int, int, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size
+	if last != "" {
+		v, err := strconv.Atoi(last)
+		if err != nil || v < start {
+			return 0, 0, false
+		}
+		if v+1 < end {
+			end = v + 1
+		}
+	}
+	return start, end, true
+}
+
+// listResult ListObjectsV2 响应
+type listResult struct {
+	XMLName               xml.Name      `xml:"ListBucketResult"`
+	Name                  string        `xml:"Name"`
+	Prefix                string        `xml:"Prefix"`
+	KeyCount              int           `xml:"KeyCount"`
+	IsTruncated           bool          `xml:"IsTruncated"`
+	NextContinuationToken string        `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent `xml:"Contents"`
+}
+
+// listContent ListObjectsV2 响应中的对象
+type listContent struct {
+	Key          string `xml:"Key"`
+	Size         int    `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+// list 按前缀分页列出对象，continuation-token 为上一页最后一个对象键
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("continuation-token")
+	limit := s.MaxKeys
+	if v, err := strconv.Atoi(query.Get("max-keys")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+
+	result := listResult{Name: s.Bucket, Prefix: prefix}
+	keys := s.Keys()
+	s.mu.RLock()
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		obj, ok := s.objects[key]
+		if !ok {
+			continue
+		}
+		if len(result.Contents) == limit {
+			result.IsTruncated = true
+			result.NextContinuationToken = result.Contents[len(result.Contents)-1].Key
+			break
+		}
+		result.Contents = append(result.Contents, listContent{
+			Key:          key,
+			Size:         len(obj.data),
+			LastModified: obj.modTime.Format(time.RFC3339),
+		})
+	}
+	s.mu.RUnlock()
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verifySignature 使用相同的密钥重新签名收到的请求，并与请求中的签名比较
+func (s *Server) verifySignature(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") || !strings.Contains(auth, "Credential="+s.AccessKeyID+"/") {
+		return false
+	}
+	signedAt, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	clone, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	for name, values := range r.Header {
+		if name != "Authorization" {
+			clone.Header[name] = values
+		}
+	}
+
+	signer := storage.V4Signer{
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+		Region:          s.Region,
+		Service:         "s3",
+	}
+	signer.Sign(clone, r.Header.Get("X-Amz-Content-Sha256"), signedAt)
+	return clone.Header.Get("Authorization") == auth
+}
+
+// writeError 返回 S3 风格的 XML 错误
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	xml.NewEncoder(&buf).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
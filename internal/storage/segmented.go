@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 分段加密格式：加密头（segmentedMagic、密钥ID长度、密钥ID、8 字节随机数前缀）之后是若干段密文
+// 每段最多 segmentSize 字节明文，使用随机数前缀和段序号组成的 nonce 单独加密，并带有自己的认证标签
+// 附加数据为加密头、对象键和是否为最后一段的标记，段被调换、截断或拼接到其他对象时解密失败
+// 每段可以单独解密，写入和读取对象时不需要把整个对象放在内存中，也可以从任意一段开始读取
+
+// segmentedMagic 分段加密数据的前缀
+var segmentedMagic = []byte{'I', 'S', 'E', 2}
+
+const (
+	// segmentSize 每段明文的长度，最后一段可以更短
+	segmentSize = 64 * 1024
+	// sealedSegmentSize 每段密文的长度
+	sealedSegmentSize = segmentSize + tagSize
+	// noncePrefixSize 加密头中随机数前缀的长度，nonce 的其余 4 字节为段序号
+	noncePrefixSize = 8
+)
+
+// segmentCipher 加密或解密一个对象的所有段
+type segmentCipher struct {
+	aead   cipher.AEAD
+	header []byte
+	aad    []byte
+}
+
+// newSegmentSealer 生成使用当前密钥分段加密的加密头，aad 为需要一并认证的附加数据（如对象键）
+func (k *Keyring) newSegmentSealer(aad []byte) (*segmentCipher, error) {
+	header := make([]byte, 0, maxHeaderSize)
+	header = append(header, segmentedMagic...)
+	header = append(header, byte(len(k.active)))
+	header = append(header, k.active...)
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %w", err)
+	}
+	header = append(header, prefix...)
+	return &segmentCipher{aead: k.keys[k.active], header: header, aad: aad}, nil
+}
+
+// segmentOpener 按加密头中记录的密钥ID返回解密各段使用的 segmentCipher
+func (k *Keyring) segmentOpener(header, aad []byte) (*segmentCipher, error) {
+	keyID, headerLen, err := splitHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if headerLen != len(header) || !bytes.HasPrefix(header, segmentedMagic) {
+		return nil, ErrDecrypt
+	}
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return &segmentCipher{aead: aead, header: header, aad: aad}, nil
+}
+
+// nonce 返回第 index 段使用的 nonce
+func (c *segmentCipher) nonce(index int64) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, c.header[len(c.header)-noncePrefixSize:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(index))
+	return nonce
+}
+
+// additionalData 返回一段的附加数据
+func (c *segmentCipher) additionalData(last bool) []byte {
+	data := make([]byte, 0, len(c.header)+len(c.aad)+1)
+	data = append(data, c.header...)
+	data = append(data, c.aad...)
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// seal 加密第 index 段，结果追加到 dst
+func (c *segmentCipher) seal(dst, plaintext []byte, index int64, last bool) []byte {
+	return c.aead.Seal(dst, c.nonce(index), plaintext, c.additionalData(last))
+}
+
+// open 解密第 index 段，结果追加到 dst
+func (c *segmentCipher) open(dst, ciphertext []byte, index int64, last bool) ([]byte, error) {
+	plaintext, err := c.aead.Open(dst, c.nonce(index), ciphertext, c.additionalData(last))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// sealedSize 返回明文长度为 size 的数据分段加密后的长度，空数据同样有一段只包含认证标签的密文
+func sealedSize(headerLen int, size int64) int64 {
+	segments := (size + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(headerLen) + size + segments*tagSize
+}
+
+// openedSize 返回分段加密后长度为 size 的数据的明文长度和段数，长度不可能由分段加密生成时返回 ErrDecrypt
+func openedSize(headerLen int, size int64) (int64, int64, error) {
+	body := size - int64(headerLen)
+	if body < tagSize {
+		return 0, 0, ErrDecrypt
+	}
+	segments := (body + sealedSegmentSize - 1) / sealedSegmentSize
+	// 只有空数据的最后一段不包含明文
+	if last := body - (segments-1)*sealedSegmentSize; last == tagSize && segments > 1 {
+		return 0, 0, ErrDecrypt
+	}
+	return body - segments*tagSize, segments, nil
+}
+
+// sealingReader 读取时逐段加密明文，先输出加密头，再输出各段密文
+type sealingReader struct {
+	src    *bufio.Reader
+	cipher *segmentCipher
+	index  int64
+	plain  []byte
+	sealed []byte
+	// pending 尚未输出的数据
+	pending []byte
+	done    bool
+}
+
+// newSealingReader 创建逐段加密 src 的 Reader
+func newSealingReader(src io.Reader, c *segmentCipher) *sealingReader {
+	return &sealingReader{
+		src:     bufio.NewReaderSize(src, segmentSize),
+		cipher:  c,
+		plain:   make([]byte, segmentSize),
+		sealed:  make([]byte, 0, sealedSegmentSize),
+		pending: c.header,
+	}
+}
+
+// Read 实现 io.Reader
+func (r *sealingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next 读取并加密下一段，读满一段后再看是否还有数据，以确定这一段是否为最后一段
+func (r *sealingReader) next() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	last := n < segmentSize
+	if !last {
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if r.index > math.MaxUint32 {
+		return errors.New("对象过大，无法加密")
+	}
+
+	r.pending = r.cipher.seal(r.sealed[:0], r.plain[:n], r.index, last)
+	r.index++
+	r.done = last
+	return nil
+}
+
+// openingReader 逐段解密分段加密的数据
+type openingReader struct {
+	cipher    *segmentCipher
+	headerLen int64
+	// sealedLen 密文（包括加密头）的总长度
+	sealedLen int64
+	size      int64
+	segments  int64
+
+	// src 底层数据，下一次读取的位置是第 next 段的开头
+	src  io.ReadCloser
+	next int64
+	// reopen 从密文中的指定位置重新读取，为 nil 时只能顺序读取
+	reopen func(offset int64) (io.ReadCloser, error)
+
+	pos         int64
+	sealed      []byte
+	plain       []byte
+	plainIndex  int64
+	plainLoaded bool
+}
+
+// newOpeningReader 创建逐段解密的 Reader，src 的读取位置必须在加密头之后
+func newOpeningReader(src io.ReadCloser, c *segmentCipher, sealedLen int64) (*openingReader, error) {
+	headerLen := len(c.header)
+	size, segments, err := openedSize(headerLen, sealedLen)
+	if err != nil {
+		return nil, err
+	}
+	return &openingReader{
+		cipher:    c,
+		headerLen: int64(headerLen),
+		sealedLen: sealedLen,
+		size:      size,
+		segments:  segments,
+		src:       src,
+		sealed:    make([]byte, sealedSegmentSize),
+		plain:     make([]byte, 0, segmentSize),
+	}, nil
+}
+
+// Read 实现 io.Reader
+func (r *openingReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	index := r.pos / segmentSize
+	if !r.plainLoaded || index != r.plainIndex {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos-index*segmentSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// load 读取并解密第 index 段
+func (r *openingReader) load(index int64) error {
+	if index != r.next {
+		if r.reopen == nil {
+			return errors.New("分段加密的数据不支持随机读取")
+		}
+		src, err := r.reopen(r.headerLen + index*sealedSegmentSize)
+		if err != nil {
+			return err
+		}
+		if src != r.src {
+			r.src.Close()
+			r.src = src
+		}
+		r.next = index
+	}
+
+	last := index == r.segments-1
+	length := int64(sealedSegmentSize)
+	if last {
+		length = r.sealedLen - r.headerLen - index*sealedSegmentSize
+	}
+	if _, err := io.ReadFull(r.src, r.sealed[:length]); err != nil {
+		return fmt.Errorf("读取第 %d 段密文失败: %w", index, err)
+	}
+	r.next = index + 1
+	// 解密失败时不保留上一段的明文
+	r.plainLoaded = false
+
+	plain, err := r.cipher.open(r.plain[:0], r.sealed[:length], index, last)
+	if err != nil {
+		return err
+	}
+	r.plain = plain
+	r.plainIndex = index
+	r.plainLoaded = true
+	return nil
+}
+
+// Close 关闭底层数据
+func (r *openingReader) Close() error {
+	return r.src.Close()
+}
+
+// seekableOpeningReader 底层数据可以随机读取时，支持从任意位置开始解密
+type seekableOpeningReader struct {
+	*openingReader
+}
+
+// Seek 实现 io.Seeker，只移动读取位置，实际读取时再定位到所在的段
+func (r seekableOpeningReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("无效的 whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("读取位置不能为负数")
+	}
+	r.pos = offset
+	return offset, nil
+}
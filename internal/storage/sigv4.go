@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// sigV4Algorithm 签名算法名称
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	// UnsignedPayload 不对请求体计算哈希，用于流式上传
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// amzDateFormat X-Amz-Date 时间格式
+	amzDateFormat = "20060102T150405Z"
+)
+
+// emptyPayloadHash 空请求体的 SHA-256
+var emptyPayloadHash = sha256Hex(nil)
+
+// V4Signer 使用 AWS Signature Version 4 对 HTTP 请求签名
+type V4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	Service         string
+}
+
+// Sign 为请求设置 X-Amz-Date、X-Amz-Content-Sha256 和 Authorization 请求头
+// payloadHash 为请求体的十六进制 SHA-256，流式上传时可以使用 UnsignedPayload
+func (s V4Signer) Sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	// 请求路径按签名时的编码方式发送，保证服务端计算出相同的规范请求
+	req.URL.RawPath = canonicalURI(req.URL.Path)
+
+	signedHeaders, canonicalHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalURI 对路径的每一段做 URI 编码
+func canonicalURI(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery 按参数名排序并编码查询字符串
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalHeaders 返回参与签名的请求头名称列表和规范化后的请求头
+// 只对 host、content-type 和 x-amz-* 请求头签名，避免传输过程中被改写的请求头导致签名失败
+func canonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{
+		"host": req.Host,
+	}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(name)
+		builder.WriteByte(':')
+		builder.WriteString(headers[name])
+		builder.WriteByte('\n')
+	}
+	return strings.Join(names, ";"), builder.String()
+}
+
+// uriEncode 按 SigV4 规则编码，只保留 RFC 3986 中的非保留字符
+func uriEncode(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			builder.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&builder, "%%%02X", c)
+	}
+	return builder.String()
+}
+
+// hmacSHA256 计算 HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sha256Hex 计算十六进制 SHA-256
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}